@@ -1,17 +1,20 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/giwty/switch-library-manager/db"
 	"github.com/giwty/switch-library-manager/process"
 	"github.com/giwty/switch-library-manager/settings"
+	"github.com/giwty/switch-library-manager/switchfs"
 	"github.com/jedib0t/go-pretty/table"
 	"github.com/schollz/progressbar/v3"
 	"go.uber.org/zap"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -19,6 +22,10 @@ var (
 	nspFolder   = flag.String("f", "", "path to NSP folder")
 	recursive   = flag.Bool("r", true, "recursively scan sub folders")
 	mode        = flag.String("m", "", "**deprecated**")
+	keepSource  = flag.Bool("k", false, "keep the source file after converting it (compress/decompress/split/merge/fat32/join)")
+	mergeToXci  = flag.Bool("x", false, "create an XCI instead of an NSP (merge)")
+	splitLayout = flag.String("layout", "folder", "layout of the split files - folder (name.nsp/00) or flat (name.nsp.00) (fat32)")
+	modsFolder  = flag.String("mods", "", "path to the mods library holding contents/<titleid> (mods/exportmods)")
 	progressBar *progressbar.ProgressBar
 )
 
@@ -40,6 +47,11 @@ func (c *Console) Start() {
 
 	settingsObj := settings.ReadSettings(c.baseFolder)
 
+	if flag.NArg() != 0 {
+		c.runCommand(settingsObj, flag.Arg(0), flag.Args()[1:])
+		return
+	}
+
 	//1. load the titles JSON object
 	fmt.Printf("Downlading latest switch titles json file")
 	progressBar = progressbar.New(2)
@@ -75,10 +87,7 @@ func (c *Console) Start() {
 	titlesDB, err := db.CreateSwitchTitleDB(titleFile, versionsFile)
 
 	//5. read local files
-	folderToScan := settingsObj.Folder
-	if nspFolder != nil && *nspFolder != "" {
-		folderToScan = *nspFolder
-	}
+	folderToScan := c.getFolderToScan(settingsObj)
 
 	if folderToScan == "" {
 		fmt.Printf("\n\nNo folder to scan was defined, please edit settings.json with the folder path\n")
@@ -90,6 +99,9 @@ func (c *Console) Start() {
 	if keys == nil || keys.GetKey("header_key") == "" {
 		fmt.Printf("\n!!NOTE!!: keys file was not found, deep scan is disabled, library will be based on file tags.\n %v", err)
 	}
+	if keys != nil && len(keys.DerivedKeys()) != 0 {
+		fmt.Printf("\nDerived %v missing keys from the master keys", len(keys.DerivedKeys()))
+	}
 
 	recursiveMode := settingsObj.ScanRecursively
 	if recursive != nil && *recursive != true {
@@ -146,6 +158,638 @@ func (c *Console) Start() {
 	fmt.Printf("Completed")
 }
 
+func (c *Console) getFolderToScan(settingsObj *settings.AppSettings) string {
+	folderToScan := settingsObj.Folder
+	if nspFolder != nil && *nspFolder != "" {
+		folderToScan = *nspFolder
+	}
+	return folderToScan
+}
+
+func (c *Console) runCommand(settingsObj *settings.AppSettings, command string, args []string) {
+	switch command {
+	case "keys":
+		c.processKeys(settingsObj, args)
+	case "decompress":
+		c.processConvert(settingsObj, args, false)
+	case "compress":
+		c.processConvert(settingsObj, args, true)
+	case "verify":
+		c.processVerify(settingsObj)
+	case "xci2nsp":
+		c.processXciConvert(settingsObj, args)
+	case "split":
+		c.processSplit(settingsObj, args)
+	case "merge":
+		c.processMerge(settingsObj, args)
+	case "fat32":
+		c.processSplitFiles(settingsObj, args, true)
+	case "join":
+		c.processSplitFiles(settingsObj, args, false)
+	case "trim":
+		c.processTrim(settingsObj, args, true)
+	case "untrim":
+		c.processTrim(settingsObj, args, false)
+	case "firmware":
+		c.processFirmware(settingsObj)
+	case "mods":
+		c.processMods(settingsObj, nil, false)
+	case "exportmods":
+		c.processMods(settingsObj, args, true)
+	default:
+		fmt.Printf("unknown command [%v], supported commands: keys, compress, decompress, verify, xci2nsp, split, merge, fat32, join, trim, untrim, firmware, mods, exportmods\n", command)
+	}
+}
+
+// loadLocalLibrary loads the local library (from the cache if available)
+func (c *Console) loadLocalLibrary(settingsObj *settings.AppSettings) (*db.LocalSwitchDBManager, *db.LocalSwitchFilesDB, error) {
+	folderToScan := c.getFolderToScan(settingsObj)
+	if folderToScan == "" {
+		return nil, nil, errors.New("no folder to scan was defined, please edit settings.json with the folder path")
+	}
+	localDbManager, err := db.NewLocalSwitchDBManager(c.baseFolder)
+	if err != nil {
+		return nil, nil, err
+	}
+	recursiveMode := settingsObj.ScanRecursively
+	if recursive != nil && *recursive != true {
+		recursiveMode = *recursive
+	}
+	fmt.Printf("\nScanning folder [%v]", folderToScan)
+	progressBar = progressbar.New(2000)
+	localDB, err := localDbManager.CreateLocalSwitchFilesDB(append(settingsObj.ScanFolders, folderToScan), c, recursiveMode, false)
+	progressBar.Finish()
+	if err != nil {
+		localDbManager.Close()
+		return nil, nil, err
+	}
+	return localDbManager, localDB, nil
+}
+
+// processXciConvert creates an NSP for every title in the given XCI files, all the library is converted
+// when no file is given
+func (c *Console) processXciConvert(settingsObj *settings.AppSettings, args []string) {
+	keys, _ := settings.InitSwitchKeys(c.baseFolder)
+	if keys == nil || keys.GetKey("header_key") == "" {
+		fmt.Printf("\nkeys file was not found, XCI files can't be converted\n")
+		return
+	}
+	var localDbManager *db.LocalSwitchDBManager
+	var localDB *db.LocalSwitchFilesDB
+	if len(args) == 0 || c.getFolderToScan(settingsObj) != "" {
+		var err error
+		localDbManager, localDB, err = c.loadLocalLibrary(settingsObj)
+		if err != nil {
+			fmt.Printf("\nfailed to load the local library - %v\n", err)
+			return
+		}
+		defer localDbManager.Close()
+	}
+
+	if len(args) == 0 {
+		fmt.Printf("\nConverting all XCI/XCZ files in the library\n")
+		progressBar = progressbar.New(2000)
+		converted, err := process.ConvertXciLibrary(localDbManager, localDB, c)
+		progressBar.Finish()
+		fmt.Printf("\nCreated %v files\n", converted)
+		if err != nil {
+			fmt.Printf("some files failed to convert, last error - %v\n", err)
+		}
+		return
+	}
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Printf("\n%v\n", err)
+			continue
+		}
+		absPath, _ := filepath.Abs(arg)
+		file := db.ExtendedFileInfo{FileName: info.Name(), BaseFolder: filepath.Dir(absPath) + string(os.PathSeparator), Size: info.Size()}
+		fmt.Printf("\nConverting [%v]\n", arg)
+		progressBar = progressbar.New(2000)
+		newFiles, err := process.ConvertXciToNsp(localDbManager, localDB, file, c)
+		progressBar.Finish()
+		for _, newFile := range newFiles {
+			fmt.Printf("\nCreated [%v]\n", filepath.Join(newFile.BaseFolder, newFile.FileName))
+		}
+		if err != nil {
+			fmt.Printf("\nfailed to convert [%v] - %v\n", arg, err)
+		}
+	}
+}
+
+// processSplit splits multi-content NSP files into an NSP per title, all the library is split when no file is given
+func (c *Console) processSplit(settingsObj *settings.AppSettings, args []string) {
+	keys, _ := settings.InitSwitchKeys(c.baseFolder)
+	if keys == nil || keys.GetKey("header_key") == "" {
+		fmt.Printf("\nkeys file was not found, NSP files can't be split\n")
+		return
+	}
+	var localDbManager *db.LocalSwitchDBManager
+	var localDB *db.LocalSwitchFilesDB
+	if len(args) == 0 || c.getFolderToScan(settingsObj) != "" {
+		var err error
+		localDbManager, localDB, err = c.loadLocalLibrary(settingsObj)
+		if err != nil {
+			fmt.Printf("\nfailed to load the local library - %v\n", err)
+			return
+		}
+		defer localDbManager.Close()
+	}
+
+	if len(args) == 0 {
+		fmt.Printf("\nSplitting all multi-content NSP files in the library\n")
+		progressBar = progressbar.New(2000)
+		split, err := process.SplitLibrary(localDbManager, localDB, *keepSource, c)
+		progressBar.Finish()
+		fmt.Printf("\nSplit %v files\n", split)
+		if err != nil {
+			fmt.Printf("some files failed to split, last error - %v\n", err)
+		}
+		return
+	}
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Printf("\n%v\n", err)
+			continue
+		}
+		absPath, _ := filepath.Abs(arg)
+		file := db.ExtendedFileInfo{FileName: info.Name(), BaseFolder: filepath.Dir(absPath) + string(os.PathSeparator), Size: info.Size()}
+		fmt.Printf("\nSplitting [%v]\n", arg)
+		progressBar = progressbar.New(2000)
+		newFiles, err := process.SplitNsp(localDbManager, localDB, file, *keepSource, c)
+		progressBar.Finish()
+		if err != nil {
+			fmt.Printf("\nfailed to split [%v] - %v\n", arg, err)
+			continue
+		}
+		for _, newFile := range newFiles {
+			fmt.Printf("\nCreated [%v]\n", filepath.Join(newFile.BaseFolder, newFile.FileName))
+		}
+	}
+}
+
+// processMerge bundles the base, the latest update and the DLCs of the given titles into a single file,
+// all the titles spread over more than one file are merged when no title id is given
+func (c *Console) processMerge(settingsObj *settings.AppSettings, args []string) {
+	keys, _ := settings.InitSwitchKeys(c.baseFolder)
+	if keys == nil || keys.GetKey("header_key") == "" {
+		fmt.Printf("\nkeys file was not found, titles can't be merged\n")
+		return
+	}
+	localDbManager, localDB, err := c.loadLocalLibrary(settingsObj)
+	if err != nil {
+		fmt.Printf("\nfailed to load the local library - %v\n", err)
+		return
+	}
+	defer localDbManager.Close()
+
+	if len(args) == 0 {
+		fmt.Printf("\nMerging all the titles in the library\n")
+		progressBar = progressbar.New(2000)
+		merged, err := process.MergeLibrary(localDbManager, localDB, nil, *mergeToXci, *keepSource, c)
+		progressBar.Finish()
+		fmt.Printf("\nMerged %v titles\n", merged)
+		if err != nil {
+			fmt.Printf("some titles failed to merge, last error - %v\n", err)
+		}
+		return
+	}
+
+	for _, titleId := range args {
+		fmt.Printf("\nMerging [%v]\n", titleId)
+		progressBar = progressbar.New(2000)
+		newFile, err := process.MergeTitle(localDbManager, localDB, nil, titleId, *mergeToXci, *keepSource, c)
+		progressBar.Finish()
+		if err != nil {
+			fmt.Printf("\nfailed to merge [%v] - %v\n", titleId, err)
+			continue
+		}
+		fmt.Printf("\nCreated [%v]\n", filepath.Join(newFile.BaseFolder, newFile.FileName))
+	}
+}
+
+// processSplitFiles splits files to FAT32 compatible parts (or joins split files), all the library is processed
+// when no file is given
+func (c *Console) processSplitFiles(settingsObj *settings.AppSettings, args []string, split bool) {
+	layout := switchfs.SplitLayout_Folder
+	switch *splitLayout {
+	case "folder":
+	case "flat":
+		layout = switchfs.SplitLayout_Flat
+	default:
+		fmt.Printf("\nunknown layout [%v], supported layouts: folder, flat\n", *splitLayout)
+		return
+	}
+	keys, _ := settings.InitSwitchKeys(c.baseFolder)
+	if keys == nil || keys.GetKey("header_key") == "" {
+		fmt.Printf("\nkeys file was not found, the files can't be verified\n")
+		return
+	}
+	var localDbManager *db.LocalSwitchDBManager
+	var localDB *db.LocalSwitchFilesDB
+	if len(args) == 0 || c.getFolderToScan(settingsObj) != "" {
+		var err error
+		localDbManager, localDB, err = c.loadLocalLibrary(settingsObj)
+		if err != nil {
+			fmt.Printf("\nfailed to load the local library - %v\n", err)
+			return
+		}
+		defer localDbManager.Close()
+	}
+
+	if len(args) == 0 {
+		var processed int
+		var err error
+		progressBar = progressbar.New(2000)
+		if split {
+			fmt.Printf("\nSplitting all the files larger than 4GB in the library\n")
+			processed, err = process.SplitLibraryForFat32(localDbManager, localDB, layout, *keepSource, c)
+		} else {
+			fmt.Printf("\nJoining all the split files in the library\n")
+			processed, err = process.JoinLibrary(localDbManager, localDB, *keepSource, c)
+		}
+		progressBar.Finish()
+		fmt.Printf("\nProcessed %v files\n", processed)
+		if err != nil {
+			fmt.Printf("some files failed, last error - %v\n", err)
+		}
+		return
+	}
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Printf("\n%v\n", err)
+			continue
+		}
+		absPath, _ := filepath.Abs(arg)
+		if info.IsDir() {
+			//the folder layout can be given as the folder holding the parts
+			absPath = switchfs.GetSplitFilePartPath(absPath, switchfs.SplitLayout_Folder, 0)
+			if info, err = os.Stat(absPath); err != nil {
+				fmt.Printf("\n%v\n", err)
+				continue
+			}
+		}
+		file := db.ExtendedFileInfo{FileName: info.Name(), BaseFolder: filepath.Dir(absPath) + string(os.PathSeparator), Size: info.Size()}
+		var newFile *db.ExtendedFileInfo
+		progressBar = progressbar.New(2000)
+		if split {
+			fmt.Printf("\nSplitting [%v]\n", arg)
+			newFile, err = process.SplitFileForFat32(localDbManager, localDB, file, layout, *keepSource, c)
+		} else {
+			fmt.Printf("\nJoining [%v]\n", arg)
+			newFile, err = process.JoinSplitFile(localDbManager, localDB, file, *keepSource, c)
+		}
+		progressBar.Finish()
+		if err != nil {
+			fmt.Printf("\nfailed to process [%v] - %v\n", arg, err)
+			continue
+		}
+		fmt.Printf("\nCreated [%v]\n", filepath.Join(newFile.BaseFolder, newFile.FileName))
+	}
+}
+
+// processTrim removes the padding of XCI files (or restores it), all the library is processed when no file is given
+func (c *Console) processTrim(settingsObj *settings.AppSettings, args []string, trim bool) {
+	//the keys are only needed to decrypt the extended header
+	settings.InitSwitchKeys(c.baseFolder)
+	var localDbManager *db.LocalSwitchDBManager
+	var localDB *db.LocalSwitchFilesDB
+	if len(args) == 0 || c.getFolderToScan(settingsObj) != "" {
+		var err error
+		localDbManager, localDB, err = c.loadLocalLibrary(settingsObj)
+		if err != nil {
+			fmt.Printf("\nfailed to load the local library - %v\n", err)
+			return
+		}
+		defer localDbManager.Close()
+	}
+
+	if len(args) == 0 {
+		var processed int
+		var err error
+		progressBar = progressbar.New(2000)
+		if trim {
+			fmt.Printf("\nTrimming all the XCI files in the library\n")
+			processed, err = process.TrimLibrary(localDbManager, localDB, c)
+		} else {
+			fmt.Printf("\nUntrimming all the XCI files in the library\n")
+			processed, err = process.UntrimLibrary(localDbManager, localDB, c)
+		}
+		progressBar.Finish()
+		fmt.Printf("\nProcessed %v files\n", processed)
+		if err != nil {
+			fmt.Printf("some files failed, last error - %v\n", err)
+		}
+		return
+	}
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Printf("\n%v\n", err)
+			continue
+		}
+		absPath, _ := filepath.Abs(arg)
+		c.printXciHeader(absPath)
+		file := db.ExtendedFileInfo{FileName: info.Name(), BaseFolder: filepath.Dir(absPath) + string(os.PathSeparator), Size: info.Size()}
+		var newFile *db.ExtendedFileInfo
+		progressBar = progressbar.New(2000)
+		if trim {
+			newFile, err = process.TrimXciFile(localDbManager, localDB, file, c)
+		} else {
+			newFile, err = process.UntrimXciFile(localDbManager, localDB, file, c)
+		}
+		progressBar.Finish()
+		if err != nil {
+			fmt.Printf("\nfailed to process [%v] - %v\n", arg, err)
+			continue
+		}
+		fmt.Printf("\n[%v] resized from %v to %v bytes\n", arg, file.Size, newFile.Size)
+	}
+}
+
+// printXciHeader prints the gamecard header fields and the trimmed status of the XCI
+func (c *Console) printXciHeader(filePath string) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	header, err := switchfs.ReadXciHeader(file)
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+		return
+	}
+	fmt.Printf("\n[%v]\n  package id: %016x, card size: %vGB, flags: 0x%x\n", filePath, header.PackageId, header.CardSize()>>30, header.Flags)
+	if header.ExtendedHeader != nil {
+		fmt.Printf("  card firmware version: %v, clock rate: %vMHz\n", header.ExtendedHeader.FwVersion, header.ExtendedHeader.ClockRate())
+	}
+	trimInfo, err := switchfs.GetXciTrimInfo(filePath)
+	if err != nil {
+		fmt.Printf("  %v\n", err)
+		return
+	}
+	fmt.Printf("  trimmed: %v, data size: %v, untrimmed size: %v, reclaimable: %v bytes\n", trimInfo.Trimmed, trimInfo.DataSize, trimInfo.UntrimmedSize, trimInfo.ReclaimableSize)
+}
+
+// processConvert compresses NSP/XCI files to NSZ/XCZ (or decompresses NSZ/XCZ files), all the library is converted
+// when no file is given
+func (c *Console) processConvert(settingsObj *settings.AppSettings, args []string, compress bool) {
+	keys, _ := settings.InitSwitchKeys(c.baseFolder)
+	if keys == nil || keys.GetKey("header_key") == "" {
+		fmt.Printf("\nkeys file was not found, the converted files can't be verified\n")
+		return
+	}
+	var localDbManager *db.LocalSwitchDBManager
+	var localDB *db.LocalSwitchFilesDB
+	if len(args) == 0 || c.getFolderToScan(settingsObj) != "" {
+		var err error
+		localDbManager, localDB, err = c.loadLocalLibrary(settingsObj)
+		if err != nil {
+			fmt.Printf("\nfailed to load the local library - %v\n", err)
+			return
+		}
+		defer localDbManager.Close()
+	}
+
+	if len(args) == 0 {
+		var converted int
+		var err error
+		progressBar = progressbar.New(2000)
+		if compress {
+			fmt.Printf("\nCompressing all NSP/XCI files in the library\n")
+			converted, err = process.CompressLibrary(localDbManager, localDB, settingsObj.CompressOptions, *keepSource, c)
+		} else {
+			fmt.Printf("\nDecompressing all NSZ/XCZ files in the library\n")
+			converted, err = process.DecompressLibrary(localDbManager, localDB, *keepSource, c)
+		}
+		progressBar.Finish()
+		fmt.Printf("\nConverted %v files\n", converted)
+		if err != nil {
+			fmt.Printf("some files failed to convert, last error - %v\n", err)
+		}
+		return
+	}
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Printf("\n%v\n", err)
+			continue
+		}
+		absPath, _ := filepath.Abs(arg)
+		file := db.ExtendedFileInfo{FileName: info.Name(), BaseFolder: filepath.Dir(absPath) + string(os.PathSeparator), Size: info.Size()}
+		var newFile *db.ExtendedFileInfo
+		progressBar = progressbar.New(2000)
+		if compress {
+			fmt.Printf("\nCompressing [%v]\n", arg)
+			newFile, err = process.CompressFile(localDbManager, localDB, file, settingsObj.CompressOptions, *keepSource, c)
+		} else {
+			fmt.Printf("\nDecompressing [%v]\n", arg)
+			newFile, err = process.DecompressFile(localDbManager, localDB, file, *keepSource, c)
+		}
+		progressBar.Finish()
+		if err != nil {
+			fmt.Printf("\nfailed to convert [%v] - %v\n", arg, err)
+			continue
+		}
+		fmt.Printf("\nCreated [%v] (%v MB -> %v MB)\n", filepath.Join(newFile.BaseFolder, newFile.FileName), file.Size>>20, newFile.Size>>20)
+	}
+}
+
+func (c *Console) processVerify(settingsObj *settings.AppSettings) {
+	keys, _ := settings.InitSwitchKeys(c.baseFolder)
+	if keys == nil || keys.GetKey("header_key") == "" {
+		fmt.Printf("\nkeys file was not found, files can't be verified\n")
+		return
+	}
+	localDbManager, localDB, err := c.loadLocalLibrary(settingsObj)
+	if err != nil {
+		fmt.Printf("\nfailed to load the local library - %v\n", err)
+		return
+	}
+	defer localDbManager.Close()
+
+	fmt.Printf("\nVerifying NCA hashes\n")
+	progressBar = progressbar.New(2000)
+	results := process.VerifyLibrary(localDbManager, localDB, false, c)
+	progressBar.Finish()
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.SetStyle(table.StyleColoredBright)
+	t.AppendHeader(table.Row{"#", "Corrupted file", "Issues"})
+	i := 0
+	for k, v := range results {
+		if v.Valid {
+			continue
+		}
+		t.AppendRow([]interface{}{i, path.Join(k.BaseFolder, k.FileName), strings.Join(v.Issues, "\n")})
+		i++
+	}
+	if i == 0 {
+		fmt.Printf("\nAll %v files were verified successfully\n", len(results))
+		return
+	}
+	t.AppendFooter(table.Row{"", "Total", i})
+	t.Render()
+}
+
+// processFirmware prints the firmware required by each title in the library (with its latest update),
+// and the firmware shipped on the cartridge
+func (c *Console) processFirmware(settingsObj *settings.AppSettings) {
+	localDbManager, localDB, err := c.loadLocalLibrary(settingsObj)
+	if err != nil {
+		fmt.Printf("\nfailed to load the local library - %v\n", err)
+		return
+	}
+	defer localDbManager.Close()
+
+	var titleIds []string
+	for titleId, title := range localDB.TitlesMap {
+		if title.BaseExist && title.File.Metadata != nil {
+			titleIds = append(titleIds, titleId)
+		}
+	}
+	sort.Strings(titleIds)
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.SetStyle(table.StyleColoredBright)
+	t.AppendHeader(table.Row{"#", "Title", "TitleId", "Required firmware", "Cart firmware"})
+	for i, titleId := range titleIds {
+		title := localDB.TitlesMap[titleId]
+		requiredFirmware := ""
+		if version := process.GetRequiredSystemVersion(title); version != 0 {
+			requiredFirmware = switchfs.FormatSystemVersion(version)
+		}
+		cartFirmware := ""
+		if title.File.Metadata != nil && title.File.Metadata.SystemUpdateVersion != 0 {
+			cartFirmware = switchfs.FormatSystemVersion(title.File.Metadata.SystemUpdateVersion)
+		}
+		t.AppendRow([]interface{}{i, db.ParseTitleNameFromFileName(title.File.ExtendedInfo.FileName),
+			title.File.Metadata.TitleId, requiredFirmware, cartFirmware})
+	}
+	t.AppendFooter(table.Row{"", "Total", len(titleIds)})
+	t.Render()
+}
+
+// processMods prints the cheats and LayeredFS mods of the mod library, and the local version matching each cheat,
+// or exports the mods of the given titles (all the owned titles when none is given) to an Atmosphère folder tree
+func (c *Console) processMods(settingsObj *settings.AppSettings, args []string, export bool) {
+	folder := settingsObj.ModsFolder
+	if *modsFolder != "" {
+		folder = *modsFolder
+	}
+	if folder == "" {
+		fmt.Printf("\nno mods folder was defined, please use -mods or edit settings.json with the mods folder path\n")
+		return
+	}
+	if export && len(args) == 0 {
+		fmt.Printf("\nplease provide the output folder\n")
+		return
+	}
+	localDbManager, localDB, err := c.loadLocalLibrary(settingsObj)
+	if err != nil {
+		fmt.Printf("\nfailed to load the local library - %v\n", err)
+		return
+	}
+	defer localDbManager.Close()
+	mods, err := process.ScanModLibrary(folder, localDB)
+	if err != nil {
+		fmt.Printf("\nfailed to read the mods library - %v\n", err)
+		return
+	}
+
+	if export {
+		progressBar = progressbar.New(2000)
+		exported, err := process.ExportAtmosphereMods(mods, args[1:], args[0], c)
+		progressBar.Finish()
+		fmt.Printf("\nExported the mods of %v titles to [%v]\n", exported, args[0])
+		if err != nil {
+			fmt.Printf("%v\n", err)
+		}
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.SetStyle(table.StyleColoredBright)
+	t.AppendHeader(table.Row{"#", "Title", "TitleId", "Cheat build id", "Local version", "LayeredFS"})
+	i := 0
+	for _, titleMods := range mods {
+		var layeredFs []string
+		if titleMods.RomFs {
+			layeredFs = append(layeredFs, "romfs")
+		}
+		if titleMods.ExeFs {
+			layeredFs = append(layeredFs, "exefs")
+		}
+		name := titleMods.Name
+		if !titleMods.Owned {
+			name = "(title not in the library)"
+		}
+		if len(titleMods.Cheats) == 0 {
+			t.AppendRow([]interface{}{i, name, titleMods.TitleId, "", "", strings.Join(layeredFs, ", ")})
+			i++
+			continue
+		}
+		for _, cheat := range titleMods.Cheats {
+			version := "no matching local version"
+			if cheat.Version != nil {
+				version = fmt.Sprintf("v%v", *cheat.Version)
+			}
+			t.AppendRow([]interface{}{i, name, titleMods.TitleId, cheat.BuildId, version, strings.Join(layeredFs, ", ")})
+			i++
+		}
+	}
+	t.AppendFooter(table.Row{"", "", "", "", "Total", len(mods)})
+	t.Render()
+}
+
+func (c *Console) processKeys(settingsObj *settings.AppSettings, args []string) {
+	_, err := settings.InitSwitchKeys(c.baseFolder)
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+		return
+	}
+	sampleFile := ""
+	if len(args) != 0 {
+		sampleFile = args[0]
+	} else if folder := c.getFolderToScan(settingsObj); folder != "" {
+		sampleFile = process.FindSampleFileInFolder(folder)
+	}
+	report, err := process.ValidateKeys(sampleFile)
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+		return
+	}
+
+	generations := make([]string, len(report.KeyGenerations))
+	for i, generation := range report.KeyGenerations {
+		generations[i] = fmt.Sprintf("%02x", generation)
+	}
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.SetStyle(table.StyleColoredBright)
+	t.AppendHeader(table.Row{"Check", "Result"})
+	t.AppendRow([]interface{}{"Keys file", report.Path})
+	t.AppendRow([]interface{}{"Number of keys", report.NumKeys})
+	t.AppendRow([]interface{}{"Derived keys", strings.Join(report.DerivedKeys, "\n")})
+	t.AppendRow([]interface{}{"Invalid keys", strings.Join(report.InvalidKeys, "\n")})
+	t.AppendRow([]interface{}{"Missing keys", strings.Join(report.MissingKeys, "\n")})
+	t.AppendRow([]interface{}{"Key generations", strings.Join(generations, ", ")})
+	t.AppendRow([]interface{}{"Title keys", report.NumTitleKeys})
+	t.AppendRow([]interface{}{"Header key", report.HeaderKeyCheck})
+	t.AppendFooter(table.Row{"Valid", report.Valid})
+	t.Render()
+}
+
 func (c *Console) processIssues(localDB *db.LocalSwitchFilesDB) {
 	if len(localDB.Skipped) != 0 {
 		fmt.Print("\nSkipped files:\n\n")
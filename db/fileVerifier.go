@@ -0,0 +1,78 @@
+package db
+
+import (
+	"fmt"
+	"github.com/giwty/switch-library-manager/switchfs"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+)
+
+const (
+	DB_TABLE_VERIFY = "verify"
+)
+
+type VerifyResult struct {
+	Size    int64
+	ModTime int64
+	Valid   bool
+	Issues  []string
+}
+
+// VerifyFile computes the SHA-256 of every NCA in the file, and compares it to the hashes in the cnmt,
+// the result is cached until the file size or modification time changes
+func (ldb *LocalSwitchDBManager) VerifyFile(file ExtendedFileInfo, ignoreCache bool) (*VerifyResult, error) {
+	filePath := filepath.Join(file.BaseFolder, file.FileName)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ignoreCache {
+		cached := &VerifyResult{}
+		err = ldb.db.GetEntry(DB_TABLE_VERIFY, filePath, cached)
+		if err == nil && cached.Size == info.Size() && cached.ModTime == info.ModTime().UnixNano() {
+			return cached, nil
+		}
+	}
+
+	ncaResults, err := switchfs.VerifyNcaHashes(filePath)
+	if err != nil {
+		return nil, err
+	}
+	result := &VerifyResult{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Valid: true, Issues: []string{}}
+	for _, ncaResult := range ncaResults {
+		if ncaResult.IsValid() {
+			continue
+		}
+		result.Valid = false
+		if ncaResult.Missing {
+			result.Issues = append(result.Issues, fmt.Sprintf("%v NCA is missing (%v)", ncaResult.ContentType, ncaResult.FileName))
+			continue
+		}
+		issue := fmt.Sprintf("%v NCA hash mismatch (%v)", ncaResult.ContentType, ncaResult.FileName)
+		//locate the damaged blocks using the section hash levels
+		sections, err := switchfs.VerifyNcaIntegrity(filePath, ncaResult.FileName)
+		if err != nil {
+			zap.S().Warnf("failed to verify the sections of [%v] - %v", ncaResult.FileName, err)
+		} else if failedBlocks := switchfs.FormatIntegrityIssues(sections); failedBlocks != "" {
+			issue += " - " + failedBlocks
+		}
+		result.Issues = append(result.Issues, issue)
+	}
+
+	err = ldb.db.AddEntry(DB_TABLE_VERIFY, filePath, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SaveLibrary persists the changes made to the library (e.g. files marked as skipped)
+func (ldb *LocalSwitchDBManager) SaveLibrary(localDB *LocalSwitchFilesDB) error {
+	err := ldb.db.AddEntry(DB_TABLE_LOCAL_LIBRARY, "skipped", localDB.Skipped)
+	if err != nil {
+		return err
+	}
+	return ldb.db.AddEntry(DB_TABLE_LOCAL_LIBRARY, "titles", localDB.TitlesMap)
+}
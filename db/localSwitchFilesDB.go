@@ -22,16 +22,27 @@ var (
 const (
 	DB_TABLE_FILE_SCAN_METADATA = "deep-scan"
 	DB_TABLE_LOCAL_LIBRARY      = "local-library"
+	DB_TABLE_TITLE_KEYS         = "title-keys"
+	DB_TABLE_ORIGINAL_SIZE      = "original-size"
+	DB_TABLE_XCI_TRIM_INFO      = "xci-trim-info"
 
-	REASON_UNSUPPORTED_TYPE = iota
+	ICON_CACHE_FOLDER = "icons"
+)
+
+// the reason codes are persisted with the skipped files, new reasons must be added at the end
+const (
+	REASON_UNSUPPORTED_TYPE = iota + 2
 	REASON_DUPLICATE
 	REASON_OLD_UPDATE
 	REASON_UNRECOGNISED
 	REASON_MALFORMED_FILE
+	REASON_MISSING_KEY
+	REASON_CORRUPT
 )
 
 type LocalSwitchDBManager struct {
-	db *PersistentDB
+	db         *PersistentDB
+	iconFolder string
 }
 
 func NewLocalSwitchDBManager(baseFolder string) (*LocalSwitchDBManager, error) {
@@ -39,7 +50,7 @@ func NewLocalSwitchDBManager(baseFolder string) (*LocalSwitchDBManager, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &LocalSwitchDBManager{db: db}, nil
+	return &LocalSwitchDBManager{db: db, iconFolder: filepath.Join(baseFolder, ICON_CACHE_FOLDER)}, nil
 }
 
 func (ldb *LocalSwitchDBManager) Close() {
@@ -87,6 +98,8 @@ func (ldb *LocalSwitchDBManager) CreateLocalSwitchFilesDB(folders []string,
 	skipped := map[ExtendedFileInfo]SkippedFile{}
 	files := []ExtendedFileInfo{}
 
+	ldb.loadTitleKeys()
+
 	if !ignoreCache {
 		ldb.db.GetEntry(DB_TABLE_LOCAL_LIBRARY, "files", &files)
 		ldb.db.GetEntry(DB_TABLE_LOCAL_LIBRARY, "skipped", &skipped)
@@ -152,10 +165,276 @@ func scanFolder(folder string, recursive bool, files *[]ExtendedFileInfo, progre
 	return nil
 }
 
+// ReplaceFile updates the library in place after a file was replaced by a file holding the same
+// content (e.g. NSZ converted to NSP), the persisted library and the deep scan cache are updated as well
+func (ldb *LocalSwitchDBManager) ReplaceFile(localDB *LocalSwitchFilesDB, oldFile ExtendedFileInfo, newFile ExtendedFileInfo) error {
+	for _, title := range localDB.TitlesMap {
+		if title.File.ExtendedInfo == oldFile {
+			title.File.ExtendedInfo = newFile
+			title.IsSplit = switchfs.IsSplitFileName(newFile.FileName)
+		}
+		for version, update := range title.Updates {
+			if update.ExtendedInfo == oldFile {
+				update.ExtendedInfo = newFile
+				title.Updates[version] = update
+			}
+		}
+		for titleId, dlc := range title.Dlc {
+			if dlc.ExtendedInfo == oldFile {
+				dlc.ExtendedInfo = newFile
+				title.Dlc[titleId] = dlc
+			}
+		}
+	}
+	if skippedFile, ok := localDB.Skipped[oldFile]; ok {
+		delete(localDB.Skipped, oldFile)
+		localDB.Skipped[newFile] = skippedFile
+	}
+
+	var metadata map[string]*switchfs.ContentMetaAttributes
+	err := ldb.db.GetEntry(DB_TABLE_FILE_SCAN_METADATA, getFileKey(oldFile), &metadata)
+	if err == nil && metadata != nil {
+		err = ldb.db.AddEntry(DB_TABLE_FILE_SCAN_METADATA, getFileKey(newFile), metadata)
+	}
+	if err != nil {
+		zap.S().Warnf("failed to update scan data - %v", err)
+	}
+
+	files := []ExtendedFileInfo{}
+	err = ldb.db.GetEntry(DB_TABLE_LOCAL_LIBRARY, "files", &files)
+	if err != nil {
+		return err
+	}
+	for i, file := range files {
+		if file == oldFile {
+			files[i] = newFile
+		}
+	}
+	err = ldb.db.AddEntry(DB_TABLE_LOCAL_LIBRARY, "files", files)
+	if err != nil {
+		return err
+	}
+	return ldb.SaveLibrary(localDB)
+}
+
+// AddFiles adds new files (e.g. NSPs converted from an XCI) to the library, the persisted library is updated as well,
+// files that are already in the library are skipped
+func (ldb *LocalSwitchDBManager) AddFiles(localDB *LocalSwitchFilesDB, newFiles []ExtendedFileInfo) error {
+	files := []ExtendedFileInfo{}
+	err := ldb.db.GetEntry(DB_TABLE_LOCAL_LIBRARY, "files", &files)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, file := range files {
+		existing[filepath.Join(file.BaseFolder, file.FileName)] = true
+	}
+	var addedFiles []ExtendedFileInfo
+	for _, file := range newFiles {
+		filePath := filepath.Join(file.BaseFolder, file.FileName)
+		if existing[filePath] {
+			continue
+		}
+		existing[filePath] = true
+		addedFiles = append(addedFiles, file)
+	}
+	if len(addedFiles) == 0 {
+		return nil
+	}
+
+	ldb.processLocalFiles(addedFiles, nil, localDB.TitlesMap, localDB.Skipped)
+	localDB.NumFiles += len(addedFiles)
+	err = ldb.db.AddEntry(DB_TABLE_LOCAL_LIBRARY, "files", append(files, addedFiles...))
+	if err != nil {
+		return err
+	}
+	return ldb.SaveLibrary(localDB)
+}
+
+// RemoveFile removes a file from the library (e.g. a multi-content NSP that was split), titles left without
+// files are removed, the persisted library is updated as well
+func (ldb *LocalSwitchDBManager) RemoveFile(localDB *LocalSwitchFilesDB, oldFile ExtendedFileInfo) error {
+	removed := false
+	for idPrefix, title := range localDB.TitlesMap {
+		found := false
+		if title.BaseExist && title.File.ExtendedInfo == oldFile {
+			title.File = SwitchFileInfo{}
+			title.BaseExist = false
+			found = true
+		}
+		for version, update := range title.Updates {
+			if update.ExtendedInfo == oldFile {
+				delete(title.Updates, version)
+				found = true
+			}
+		}
+		for titleId, dlc := range title.Dlc {
+			if dlc.ExtendedInfo == oldFile {
+				delete(title.Dlc, titleId)
+				found = true
+			}
+		}
+		if !found {
+			continue
+		}
+		removed = true
+		title.MultiContent = false
+		title.LatestUpdate = 0
+		for version := range title.Updates {
+			if version > title.LatestUpdate {
+				title.LatestUpdate = version
+			}
+		}
+		if !title.BaseExist && len(title.Updates) == 0 && len(title.Dlc) == 0 {
+			delete(localDB.TitlesMap, idPrefix)
+		}
+	}
+	delete(localDB.Skipped, oldFile)
+	if removed {
+		localDB.NumFiles--
+	}
+
+	files := []ExtendedFileInfo{}
+	err := ldb.db.GetEntry(DB_TABLE_LOCAL_LIBRARY, "files", &files)
+	if err != nil {
+		return err
+	}
+	var newFiles []ExtendedFileInfo
+	for _, file := range files {
+		if file != oldFile {
+			newFiles = append(newFiles, file)
+		}
+	}
+	err = ldb.db.AddEntry(DB_TABLE_LOCAL_LIBRARY, "files", newFiles)
+	if err != nil {
+		return err
+	}
+	return ldb.SaveLibrary(localDB)
+}
+
+// GetOriginalSize returns the size of the NSP/XCI that the NSZ/XCZ file was compressed from (the file size
+// for uncompressed files), the result is cached
+func (ldb *LocalSwitchDBManager) GetOriginalSize(file ExtendedFileInfo) int64 {
+	fileName := strings.ToLower(file.FileName)
+	if !strings.HasSuffix(fileName, "nsz") && !strings.HasSuffix(fileName, "xcz") {
+		return file.Size
+	}
+	var originalSize int64
+	fileKey := getFileKey(file)
+	err := ldb.db.GetEntry(DB_TABLE_ORIGINAL_SIZE, fileKey, &originalSize)
+	if err == nil && originalSize != 0 {
+		return originalSize
+	}
+	decompressed, err := switchfs.OpenDecompressed(filepath.Join(file.BaseFolder, file.FileName))
+	if err != nil {
+		zap.S().Debugf("failed to read NCZ sizes of [%v] - %v", file.FileName, err)
+		return file.Size
+	}
+	originalSize = decompressed.Size()
+	decompressed.Close()
+	err = ldb.db.AddEntry(DB_TABLE_ORIGINAL_SIZE, fileKey, originalSize)
+	if err != nil {
+		zap.S().Warnf("%v", err)
+	}
+	return originalSize
+}
+
+// GetXciTrimInfo returns the trimmed status of the XCI file (nil for other files), the result is cached
+func (ldb *LocalSwitchDBManager) GetXciTrimInfo(file ExtendedFileInfo) *switchfs.XciTrimInfo {
+	if !strings.HasSuffix(strings.ToLower(file.FileName), "xci") {
+		return nil
+	}
+	var trimInfo *switchfs.XciTrimInfo
+	fileKey := getFileKey(file)
+	err := ldb.db.GetEntry(DB_TABLE_XCI_TRIM_INFO, fileKey, &trimInfo)
+	if err == nil && trimInfo != nil {
+		return trimInfo
+	}
+	trimInfo, err = switchfs.GetXciTrimInfo(filepath.Join(file.BaseFolder, file.FileName))
+	if err != nil {
+		zap.S().Debugf("failed to read XCI header of [%v] - %v", file.FileName, err)
+		return nil
+	}
+	err = ldb.db.AddEntry(DB_TABLE_XCI_TRIM_INFO, fileKey, trimInfo)
+	if err != nil {
+		zap.S().Warnf("%v", err)
+	}
+	return trimInfo
+}
+
+// saveIcons stores the icons extracted from the control NCAs in the icon cache (<title id>.jpg)
+func (ldb *LocalSwitchDBManager) saveIcons(metadata map[string]*switchfs.ContentMetaAttributes) {
+	for _, titleMetadata := range metadata {
+		if titleMetadata.Ncap == nil || len(titleMetadata.Ncap.Icon()) == 0 {
+			continue
+		}
+		err := os.MkdirAll(ldb.iconFolder, os.ModePerm)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(ldb.iconFolder, strings.ToLower(titleMetadata.TitleId)+".jpg"), titleMetadata.Ncap.Icon(), 0644)
+		}
+		if err != nil {
+			zap.S().Warnf("failed to save the icon of [%v] - %v", titleMetadata.TitleId, err)
+		}
+	}
+}
+
+// GetIconPath returns the path of the icon of the title in the icon cache, empty if the icon was not extracted
+func (ldb *LocalSwitchDBManager) GetIconPath(titleId string) string {
+	iconPath := filepath.Join(ldb.iconFolder, strings.ToLower(titleId)+".jpg")
+	if _, err := os.Stat(iconPath); err != nil {
+		return ""
+	}
+	return iconPath
+}
+
+func getFileKey(file ExtendedFileInfo) string {
+	filePath := filepath.Join(file.BaseFolder, file.FileName)
+	return filePath + "|" + file.FileName + "|" + strconv.Itoa(int(file.Size))
+}
+
 func (ldb *LocalSwitchDBManager) ClearScanData() error {
 	return ldb.db.ClearTable(DB_TABLE_FILE_SCAN_METADATA)
 }
 
+// loadTitleKeys adds the title keys harvested from previously scanned tickets to the key store
+func (ldb *LocalSwitchDBManager) loadTitleKeys() {
+	keys, _ := settings.SwitchKeys()
+	if keys == nil {
+		return
+	}
+	titleKeys, err := ldb.db.GetStringEntries(DB_TABLE_TITLE_KEYS)
+	if err != nil {
+		zap.S().Warnf("failed to load title keys - %v", err)
+		return
+	}
+	for rightsId, titleKey := range titleKeys {
+		keys.AddTitleKey(rightsId, titleKey)
+	}
+}
+
+// harvestTitleKeys saves the title keys of the tickets found in the file, so that titles
+// missing a ticket can be decrypted by tickets from other files
+func (ldb *LocalSwitchDBManager) harvestTitleKeys(filePath string) {
+	keys, _ := settings.SwitchKeys()
+	if keys == nil {
+		return
+	}
+	titleKeys, err := switchfs.ReadTitleKeys(filePath)
+	if err != nil {
+		zap.S().Debugf("failed to read tickets from [%v] - %v", filePath, err)
+		return
+	}
+	for rightsId, titleKey := range titleKeys {
+		if !keys.AddTitleKey(rightsId, titleKey) {
+			continue
+		}
+		err = ldb.db.AddEntry(DB_TABLE_TITLE_KEYS, rightsId, titleKey)
+		if err != nil {
+			zap.S().Warnf("failed to save title key - %v", err)
+		}
+	}
+}
+
 func (ldb *LocalSwitchDBManager) processLocalFiles(files []ExtendedFileInfo,
 	progress ProgressUpdater,
 	titles map[string]*SwitchGameFiles,
@@ -177,13 +456,12 @@ func (ldb *LocalSwitchDBManager) processLocalFiles(files []ExtendedFileInfo,
 		fileName := strings.ToLower(file.FileName)
 		isSplit := false
 
-		if partNum, err := strconv.Atoi(fileName[len(fileName)-2:]); err == nil {
-			if partNum == 0 {
-				isSplit = true
-			} else {
+		//only the first part is scanned, the other parts are read with it
+		if partNum, ok := switchfs.GetSplitPartNumber(file.FileName); ok {
+			if partNum != 0 {
 				continue
 			}
-
+			isSplit = true
 		}
 
 		//only handle NSZ and NSP files
@@ -285,7 +563,7 @@ func (ldb *LocalSwitchDBManager) getGameMetadata(file ExtendedFileInfo,
 	var metadata map[string]*switchfs.ContentMetaAttributes = nil
 	keys, _ := settings.SwitchKeys()
 	var err error
-	fileKey := filePath + "|" + file.FileName + "|" + strconv.Itoa(int(file.Size))
+	fileKey := getFileKey(file)
 	if keys != nil && keys.GetKey("header_key") != "" {
 		err = ldb.db.GetEntry(DB_TABLE_FILE_SCAN_METADATA, fileKey, &metadata)
 
@@ -297,6 +575,8 @@ func (ldb *LocalSwitchDBManager) getGameMetadata(file ExtendedFileInfo,
 			return metadata, nil
 		}
 
+		ldb.harvestTitleKeys(filePath)
+
 		fileName := strings.ToLower(file.FileName)
 		if strings.HasSuffix(fileName, "nsp") ||
 			strings.HasSuffix(fileName, "nsz") {
@@ -312,16 +592,21 @@ func (ldb *LocalSwitchDBManager) getGameMetadata(file ExtendedFileInfo,
 				skipped[file] = SkippedFile{ReasonCode: REASON_MALFORMED_FILE, ReasonText: fmt.Sprintf("failed to read NSP [reason: %v]", err)}
 				zap.S().Errorf("[file:%v] failed to read file [reason: %v]\n", file.FileName, err)
 			}
-		} else if strings.HasSuffix(fileName, "00") {
+		} else if switchfs.IsSplitFileName(file.FileName) {
 			metadata, err = fileio.ReadSplitFileMetadata(filePath)
 			if err != nil {
 				skipped[file] = SkippedFile{ReasonCode: REASON_MALFORMED_FILE, ReasonText: fmt.Sprintf("failed to read split files [reason: %v]", err)}
 				zap.S().Errorf("[file:%v] failed to read NSP [reason: %v]\n", file.FileName, err)
 			}
 		}
+		var keyErr *switchfs.MissingKeyError
+		if errors.As(err, &keyErr) {
+			skipped[file] = SkippedFile{ReasonCode: REASON_MISSING_KEY, ReasonText: fmt.Sprintf("missing key [%v], please add it to prod.keys", keyErr.KeyName)}
+		}
 	}
 
 	if metadata != nil {
+		ldb.saveIcons(metadata)
 		err = ldb.db.AddEntry(DB_TABLE_FILE_SCAN_METADATA, fileKey, metadata)
 
 		if err != nil {
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/giwty/switch-library-manager/settings"
+	"github.com/giwty/switch-library-manager/switchfs"
+	"github.com/giwty/switch-library-manager/switchfs/_crypto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testMetaNca builds a meta NCA with a single AesCtr section, the section content is not needed as the keys are
+// checked when the sections are opened
+func testMetaNca(t *testing.T, headerKey []byte, rightsId []byte) []byte {
+	header := make([]byte, 0xC00)
+	copy(header[0x200:], "NCA3")
+	header[0x205] = 1 //meta
+	binary.LittleEndian.PutUint64(header[0x208:], 0x4200)
+	copy(header[0x230:], rightsId)
+	binary.LittleEndian.PutUint32(header[0x240:], 0x4000/0x200)
+	binary.LittleEndian.PutUint32(header[0x244:], 0x4200/0x200)
+	fsHeader := header[0x400:0x600]
+	fsHeader[0x2] = 1 //PFS0
+	fsHeader[0x3] = 2 //HierarchicalSha256
+	fsHeader[0x4] = 3 //AesCtr
+	hash := sha256.Sum256(fsHeader)
+	copy(header[0x280:], hash[:])
+
+	c, err := _crypto.NewCipher(aes.NewCipher, headerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nca := make([]byte, 0x4200)
+	for sector := 0; sector < 6; sector++ {
+		tweak := [16]byte{}
+		tweak[0xF] = byte(sector)
+		c.EncryptSector(nca[sector*0x200:(sector+1)*0x200], header[sector*0x200:(sector+1)*0x200], &tweak)
+	}
+	return nca
+}
+
+func TestGetGameMetadataMissingKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		rightsId []byte
+		keyName  string
+	}{
+		{"titlekek", bytes.Repeat([]byte{0xAB}, 0x10), "titlekek_00"},
+		{"key area key", nil, "key_area_key_application_00"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			//only the header key, so that the titlekek and the key area keys can't be derived
+			headerKey := bytes.Repeat([]byte{0x11}, 0x20)
+			keys := "header_key = " + hex.EncodeToString(headerKey) + "\n"
+			err := os.WriteFile(filepath.Join(dir, "prod.keys"), []byte(keys), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			titleKeys := hex.EncodeToString(bytes.Repeat([]byte{0xAB}, 0x10)) + " = " + hex.EncodeToString(bytes.Repeat([]byte{0x33}, 0x10)) + "\n"
+			err = os.WriteFile(filepath.Join(dir, "title.keys"), []byte(titleKeys), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = settings.InitSwitchKeys(dir); err != nil {
+				t.Fatal(err)
+			}
+
+			nca := testMetaNca(t, headerKey, test.rightsId)
+			nsp, err := os.Create(filepath.Join(dir, "title.nsp"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			size, err := switchfs.WritePfs0(nsp, []switchfs.PartitionFile{
+				{Name: "00112233445566778899aabbccddeeff.cnmt.nca", Size: int64(len(nca)), Reader: bytes.NewReader(nca)}})
+			nsp.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ldb, err := NewLocalSwitchDBManager(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ldb.Close()
+			file := ExtendedFileInfo{FileName: "title.nsp", BaseFolder: dir, Size: size}
+			skipped := map[ExtendedFileInfo]SkippedFile{}
+			ldb.getGameMetadata(file, filepath.Join(dir, "title.nsp"), skipped)
+
+			if skipped[file].ReasonCode != REASON_MISSING_KEY || !strings.Contains(skipped[file].ReasonText, test.keyName) {
+				t.Fatalf("unexpected skipped reason %+v", skipped[file])
+			}
+		})
+	}
+}
+
+func TestAddFilesSkipsExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	ldb, err := NewLocalSwitchDBManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+	err = ldb.db.AddEntry(DB_TABLE_LOCAL_LIBRARY, "files", []ExtendedFileInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	localDB := &LocalSwitchFilesDB{TitlesMap: map[string]*SwitchGameFiles{}, Skipped: map[ExtendedFileInfo]SkippedFile{}}
+	first := ExtendedFileInfo{FileName: "first.nsp", BaseFolder: dir, Size: 0x100}
+	second := ExtendedFileInfo{FileName: "second.nsp", BaseFolder: dir, Size: 0x200}
+	for _, file := range []ExtendedFileInfo{first, second} {
+		err = os.WriteFile(filepath.Join(dir, file.FileName), make([]byte, file.Size), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = ldb.AddFiles(localDB, []ExtendedFileInfo{first}); err != nil {
+		t.Fatal(err)
+	}
+	if err = ldb.AddFiles(localDB, []ExtendedFileInfo{first, second, second}); err != nil {
+		t.Fatal(err)
+	}
+
+	if localDB.NumFiles != 2 {
+		t.Fatalf("unexpected number of files %v", localDB.NumFiles)
+	}
+	files := []ExtendedFileInfo{}
+	err = ldb.db.GetEntry(DB_TABLE_LOCAL_LIBRARY, "files", &files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != first || files[1] != second {
+		t.Fatalf("unexpected persisted files %v", files)
+	}
+}
@@ -105,6 +105,27 @@ func (pd *PersistentDB) GetEntry(tableName string, key string, value interface{}
 	return err
 }
 
+// GetStringEntries returns all the entries of a table holding string values
+func (pd *PersistentDB) GetStringEntries(tableName string) (map[string]string, error) {
+	result := map[string]string{}
+	err := pd.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(tableName))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var value string
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&value)
+			if err != nil {
+				return err
+			}
+			result[string(k)] = value
+			return nil
+		})
+	})
+	return result, err
+}
+
 /*func (pd *PersistentDB) GetEntries() (map[string]*switchfs.ContentMetaAttributes, error) {
 	pd.db.View(func(tx *bolt.Tx) error {
 		// Assume bucket exists and has keys
@@ -1,6 +1,6 @@
 module github.com/giwty/switch-library-manager
 
-go 1.12
+go 1.22
 
 require (
 	github.com/asticode/go-astikit v0.8.0
@@ -10,6 +10,7 @@ require (
 	github.com/boltdb/bolt v1.3.1
 	github.com/go-openapi/strfmt v0.19.2 // indirect
 	github.com/jedib0t/go-pretty v4.3.0+incompatible
+	github.com/klauspost/compress v1.18.0
 	github.com/magiconair/properties v1.8.1
 	github.com/mcuadros/go-version v0.0.0-20190830083331-035f6764e8d2
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
@@ -12,6 +12,7 @@ import (
 	"github.com/giwty/switch-library-manager/settings"
 	"go.uber.org/zap"
 	"log"
+	"net/url"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -39,16 +40,23 @@ type SwitchTitle struct {
 }
 
 type LibraryTemplateData struct {
-	Id      int    `json:"id"`
-	Name    string `json:"name"`
-	Version string `json:"version"`
-	Dlc     string `json:"dlc"`
-	TitleId string `json:"titleId"`
-	Path    string `json:"path"`
-	Icon    string `json:"icon"`
-	Update  int    `json:"update"`
-	Region  string `json:"region"`
-	Type    string `json:"type"`
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Dlc         string `json:"dlc"`
+	TitleId     string `json:"titleId"`
+	Path        string `json:"path"`
+	Icon        string `json:"icon"`
+	Update      int    `json:"update"`
+	Region      string `json:"region"`
+	Type        string `json:"type"`
+	SpaceSaved  int64  `json:"space_saved"`
+	Trimmed     string `json:"trimmed"`
+	Reclaimable int64  `json:"reclaimable_space"`
+	Firmware    string `json:"firmware"`
+	Languages   string `json:"languages"`
+	AgeRating   string `json:"age_rating"`
+	SaveSize    int64  `json:"save_size"`
 }
 
 type ProgressUpdate struct {
@@ -196,6 +204,19 @@ func (g *GUI) handleMessage(m *astilectron.EventMessage) interface{} {
 	case "isKeysFileAvailable":
 		keys, _ := settings.SwitchKeys()
 		retValue = strconv.FormatBool(keys != nil && keys.GetKey("header_key") != "")
+	case "validateKeys":
+		sampleFile := process.FindSampleFile(g.state.localDB)
+		if sampleFile == "" {
+			sampleFile = process.FindSampleFileInFolder(settings.ReadSettings(g.baseFolder).Folder)
+		}
+		report, err := process.ValidateKeys(sampleFile)
+		if err != nil {
+			g.sugarLogger.Error(err)
+			g.state.window.SendMessage(Message{Name: "error", Payload: err.Error()}, func(m *astilectron.EventMessage) {})
+			return ""
+		}
+		msg, _ := json.Marshal(report)
+		retValue = string(msg)
 	case "loadSettings":
 		retValue = g.loadSettings()
 	case "saveSettings":
@@ -224,6 +245,7 @@ func (g *GUI) handleMessage(m *astilectron.EventMessage) interface{} {
 			if v.BaseExist {
 				version := ""
 				name := ""
+				nacp := v.File.Metadata.Ncap
 				if v.File.Metadata.Ncap != nil {
 					version = v.File.Metadata.Ncap.DisplayVersion
 					name = v.File.Metadata.Ncap.TitleName["AmericanEnglish"].Title
@@ -232,24 +254,43 @@ func (g *GUI) handleMessage(m *astilectron.EventMessage) interface{} {
 				if v.Updates != nil && len(v.Updates) != 0 {
 					if v.Updates[v.LatestUpdate].Metadata.Ncap != nil {
 						version = v.Updates[v.LatestUpdate].Metadata.Ncap.DisplayVersion
+						nacp = v.Updates[v.LatestUpdate].Metadata.Ncap
 					} else {
 						version = ""
 					}
 				}
+				trimmed, reclaimable := process.GetTrimStatus(g.localDbManager, v)
+				icon := g.getLocalIcon(v)
+				languages, ageRating, saveSize := "", "", int64(0)
+				if nacp != nil {
+					languages = strings.Join(nacp.SupportedLanguages, ", ")
+					ageRating = strings.Join(nacp.RatingAges(), ", ")
+					saveSize = nacp.TotalSaveDataSize()
+				}
 				if title, ok := g.state.switchDB.TitlesMap[k]; ok {
 					if title.Attributes.Name != "" {
 						name = title.Attributes.Name
 					}
+					if icon == "" {
+						icon = title.Attributes.IconUrl
+					}
 					libraryData = append(libraryData,
 						LibraryTemplateData{
-							Icon:    title.Attributes.IconUrl,
-							Name:    name,
-							TitleId: v.File.Metadata.TitleId,
-							Update:  v.LatestUpdate,
-							Version: version,
-							Region:  title.Attributes.Region,
-							Type:    getType(v),
-							Path:    filepath.Join(v.File.ExtendedInfo.BaseFolder, v.File.ExtendedInfo.FileName),
+							Icon:        icon,
+							Name:        name,
+							TitleId:     v.File.Metadata.TitleId,
+							Update:      v.LatestUpdate,
+							Version:     version,
+							Region:      title.Attributes.Region,
+							Type:        getType(v),
+							Path:        filepath.Join(v.File.ExtendedInfo.BaseFolder, v.File.ExtendedInfo.FileName),
+							SpaceSaved:  process.GetSpaceSaved(g.localDbManager, v),
+							Trimmed:     trimmed,
+							Reclaimable: reclaimable,
+							Firmware:    process.GetFirmwareInfo(v),
+							Languages:   languages,
+							AgeRating:   ageRating,
+							SaveSize:    saveSize,
 						})
 				} else {
 					if name == "" {
@@ -257,12 +298,20 @@ func (g *GUI) handleMessage(m *astilectron.EventMessage) interface{} {
 					}
 					libraryData = append(libraryData,
 						LibraryTemplateData{
-							Name:    name,
-							Update:  v.LatestUpdate,
-							Version: version,
-							Type:    getType(v),
-							TitleId: v.File.Metadata.TitleId,
-							Path:    v.File.ExtendedInfo.FileName,
+							Icon:        icon,
+							Name:        name,
+							Update:      v.LatestUpdate,
+							Version:     version,
+							Type:        getType(v),
+							TitleId:     v.File.Metadata.TitleId,
+							Path:        v.File.ExtendedInfo.FileName,
+							SpaceSaved:  process.GetSpaceSaved(g.localDbManager, v),
+							Trimmed:     trimmed,
+							Reclaimable: reclaimable,
+							Firmware:    process.GetFirmwareInfo(v),
+							Languages:   languages,
+							AgeRating:   ageRating,
+							SaveSize:    saveSize,
 						})
 				}
 
@@ -313,6 +362,26 @@ func (g *GUI) handleMessage(m *astilectron.EventMessage) interface{} {
 	return retValue
 }
 
+// getLocalIcon returns the URL of the icon extracted from the latest update (or the base title) during the deep scan,
+// empty if there is no local icon
+func (g *GUI) getLocalIcon(gameFile *db.SwitchGameFiles) string {
+	var titleIds []string
+	if update, ok := gameFile.Updates[gameFile.LatestUpdate]; ok && update.Metadata != nil {
+		titleIds = append(titleIds, update.Metadata.TitleId)
+	}
+	titleIds = append(titleIds, gameFile.File.Metadata.TitleId)
+	for _, titleId := range titleIds {
+		if iconPath := g.localDbManager.GetIconPath(titleId); iconPath != "" {
+			iconPath = filepath.ToSlash(iconPath)
+			if !strings.HasPrefix(iconPath, "/") {
+				iconPath = "/" + iconPath
+			}
+			return (&url.URL{Scheme: "file", Path: iconPath}).String()
+		}
+	}
+	return ""
+}
+
 func getType(gameFile *db.SwitchGameFiles) string {
 	if gameFile.IsSplit {
 		return "split"
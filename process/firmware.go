@@ -0,0 +1,33 @@
+package process
+
+import (
+	"github.com/giwty/switch-library-manager/db"
+	"github.com/giwty/switch-library-manager/switchfs"
+)
+
+// GetRequiredSystemVersion returns the firmware required to run the title with its latest update
+func GetRequiredSystemVersion(title *db.SwitchGameFiles) int {
+	result := 0
+	if title.File.Metadata != nil {
+		result = title.File.Metadata.RequiredSystemVersion
+	}
+	if update, ok := title.Updates[title.LatestUpdate]; ok && update.Metadata != nil &&
+		update.Metadata.RequiredSystemVersion > result {
+		result = update.Metadata.RequiredSystemVersion
+	}
+	return result
+}
+
+// GetFirmwareInfo returns the firmware required by the title, and the firmware shipped on the cartridge
+// (e.g. "requires firmware 9.0.0, cart ships firmware 8.1.0"), empty if the cnmt was not read
+func GetFirmwareInfo(title *db.SwitchGameFiles) string {
+	requiredSystemVersion := GetRequiredSystemVersion(title)
+	if requiredSystemVersion == 0 {
+		return ""
+	}
+	result := "requires firmware " + switchfs.FormatSystemVersion(requiredSystemVersion)
+	if title.File.Metadata != nil && title.File.Metadata.SystemUpdateVersion != 0 {
+		result += ", cart ships firmware " + switchfs.FormatSystemVersion(title.File.Metadata.SystemUpdateVersion)
+	}
+	return result
+}
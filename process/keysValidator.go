@@ -0,0 +1,85 @@
+package process
+
+import (
+	"errors"
+	"github.com/giwty/switch-library-manager/db"
+	"github.com/giwty/switch-library-manager/settings"
+	"github.com/giwty/switch-library-manager/switchfs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errSampleFound = errors.New("sample found")
+
+// ValidateKeys builds a diagnostics report for the loaded prod.keys, the header_key is verified
+// by decrypting the NCA header of the given sample file (if available)
+func ValidateKeys(sampleFile string) (*settings.KeysReport, error) {
+	keys, _ := settings.SwitchKeys()
+	if keys == nil {
+		return nil, errors.New("keys file was not found")
+	}
+	report := keys.Validate()
+	if sampleFile == "" {
+		report.HeaderKeyCheck = "skipped - no NSP/XCI file found to verify the header_key"
+		return report, nil
+	}
+	err := switchfs.CheckHeaderKey(sampleFile)
+	if err != nil {
+		report.HeaderKeyCheck = "failed - " + err.Error()
+		report.Valid = false
+	} else {
+		report.HeaderKeyCheck = "ok - decrypted NCA header of " + filepath.Base(sampleFile)
+	}
+	return report, nil
+}
+
+// FindSampleFile returns the first NSP/XCI in the local library, or "" if none exists
+func FindSampleFile(localDB *db.LocalSwitchFilesDB) string {
+	if localDB == nil {
+		return ""
+	}
+	for _, v := range localDB.TitlesMap {
+		for _, file := range append([]db.SwitchFileInfo{v.File}, getFiles(v)...) {
+			if isSupportedFile(file.ExtendedInfo.FileName) {
+				return filepath.Join(file.ExtendedInfo.BaseFolder, file.ExtendedInfo.FileName)
+			}
+		}
+	}
+	return ""
+}
+
+// FindSampleFileInFolder walks the folder, and returns the first NSP/XCI found, or "" if none exists
+func FindSampleFileInFolder(folder string) string {
+	result := ""
+	_ = filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if isSupportedFile(info.Name()) {
+			result = path
+			return errSampleFound
+		}
+		return nil
+	})
+	return result
+}
+
+func getFiles(v *db.SwitchGameFiles) []db.SwitchFileInfo {
+	var result []db.SwitchFileInfo
+	for _, update := range v.Updates {
+		result = append(result, update)
+	}
+	for _, dlc := range v.Dlc {
+		result = append(result, dlc)
+	}
+	return result
+}
+
+func isSupportedFile(fileName string) bool {
+	fileName = strings.ToLower(fileName)
+	return strings.HasSuffix(fileName, "nsp") ||
+		strings.HasSuffix(fileName, "nsz") ||
+		strings.HasSuffix(fileName, "xci") ||
+		strings.HasSuffix(fileName, "xcz")
+}
@@ -0,0 +1,44 @@
+package process
+
+import (
+	"github.com/giwty/switch-library-manager/db"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// VerifyLibrary verifies the NCA hashes of all the files in the library, missing or corrupted NCAs
+// mark the file as skipped (REASON_CORRUPT)
+func VerifyLibrary(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	ignoreCache bool,
+	updateProgress db.ProgressUpdater) map[db.ExtendedFileInfo]*db.VerifyResult {
+
+	result := map[db.ExtendedFileInfo]*db.VerifyResult{}
+	files := getLibraryFiles(localDB, "")
+	for i, file := range files {
+		if updateProgress != nil {
+			updateProgress.UpdateProgress(i+1, len(files)+1, "verifying "+file.FileName)
+		}
+		verifyResult, err := localDbManager.VerifyFile(file, ignoreCache)
+		if err != nil {
+			zap.S().Errorf("Failed to verify %v [%v]\n", file.FileName, err)
+			continue
+		}
+		result[file] = verifyResult
+		if !verifyResult.Valid {
+			zap.S().Warnf("-->Corrupted file found [%v] - %v", file.FileName, strings.Join(verifyResult.Issues, ", "))
+			localDB.Skipped[file] = db.SkippedFile{ReasonCode: db.REASON_CORRUPT, ReasonText: "corrupted file - " + strings.Join(verifyResult.Issues, ", ")}
+		} else if skipped, ok := localDB.Skipped[file]; ok && skipped.ReasonCode == db.REASON_CORRUPT {
+			delete(localDB.Skipped, file)
+		}
+	}
+
+	err := localDbManager.SaveLibrary(localDB)
+	if err != nil {
+		zap.S().Warnf("failed to save the library - %v", err)
+	}
+	if updateProgress != nil {
+		updateProgress.UpdateProgress(len(files)+1, len(files)+1, "verification complete")
+	}
+	return result
+}
@@ -0,0 +1,214 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"github.com/giwty/switch-library-manager/db"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//https://github.com/Atmosphere-NX/Atmosphere/blob/master/docs/features/cheats.md
+//https://github.com/Atmosphere-NX/Atmosphere/blob/master/docs/features/layeredfs.md
+
+var (
+	modTitleIdRegex = regexp.MustCompile(`^[0-9A-Fa-f]{16}$`)
+	cheatFileRegex  = regexp.MustCompile(`^([0-9A-Fa-f]{16})\.txt$`)
+)
+
+// CheatFile is a cheat file of the mod library (contents/<title id>/cheats/<build id>.txt), the build id is
+// the first 8 bytes of the build id of the main executable
+type CheatFile struct {
+	Path    string
+	BuildId string
+	Version *int //the local version (0 for the base) with the same build id, nil if there is none
+}
+
+// TitleMods are the cheats and LayeredFS mods (romfs / exefs folders) of a title in the mod library
+type TitleMods struct {
+	TitleId string
+	Folder  string
+	Name    string
+	Owned   bool //the base title is in the local library
+	Cheats  []CheatFile
+	RomFs   bool
+	ExeFs   bool
+}
+
+// ScanModLibrary reads the mod library folder (<folder>/contents/<title id>, the atmosphere folder of the SD card
+// or a folder holding the contents folder are accepted), and maps the cheats to the local titles and versions
+// with the same build id
+func ScanModLibrary(modsFolder string, localDB *db.LocalSwitchFilesDB) ([]*TitleMods, error) {
+	contentsFolder, err := getModContentsFolder(modsFolder)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(contentsFolder)
+	if err != nil {
+		return nil, err
+	}
+	var result []*TitleMods
+	for _, entry := range entries {
+		if !entry.IsDir() || !modTitleIdRegex.MatchString(entry.Name()) {
+			continue
+		}
+		titleId := strings.ToLower(entry.Name())
+		titleMods := &TitleMods{TitleId: titleId, Folder: filepath.Join(contentsFolder, entry.Name())}
+		var buildIds map[string]int
+		if title, ok := localDB.TitlesMap[titleId[:len(titleId)-4]]; ok && title.BaseExist {
+			titleMods.Owned = true
+			titleMods.Name = db.ParseTitleNameFromFileName(title.File.ExtendedInfo.FileName)
+			if title.File.Metadata.Ncap != nil && title.File.Metadata.Ncap.TitleName["AmericanEnglish"].Title != "" {
+				titleMods.Name = title.File.Metadata.Ncap.TitleName["AmericanEnglish"].Title
+			}
+			buildIds = getLocalBuildIds(title)
+		}
+		titleMods.RomFs = isDir(filepath.Join(titleMods.Folder, "romfs"))
+		titleMods.ExeFs = isDir(filepath.Join(titleMods.Folder, "exefs"))
+
+		cheats, _ := os.ReadDir(filepath.Join(titleMods.Folder, "cheats"))
+		for _, cheat := range cheats {
+			match := cheatFileRegex.FindStringSubmatch(cheat.Name())
+			if cheat.IsDir() || match == nil {
+				continue
+			}
+			cheatFile := CheatFile{Path: filepath.Join(titleMods.Folder, "cheats", cheat.Name()), BuildId: strings.ToUpper(match[1])}
+			if version, ok := buildIds[cheatFile.BuildId]; ok {
+				cheatFile.Version = &version
+			}
+			titleMods.Cheats = append(titleMods.Cheats, cheatFile)
+		}
+		if len(titleMods.Cheats) == 0 && !titleMods.RomFs && !titleMods.ExeFs {
+			continue
+		}
+		result = append(result, titleMods)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].TitleId < result[j].TitleId })
+	return result, nil
+}
+
+// getModContentsFolder returns the contents folder of the mod library
+func getModContentsFolder(modsFolder string) (string, error) {
+	for _, folder := range []string{filepath.Join(modsFolder, "contents"), filepath.Join(modsFolder, "atmosphere", "contents")} {
+		if isDir(folder) {
+			return folder, nil
+		}
+	}
+	if filepath.Base(modsFolder) == "contents" && isDir(modsFolder) {
+		return modsFolder, nil
+	}
+	return "", errors.New("contents folder was not found in [" + modsFolder + "]")
+}
+
+// getLocalBuildIds returns the local versions of the title (0 for the base) by the build id (the first 8 bytes)
+// of the main executable, the build ids are read during the deep scan
+func getLocalBuildIds(title *db.SwitchGameFiles) map[string]int {
+	result := map[string]int{}
+	addBuildId := func(file db.SwitchFileInfo, version int) {
+		if file.Metadata != nil && len(file.Metadata.BuildId) >= 16 {
+			result[strings.ToUpper(file.Metadata.BuildId[:16])] = version
+		}
+	}
+	addBuildId(title.File, 0)
+	for version, update := range title.Updates {
+		addBuildId(update, version)
+	}
+	return result
+}
+
+// ExportAtmosphereMods copies the mods of the given titles (all the owned titles when none is given) to an Atmosphère
+// folder tree (<output>/atmosphere/contents/<title id>), cheats that don't match a local version are not exported
+func ExportAtmosphereMods(mods []*TitleMods, titleIds []string, outputFolder string, updateProgress db.ProgressUpdater) (int, error) {
+	selected := map[string]bool{}
+	for _, titleId := range titleIds {
+		selected[strings.ToLower(titleId)] = true
+	}
+	exported := 0
+	for i, titleMods := range mods {
+		if (len(titleIds) == 0 && !titleMods.Owned) || (len(titleIds) != 0 && !selected[titleMods.TitleId]) {
+			continue
+		}
+		delete(selected, titleMods.TitleId)
+		if updateProgress != nil {
+			updateProgress.UpdateProgress(i+1, len(mods), "exporting mods of "+titleMods.TitleId)
+		}
+		targetFolder := filepath.Join(outputFolder, "atmosphere", "contents", strings.ToUpper(titleMods.TitleId))
+		for _, cheat := range titleMods.Cheats {
+			if cheat.Version == nil {
+				zap.S().Infof("Skipping cheat [%v], the build id doesn't match a local version\n", cheat.Path)
+				continue
+			}
+			err := copyFile(cheat.Path, filepath.Join(targetFolder, "cheats", cheat.BuildId+".txt"))
+			if err != nil {
+				return exported, err
+			}
+		}
+		for _, folder := range []string{"romfs", "exefs"} {
+			if !isDir(filepath.Join(titleMods.Folder, folder)) {
+				continue
+			}
+			err := copyFolder(filepath.Join(titleMods.Folder, folder), filepath.Join(targetFolder, folder))
+			if err != nil {
+				return exported, err
+			}
+		}
+		exported++
+	}
+	if len(selected) != 0 {
+		var missing []string
+		for titleId := range selected {
+			missing = append(missing, titleId)
+		}
+		sort.Strings(missing)
+		return exported, fmt.Errorf("no mods were found for %v", missing)
+	}
+	return exported, nil
+}
+
+func copyFolder(from string, to string) error {
+	return filepath.WalkDir(from, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return os.MkdirAll(filepath.Join(to, relativePath), os.ModePerm)
+		}
+		return copyFile(path, filepath.Join(to, relativePath))
+	})
+}
+
+func copyFile(from string, to string) error {
+	input, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	err = os.MkdirAll(filepath.Dir(to), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	output, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(output, input)
+	if err != nil {
+		output.Close()
+		return err
+	}
+	return output.Close()
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
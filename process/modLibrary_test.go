@@ -0,0 +1,72 @@
+package process
+
+import (
+	"github.com/giwty/switch-library-manager/db"
+	"github.com/giwty/switch-library-manager/switchfs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestModLibrary(t *testing.T) {
+	modsFolder := t.TempDir()
+	titleFolder := filepath.Join(modsFolder, "contents", "0100000000010000")
+	writeTestFile(t, filepath.Join(titleFolder, "cheats", "AABBCCDDEEFF0011.txt"), "[cheat]")
+	writeTestFile(t, filepath.Join(titleFolder, "cheats", "1122334455667788.txt"), "[old cheat]")
+	writeTestFile(t, filepath.Join(titleFolder, "romfs", "data", "file.bin"), "mod")
+	writeTestFile(t, filepath.Join(modsFolder, "contents", "0100000000020000", "exefs", "main.npdm"), "npdm")
+
+	localDB := &db.LocalSwitchFilesDB{TitlesMap: map[string]*db.SwitchGameFiles{
+		"010000000001": {
+			BaseExist: true,
+			File: db.SwitchFileInfo{ExtendedInfo: db.ExtendedFileInfo{FileName: "Game [0100000000010000][v0].nsp"},
+				Metadata: &switchfs.ContentMetaAttributes{TitleId: "0100000000010000", BuildId: "0011223344556677"}},
+			Updates: map[int]db.SwitchFileInfo{
+				0x10000: {Metadata: &switchfs.ContentMetaAttributes{TitleId: "0100000000010800", Version: 0x10000,
+					BuildId: "AABBCCDDEEFF00112233445566778899"}},
+			},
+		},
+	}}
+
+	mods, err := ScanModLibrary(modsFolder, localDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mods) != 2 || !mods[0].Owned || mods[1].Owned || !mods[0].RomFs || !mods[1].ExeFs {
+		t.Fatalf("unexpected mods %+v", mods)
+	}
+	if len(mods[0].Cheats) != 2 || mods[0].Cheats[0].Version != nil || mods[0].Cheats[1].Version == nil ||
+		*mods[0].Cheats[1].Version != 0x10000 {
+		t.Fatalf("unexpected cheats %+v", mods[0].Cheats)
+	}
+
+	outputFolder := t.TempDir()
+	exported, err := ExportAtmosphereMods(mods, nil, outputFolder, nil)
+	if err != nil || exported != 1 {
+		t.Fatalf("expected 1 exported title, got %v %v", exported, err)
+	}
+	exportedFolder := filepath.Join(outputFolder, "atmosphere", "contents", "0100000000010000")
+	for _, path := range []string{"cheats/AABBCCDDEEFF0011.txt", "romfs/data/file.bin"} {
+		if _, err := os.Stat(filepath.Join(exportedFolder, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(exportedFolder, "cheats", "1122334455667788.txt")); err == nil {
+		t.Fatal("the cheat without a matching version was exported")
+	}
+
+	_, err = ExportAtmosphereMods(mods, []string{"0100000000030000"}, t.TempDir(), nil)
+	if err == nil {
+		t.Fatal("expected an error for a title without mods")
+	}
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err == nil {
+		err = os.WriteFile(path, []byte(content), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
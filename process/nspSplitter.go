@@ -0,0 +1,162 @@
+package process
+
+import (
+	"errors"
+	"github.com/giwty/switch-library-manager/db"
+	"github.com/giwty/switch-library-manager/switchfs"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SplitNsp writes a standalone NSP for every title (base, update, DLC) in a multi-content NSP/NSZ, each NSP holds
+// only the NCAs referenced by its cnmt. The NSPs are verified and added to the library, the source file is deleted and
+// removed from the library (when keepSource is set it stays on disk and in the library). Valid NSPs left by a previous
+// run are kept as is.
+func SplitNsp(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	file db.ExtendedFileInfo,
+	keepSource bool,
+	updateProgress db.ProgressUpdater) ([]db.ExtendedFileInfo, error) {
+
+	fileName := strings.ToLower(file.FileName)
+	if !strings.HasSuffix(fileName, ".nsp") && !strings.HasSuffix(fileName, ".nsz") {
+		return nil, errors.New("file is not an NSP/NSZ")
+	}
+	filePath := filepath.Join(file.BaseFolder, file.FileName)
+	container, err := switchfs.OpenContainer(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer container.Close()
+
+	nsps, err := switchfs.SplitNsp(container)
+	if err != nil {
+		return nil, err
+	}
+	sourceInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var newFiles []db.ExtendedFileInfo
+	//the files written by this split, removed if any of the titles fails
+	var writtenPaths []string
+	//an output named like the source (e.g. the base title of the NSP) is written to a temporary file,
+	//which replaces the source once all the titles were written
+	replacedSourcePath := ""
+	for _, nsp := range nsps {
+		newFile := db.ExtendedFileInfo{FileName: getConvertedNspName(file.FileName, nsp), BaseFolder: file.BaseFolder, Size: nsp.Size()}
+		newFilePath := filepath.Join(newFile.BaseFolder, newFile.FileName)
+		outputPath := newFilePath
+		if info, statErr := os.Stat(newFilePath); statErr == nil {
+			if !os.SameFile(info, sourceInfo) {
+				//left by a previous run, kept if it's valid
+				err = verifyFile(newFilePath)
+				if err != nil {
+					err = errors.New("[" + newFile.FileName + "] already exists, " + err.Error())
+					break
+				}
+				zap.S().Infof("%v already exists, skipping", newFile.FileName)
+				newFile.Size = info.Size()
+				newFiles = append(newFiles, newFile)
+				continue
+			}
+			if keepSource {
+				err = errors.New("[" + newFile.FileName + "] would replace the source file, it can't be kept")
+				break
+			}
+			outputPath = newFilePath + ".split"
+			os.Remove(outputPath)
+			replacedSourcePath = newFilePath
+		}
+		err = writeFile(outputPath, nsp, nsp.Size(), "splitting "+newFile.FileName, updateProgress)
+		if err != nil {
+			break
+		}
+		writtenPaths = append(writtenPaths, outputPath)
+		if updateProgress != nil {
+			updateProgress.UpdateProgress(0, 0, "verifying "+newFile.FileName)
+		}
+		err = verifyFile(outputPath)
+		if err != nil {
+			break
+		}
+		newFiles = append(newFiles, newFile)
+	}
+	container.Close()
+	if err != nil {
+		//the source is only replaced when all the titles were written
+		for _, path := range writtenPaths {
+			os.Remove(path)
+		}
+		return nil, err
+	}
+
+	if replacedSourcePath != "" {
+		err = os.Remove(filePath)
+		if err == nil {
+			err = os.Rename(replacedSourcePath+".split", replacedSourcePath)
+		}
+		if err != nil {
+			return nil, errors.New("failed to replace the source file - " + err.Error())
+		}
+	}
+
+	if localDbManager != nil && localDB != nil {
+		if !keepSource {
+			err = localDbManager.RemoveFile(localDB, file)
+		}
+		if err == nil {
+			//outputs of a previous run that are already in the library are skipped by AddFiles
+			err = localDbManager.AddFiles(localDB, newFiles)
+		}
+		if err != nil {
+			zap.S().Warnf("failed to update the library - %v", err)
+		}
+	}
+
+	if !keepSource && replacedSourcePath == "" {
+		zap.S().Infof("Deleting file: %v \n", filePath)
+		err = os.Remove(filePath)
+		if err != nil {
+			zap.S().Errorf("Failed to delete file  %v  [%v]\n", filePath, err)
+		}
+	}
+	return newFiles, nil
+}
+
+// SplitLibrary splits all the multi-content NSP/NSZ files in the library
+func SplitLibrary(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	keepSource bool,
+	updateProgress db.ProgressUpdater) (int, error) {
+	//a multi-content file is referenced by more than one title/update/DLC entry
+	count := map[db.ExtendedFileInfo]int{}
+	var files []db.ExtendedFileInfo
+	for _, title := range localDB.TitlesMap {
+		for _, file := range append([]db.SwitchFileInfo{title.File}, getFiles(title)...) {
+			fileName := strings.ToLower(file.ExtendedInfo.FileName)
+			if file.ExtendedInfo.FileName == "" ||
+				(!strings.HasSuffix(fileName, ".nsp") && !strings.HasSuffix(fileName, ".nsz")) {
+				continue
+			}
+			count[file.ExtendedInfo]++
+			if (title.MultiContent || count[file.ExtendedInfo] > 1) && !containsFile(files, file.ExtendedInfo) {
+				files = append(files, file.ExtendedInfo)
+			}
+		}
+	}
+	var lastErr error
+	split := 0
+	for _, file := range files {
+		_, err := SplitNsp(localDbManager, localDB, file, keepSource, updateProgress)
+		if err != nil {
+			zap.S().Errorf("Failed to split %v [%v]\n", file.FileName, err)
+			lastErr = err
+			continue
+		}
+		split++
+	}
+	return split, lastErr
+}
@@ -0,0 +1,307 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"github.com/giwty/switch-library-manager/db"
+	"github.com/giwty/switch-library-manager/settings"
+	"github.com/giwty/switch-library-manager/switchfs"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	copyBufferSize = 0x400000
+)
+
+// DecompressFile converts an NSZ/XCZ file to NSP/XCI, the NCA hashes of the new file are verified against
+// the cnmt before the library is updated and the source file is removed (unless keepSource is set)
+func DecompressFile(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	file db.ExtendedFileInfo,
+	keepSource bool,
+	updateProgress db.ProgressUpdater) (*db.ExtendedFileInfo, error) {
+
+	if !isCompressedFile(file.FileName) {
+		return nil, errors.New("file is not an NSZ/XCZ")
+	}
+	filePath := filepath.Join(file.BaseFolder, file.FileName)
+	decompressed, err := switchfs.OpenDecompressed(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer decompressed.Close()
+
+	newFile := db.ExtendedFileInfo{FileName: switchfs.GetDecompressedFileName(file.FileName),
+		BaseFolder: file.BaseFolder, Size: decompressed.Size()}
+	err = writeFile(filepath.Join(newFile.BaseFolder, newFile.FileName), io.NewSectionReader(decompressed, 0, decompressed.Size()),
+		decompressed.Size(), "decompressing "+file.FileName, updateProgress)
+	if err != nil {
+		return nil, err
+	}
+
+	err = onFileConverted(localDbManager, localDB, file, newFile, keepSource, updateProgress)
+	if err != nil {
+		return nil, err
+	}
+	return &newFile, nil
+}
+
+// CompressFile converts an NSP/XCI file to NSZ/XCZ, the new file is verified by decompressing the NCZ files and comparing
+// the NCA hashes to the cnmt, before the library is updated and the source file is removed (unless keepSource is set)
+func CompressFile(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	file db.ExtendedFileInfo,
+	options settings.CompressOptions,
+	keepSource bool,
+	updateProgress db.ProgressUpdater) (*db.ExtendedFileInfo, error) {
+
+	compress := switchfs.CompressNsp
+	if strings.HasSuffix(strings.ToLower(file.FileName), ".xci") {
+		compress = switchfs.CompressXci
+	} else if !strings.HasSuffix(strings.ToLower(file.FileName), ".nsp") {
+		return nil, errors.New("file is not an NSP/XCI")
+	}
+	filePath := filepath.Join(file.BaseFolder, file.FileName)
+	input, err := switchfs.OpenFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	newFile := db.ExtendedFileInfo{FileName: switchfs.GetCompressedFileName(file.FileName), BaseFolder: file.BaseFolder}
+	newFilePath := filepath.Join(newFile.BaseFolder, newFile.FileName)
+	if _, err := os.Stat(newFilePath); err == nil {
+		return nil, errors.New("file [" + newFilePath + "] already exists")
+	}
+	tempPath := newFilePath + ".tmp"
+	output, err := os.Create(tempPath)
+	if err != nil {
+		return nil, err
+	}
+	progressReader := &progressReaderAt{reader: input, total: file.Size, message: "compressing " + file.FileName, updateProgress: updateProgress}
+	err = compress(progressReader, output,
+		switchfs.CompressOptions{Level: options.Level, BlockMode: options.BlockMode, BlockSizeExponent: options.BlockSizeExponent})
+	if err == nil {
+		var info os.FileInfo
+		info, err = output.Stat()
+		if err == nil {
+			newFile.Size = info.Size()
+		}
+	}
+	closeErr := output.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, newFilePath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+
+	err = onFileConverted(localDbManager, localDB, file, newFile, keepSource, updateProgress)
+	if err != nil {
+		return nil, err
+	}
+	zap.S().Infof("Compressed %v, saved %v bytes\n", file.FileName, file.Size-newFile.Size)
+	return &newFile, nil
+}
+
+// CompressLibrary converts all the NSP/XCI files in the library to NSZ/XCZ
+func CompressLibrary(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	options settings.CompressOptions,
+	keepSource bool,
+	updateProgress db.ProgressUpdater) (int, error) {
+	var lastErr error
+	converted := 0
+	for _, file := range append(getLibraryFiles(localDB, ".nsp"), getLibraryFiles(localDB, ".xci")...) {
+		_, err := CompressFile(localDbManager, localDB, file, options, keepSource, updateProgress)
+		if err != nil {
+			zap.S().Errorf("Failed to compress %v [%v]\n", file.FileName, err)
+			lastErr = err
+			continue
+		}
+		converted++
+	}
+	return converted, lastErr
+}
+
+// GetSpaceSaved returns the space saved by compressing the files (base, updates and DLC) of the title
+func GetSpaceSaved(localDbManager *db.LocalSwitchDBManager, title *db.SwitchGameFiles) int64 {
+	var result int64
+	var files []db.ExtendedFileInfo
+	for _, file := range append([]db.SwitchFileInfo{title.File}, getFiles(title)...) {
+		if isCompressedFile(file.ExtendedInfo.FileName) && !containsFile(files, file.ExtendedInfo) {
+			files = append(files, file.ExtendedInfo)
+			result += localDbManager.GetOriginalSize(file.ExtendedInfo) - file.ExtendedInfo.Size
+		}
+	}
+	return result
+}
+
+// DecompressLibrary converts all the NSZ/XCZ files in the library to NSP/XCI
+func DecompressLibrary(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	keepSource bool,
+	updateProgress db.ProgressUpdater) (int, error) {
+	var lastErr error
+	converted := 0
+	for _, file := range append(getLibraryFiles(localDB, ".nsz"), getLibraryFiles(localDB, ".xcz")...) {
+		_, err := DecompressFile(localDbManager, localDB, file, keepSource, updateProgress)
+		if err != nil {
+			zap.S().Errorf("Failed to decompress %v [%v]\n", file.FileName, err)
+			lastErr = err
+			continue
+		}
+		converted++
+	}
+	return converted, lastErr
+}
+
+// onFileConverted verifies the converted file, and replaces the source file in the library
+func onFileConverted(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	file db.ExtendedFileInfo,
+	newFile db.ExtendedFileInfo,
+	keepSource bool,
+	updateProgress db.ProgressUpdater) error {
+
+	newFilePath := filepath.Join(newFile.BaseFolder, newFile.FileName)
+	if updateProgress != nil {
+		updateProgress.UpdateProgress(0, 0, "verifying "+newFile.FileName)
+	}
+	err := verifyFile(newFilePath)
+	if err != nil {
+		os.Remove(newFilePath)
+		return err
+	}
+
+	if localDB != nil && localDbManager != nil {
+		err = localDbManager.ReplaceFile(localDB, file, newFile)
+		if err != nil {
+			zap.S().Warnf("failed to update the library - %v", err)
+		}
+	}
+
+	if keepSource {
+		return nil
+	}
+	filePath := filepath.Join(file.BaseFolder, file.FileName)
+	zap.S().Infof("Deleting file: %v \n", filePath)
+	err = os.Remove(filePath)
+	if err != nil {
+		zap.S().Errorf("Failed to delete file  %v  [%v]\n", filePath, err)
+	}
+	return nil
+}
+
+func verifyFile(filePath string) error {
+	results, err := switchfs.VerifyNcaHashes(filePath)
+	if err != nil {
+		return errors.New("failed to verify [" + filepath.Base(filePath) + "] - " + err.Error())
+	}
+	for _, result := range results {
+		if result.Missing {
+			return fmt.Errorf("verification failed, [%v] is missing", result.FileName)
+		}
+		if !result.IsValid() {
+			return fmt.Errorf("verification failed, hash mismatch for [%v]", result.FileName)
+		}
+	}
+	return nil
+}
+
+// writeFile writes the reader to a temporary file, which is renamed once all the data was written
+func writeFile(filePath string, reader io.Reader, size int64, message string, updateProgress db.ProgressUpdater) error {
+	if _, err := os.Stat(filePath); err == nil {
+		return errors.New("file [" + filePath + "] already exists")
+	}
+	tempPath := filePath + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyBuffer(&progressWriter{writer: file, total: size, message: message, updateProgress: updateProgress},
+		reader, make([]byte, copyBufferSize))
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, filePath)
+}
+
+type progressReaderAt struct {
+	reader         io.ReaderAt
+	read           int64
+	total          int64
+	message        string
+	updateProgress db.ProgressUpdater
+}
+
+func (p *progressReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := p.reader.ReadAt(b, off)
+	p.read += int64(n)
+	if p.updateProgress != nil && p.total != 0 && p.read <= p.total {
+		//progress is reported in MB
+		p.updateProgress.UpdateProgress(int(p.read>>20), int(p.total>>20), p.message)
+	}
+	return n, err
+}
+
+type progressWriter struct {
+	writer         io.Writer
+	written        int64
+	total          int64
+	message        string
+	updateProgress db.ProgressUpdater
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.writer.Write(b)
+	p.written += int64(n)
+	if p.updateProgress != nil && p.total != 0 {
+		//progress is reported in MB
+		p.updateProgress.UpdateProgress(int(p.written>>20), int(p.total>>20), p.message)
+	}
+	return n, err
+}
+
+// getLibraryFiles returns the files in the library with the given extension
+func getLibraryFiles(localDB *db.LocalSwitchFilesDB, extension string) []db.ExtendedFileInfo {
+	var files []db.ExtendedFileInfo
+	for _, v := range localDB.TitlesMap {
+		for _, file := range append([]db.SwitchFileInfo{v.File}, getFiles(v)...) {
+			if file.ExtendedInfo.FileName == "" || containsFile(files, file.ExtendedInfo) {
+				continue
+			}
+			if strings.HasSuffix(strings.ToLower(file.ExtendedInfo.FileName), extension) {
+				files = append(files, file.ExtendedInfo)
+			}
+		}
+	}
+	return files
+}
+
+func containsFile(files []db.ExtendedFileInfo, file db.ExtendedFileInfo) bool {
+	for _, f := range files {
+		if f == file {
+			return true
+		}
+	}
+	return false
+}
+
+func isCompressedFile(fileName string) bool {
+	fileName = strings.ToLower(fileName)
+	return strings.HasSuffix(fileName, ".nsz") || strings.HasSuffix(fileName, ".xcz")
+}
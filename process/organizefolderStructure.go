@@ -3,6 +3,7 @@ package process
 import (
 	"github.com/giwty/switch-library-manager/db"
 	"github.com/giwty/switch-library-manager/settings"
+	"github.com/giwty/switch-library-manager/switchfs"
 	"go.uber.org/zap"
 	"io/ioutil"
 	"os"
@@ -112,22 +113,25 @@ func OrganizeByFolders(baseFolder string,
 		}
 
 		if v.IsSplit {
-			//in case of a split file, we only rename the folder and then move all the split
-			//files with the new folder
-			files, err := ioutil.ReadDir(v.File.ExtendedInfo.BaseFolder)
+			//in case of a split file, the parts are moved as is (the folder holding the parts for the folder layout)
+			parts, err := switchfs.GetSplitFileParts(filepath.Join(v.File.ExtendedInfo.BaseFolder, v.File.ExtendedInfo.FileName))
 			if err != nil {
+				zap.S().Errorf("Failed to read split file [%v]\n", err)
 				continue
 			}
-
-			for _, file := range files {
-				if _, err := strconv.Atoi(file.Name()[len(file.Name())-1:]); err == nil {
-					from := filepath.Join(v.File.ExtendedInfo.BaseFolder, file.Name())
-					to := filepath.Join(destinationPath, file.Name())
-					err := moveFile(from, to)
-					if err != nil {
-						zap.S().Errorf("Failed to move file [%v]\n", err)
-						continue
-					}
+			if parts.Layout == switchfs.SplitLayout_Folder {
+				from := strings.TrimSuffix(parts.Folder, string(os.PathSeparator))
+				err := moveFile(from, filepath.Join(destinationPath, parts.Name))
+				if err != nil {
+					zap.S().Errorf("Failed to move file [%v]\n", err)
+				}
+				continue
+			}
+			for _, part := range parts.Parts {
+				err := moveFile(part, filepath.Join(destinationPath, filepath.Base(part)))
+				if err != nil {
+					zap.S().Errorf("Failed to move file [%v]\n", err)
+					continue
 				}
 			}
 			continue
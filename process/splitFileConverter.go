@@ -0,0 +1,219 @@
+package process
+
+import (
+	"errors"
+	"github.com/giwty/switch-library-manager/db"
+	"github.com/giwty/switch-library-manager/switchfs"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SplitFileForFat32 splits the file to parts of 4 GiB - 1 (in the folder layout name.nsp/00, or the flat layout
+// name.nsp.00), the parts are verified and replace the source file in the library, the source file is removed
+// (unless keepSource is set, which is not possible for the folder layout, as the folder has the name of the file)
+func SplitFileForFat32(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	file db.ExtendedFileInfo,
+	layout int,
+	keepSource bool,
+	updateProgress db.ProgressUpdater) (*db.ExtendedFileInfo, error) {
+
+	if switchfs.IsSplitFileName(file.FileName) {
+		return nil, errors.New("file is already split")
+	}
+	if !isSupportedFile(file.FileName) {
+		return nil, errors.New("file is not an NSP/NSZ or XCI/XCZ")
+	}
+	if layout == switchfs.SplitLayout_Folder && keepSource {
+		return nil, errors.New("the source file can't be kept with the folder layout")
+	}
+	filePath := filepath.Join(file.BaseFolder, file.FileName)
+	input, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	//the folder layout is written next to the source file, and renamed once the source file is removed
+	targetPath := filePath
+	if layout == switchfs.SplitLayout_Folder {
+		targetPath = filePath + ".tmp"
+	}
+	reader := io.TeeReader(input, &progressWriter{writer: io.Discard, total: file.Size, message: "splitting " + file.FileName, updateProgress: updateProgress})
+	parts, err := switchfs.WriteSplitFile(reader, targetPath, layout, switchfs.SplitFileChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	input.Close()
+	removeParts := func() {
+		for _, part := range parts {
+			os.Remove(part)
+		}
+		if layout == switchfs.SplitLayout_Folder {
+			os.Remove(targetPath)
+		}
+	}
+	if updateProgress != nil {
+		updateProgress.UpdateProgress(0, 0, "verifying "+file.FileName)
+	}
+	err = verifyFile(parts[0])
+	if err != nil {
+		removeParts()
+		return nil, err
+	}
+
+	if !keepSource {
+		zap.S().Infof("Deleting file: %v \n", filePath)
+		err = os.Remove(filePath)
+		if err != nil {
+			if layout == switchfs.SplitLayout_Folder {
+				removeParts()
+				return nil, err
+			}
+			zap.S().Errorf("Failed to delete file  %v  [%v]\n", filePath, err)
+		}
+	}
+	if layout == switchfs.SplitLayout_Folder {
+		err = os.Rename(targetPath, filePath)
+		if err != nil {
+			return nil, err
+		}
+		parts[0] = switchfs.GetSplitFilePartPath(filePath, layout, 0)
+	}
+
+	info, err := os.Stat(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	folder, fileName := filepath.Split(parts[0])
+	newFile := db.ExtendedFileInfo{FileName: fileName, BaseFolder: folder, Size: info.Size()}
+	if localDB != nil && localDbManager != nil {
+		err = localDbManager.ReplaceFile(localDB, file, newFile)
+		if err != nil {
+			zap.S().Warnf("failed to update the library - %v", err)
+		}
+	}
+	return &newFile, nil
+}
+
+// JoinSplitFile joins the parts of the split file (file is the first part), the joined file is verified and replaces
+// the parts in the library, the parts are removed (unless keepSource is set, which is not possible for the folder layout)
+func JoinSplitFile(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	file db.ExtendedFileInfo,
+	keepSource bool,
+	updateProgress db.ProgressUpdater) (*db.ExtendedFileInfo, error) {
+
+	parts, err := switchfs.GetSplitFileParts(filepath.Join(file.BaseFolder, file.FileName))
+	if err != nil {
+		return nil, err
+	}
+	if parts.Layout == switchfs.SplitLayout_Folder && keepSource {
+		return nil, errors.New("the parts can't be kept with the folder layout")
+	}
+	input, err := switchfs.NewSplitFileReader(parts.Parts[0])
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	//the joined file of the folder layout is placed next to the parts folder
+	folder := parts.Folder
+	if parts.Layout == switchfs.SplitLayout_Folder {
+		folder = filepath.Dir(strings.TrimSuffix(parts.Folder, string(os.PathSeparator)))
+	}
+	newFile := db.ExtendedFileInfo{FileName: parts.Name, BaseFolder: folder + string(os.PathSeparator), Size: parts.Size}
+	newFilePath := filepath.Join(folder, parts.Name)
+	targetPath := newFilePath
+	if parts.Layout == switchfs.SplitLayout_Folder {
+		targetPath = newFilePath + ".joined"
+	}
+	err = writeFile(targetPath, io.NewSectionReader(input, 0, parts.Size), parts.Size, "joining "+parts.Name, updateProgress)
+	if err != nil {
+		return nil, err
+	}
+	input.Close()
+	if updateProgress != nil {
+		updateProgress.UpdateProgress(0, 0, "verifying "+parts.Name)
+	}
+	err = verifyFile(targetPath)
+	if err != nil {
+		os.Remove(targetPath)
+		return nil, err
+	}
+
+	if !keepSource {
+		for _, part := range parts.Parts {
+			zap.S().Infof("Deleting file: %v \n", part)
+			err = os.Remove(part)
+			if err != nil {
+				zap.S().Errorf("Failed to delete file  %v  [%v]\n", part, err)
+			}
+		}
+	}
+	if parts.Layout == switchfs.SplitLayout_Folder {
+		err = os.Remove(parts.Folder)
+		if err == nil {
+			err = os.Rename(targetPath, newFilePath)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if localDB != nil && localDbManager != nil {
+		err = localDbManager.ReplaceFile(localDB, file, newFile)
+		if err != nil {
+			zap.S().Warnf("failed to update the library - %v", err)
+		}
+	}
+	return &newFile, nil
+}
+
+// SplitLibraryForFat32 splits all the files in the library that are larger than 4 GiB - 1
+func SplitLibraryForFat32(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	layout int,
+	keepSource bool,
+	updateProgress db.ProgressUpdater) (int, error) {
+	var lastErr error
+	split := 0
+	for _, file := range getLibraryFiles(localDB, "") {
+		if file.Size <= switchfs.SplitFileChunkSize || switchfs.IsSplitFileName(file.FileName) {
+			continue
+		}
+		_, err := SplitFileForFat32(localDbManager, localDB, file, layout, keepSource, updateProgress)
+		if err != nil {
+			zap.S().Errorf("Failed to split %v [%v]\n", file.FileName, err)
+			lastErr = err
+			continue
+		}
+		split++
+	}
+	return split, lastErr
+}
+
+// JoinLibrary joins all the split files in the library
+func JoinLibrary(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	keepSource bool,
+	updateProgress db.ProgressUpdater) (int, error) {
+	var lastErr error
+	joined := 0
+	for _, file := range getLibraryFiles(localDB, "") {
+		if !switchfs.IsSplitFileName(file.FileName) {
+			continue
+		}
+		_, err := JoinSplitFile(localDbManager, localDB, file, keepSource, updateProgress)
+		if err != nil {
+			zap.S().Errorf("Failed to join %v [%v]\n", file.FileName, err)
+			lastErr = err
+			continue
+		}
+		joined++
+	}
+	return joined, lastErr
+}
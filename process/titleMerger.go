@@ -0,0 +1,223 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"github.com/giwty/switch-library-manager/db"
+	"github.com/giwty/switch-library-manager/switchfs"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MergeTitle bundles the base, the latest update and all the DLCs of the title into a single NSP (or XCI), named
+// "<title name> [<title id>][v<latest update>][<n> DLC]" and placed next to the base file. The merged file is verified,
+// and replaces the source files in the library, the source files are removed (unless keepSource is set),
+// files holding titles that were not merged (e.g. an older update) are kept
+func MergeTitle(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	titlesDB *db.SwitchTitlesDB,
+	titleId string,
+	toXci bool,
+	keepSource bool,
+	updateProgress db.ProgressUpdater) (*db.ExtendedFileInfo, error) {
+
+	titleId = strings.ToLower(titleId)
+	if len(titleId) != 16 {
+		return nil, errors.New("invalid title id [" + titleId + "]")
+	}
+	idPrefix := titleId[0 : len(titleId)-4]
+	title, ok := localDB.TitlesMap[idPrefix]
+	if !ok || !title.BaseExist {
+		return nil, errors.New("base title [" + titleId + "] was not found in the library")
+	}
+	files := getMergeFiles(title)
+	for _, file := range files {
+		if file.Metadata == nil {
+			return nil, errors.New("[" + file.ExtendedInfo.FileName + "] was not read (prod.keys is required)")
+		}
+	}
+
+	containers := map[db.ExtendedFileInfo]*switchfs.Container{}
+	defer func() {
+		for _, container := range containers {
+			container.Close()
+		}
+	}()
+	var sources []switchfs.MergeSource
+	for _, file := range files {
+		container, ok := containers[file.ExtendedInfo]
+		if !ok {
+			var err error
+			container, err = switchfs.OpenContainer(filepath.Join(file.ExtendedInfo.BaseFolder, file.ExtendedInfo.FileName))
+			if err != nil {
+				return nil, err
+			}
+			containers[file.ExtendedInfo] = container
+		}
+		sources = append(sources, switchfs.MergeSource{Container: container, TitleId: file.Metadata.TitleId, Version: file.Metadata.Version})
+	}
+
+	var reader *io.SectionReader
+	var err error
+	if toXci {
+		if updateProgress != nil {
+			updateProgress.UpdateProgress(0, 0, "hashing "+title.File.ExtendedInfo.FileName)
+		}
+		reader, err = switchfs.MergeToXci(sources)
+	} else {
+		reader, err = switchfs.MergeToNsp(sources)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var switchTitle *db.SwitchTitle
+	if titlesDB != nil {
+		switchTitle = titlesDB.TitlesMap[idPrefix]
+	}
+	newFile := db.ExtendedFileInfo{FileName: getMergedFileName(switchTitle, title, toXci), BaseFolder: title.File.ExtendedInfo.BaseFolder, Size: reader.Size()}
+	newFilePath := filepath.Join(newFile.BaseFolder, newFile.FileName)
+	err = writeFile(newFilePath, reader, reader.Size(), "merging "+newFile.FileName, updateProgress)
+	if err != nil {
+		return nil, err
+	}
+	if updateProgress != nil {
+		updateProgress.UpdateProgress(0, 0, "verifying "+newFile.FileName)
+	}
+	err = verifyFile(newFilePath)
+	if err != nil {
+		os.Remove(newFilePath)
+		return nil, err
+	}
+	for _, container := range containers {
+		container.Close()
+	}
+
+	var mergedFiles []db.ExtendedFileInfo
+	if !keepSource {
+		mergedFiles = getFullyMergedFiles(localDB, files)
+	}
+	if localDbManager != nil {
+		for _, file := range mergedFiles {
+			err = localDbManager.RemoveFile(localDB, file)
+			if err != nil {
+				zap.S().Warnf("failed to update the library - %v", err)
+			}
+		}
+		err = localDbManager.AddFiles(localDB, []db.ExtendedFileInfo{newFile})
+		if err != nil {
+			zap.S().Warnf("failed to update the library - %v", err)
+		}
+	}
+	for _, file := range mergedFiles {
+		filePath := filepath.Join(file.BaseFolder, file.FileName)
+		zap.S().Infof("Deleting file: %v \n", filePath)
+		err = os.Remove(filePath)
+		if err != nil {
+			zap.S().Errorf("Failed to delete file  %v  [%v]\n", filePath, err)
+		}
+	}
+	return &newFile, nil
+}
+
+// MergeLibrary merges all the titles in the library that are spread over more than one file
+func MergeLibrary(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	titlesDB *db.SwitchTitlesDB,
+	toXci bool,
+	keepSource bool,
+	updateProgress db.ProgressUpdater) (int, error) {
+	var titleIds []string
+	for _, title := range localDB.TitlesMap {
+		if !title.BaseExist || title.File.Metadata == nil {
+			continue
+		}
+		var files []db.ExtendedFileInfo
+		for _, file := range getMergeFiles(title) {
+			if !containsFile(files, file.ExtendedInfo) {
+				files = append(files, file.ExtendedInfo)
+			}
+		}
+		if len(files) > 1 {
+			titleIds = append(titleIds, title.File.Metadata.TitleId)
+		}
+	}
+	var lastErr error
+	merged := 0
+	for _, titleId := range titleIds {
+		_, err := MergeTitle(localDbManager, localDB, titlesDB, titleId, toXci, keepSource, updateProgress)
+		if err != nil {
+			zap.S().Errorf("Failed to merge %v [%v]\n", titleId, err)
+			lastErr = err
+			continue
+		}
+		merged++
+	}
+	return merged, lastErr
+}
+
+// getMergeFiles returns the base, the latest update, and the DLCs (sorted by title id) of the title
+func getMergeFiles(title *db.SwitchGameFiles) []db.SwitchFileInfo {
+	files := []db.SwitchFileInfo{title.File}
+	if update, ok := title.Updates[title.LatestUpdate]; ok {
+		files = append(files, update)
+	}
+	var dlcIds []string
+	for id := range title.Dlc {
+		dlcIds = append(dlcIds, id)
+	}
+	sort.Strings(dlcIds)
+	for _, id := range dlcIds {
+		files = append(files, title.Dlc[id])
+	}
+	return files
+}
+
+// getFullyMergedFiles returns the source files that hold only titles included in the merged file
+func getFullyMergedFiles(localDB *db.LocalSwitchFilesDB, merged []db.SwitchFileInfo) []db.ExtendedFileInfo {
+	isMerged := func(file db.SwitchFileInfo) bool {
+		for _, m := range merged {
+			if m.ExtendedInfo == file.ExtendedInfo && m.Metadata == file.Metadata {
+				return true
+			}
+		}
+		return false
+	}
+	var sourceFiles []db.ExtendedFileInfo
+	for _, file := range merged {
+		if !containsFile(sourceFiles, file.ExtendedInfo) {
+			sourceFiles = append(sourceFiles, file.ExtendedInfo)
+		}
+	}
+	var result []db.ExtendedFileInfo
+	for _, sourceFile := range sourceFiles {
+		fullyMerged := true
+		for _, title := range localDB.TitlesMap {
+			for _, file := range append([]db.SwitchFileInfo{title.File}, getFiles(title)...) {
+				if file.ExtendedInfo == sourceFile && !isMerged(file) {
+					fullyMerged = false
+				}
+			}
+		}
+		if fullyMerged {
+			result = append(result, sourceFile)
+		}
+	}
+	return result
+}
+
+func getMergedFileName(switchTitle *db.SwitchTitle, title *db.SwitchGameFiles, toXci bool) string {
+	name := fmt.Sprintf("%v [%v][v%v]", getTitleName(switchTitle, title), strings.ToUpper(title.File.Metadata.TitleId), title.LatestUpdate)
+	if len(title.Dlc) != 0 {
+		name += fmt.Sprintf("[%v DLC]", len(title.Dlc))
+	}
+	name = folderIllegalCharsRegex.ReplaceAllString(name, "")
+	if toXci {
+		return name + ".xci"
+	}
+	return name + ".nsp"
+}
@@ -0,0 +1,95 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"github.com/giwty/switch-library-manager/db"
+	"github.com/giwty/switch-library-manager/switchfs"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConvertXciToNsp writes a standalone NSP for every title (application, patch, add-on content) in the XCI,
+// the NSPs are verified against their cnmt and added to the library, the XCI is kept
+func ConvertXciToNsp(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	file db.ExtendedFileInfo,
+	updateProgress db.ProgressUpdater) ([]db.ExtendedFileInfo, error) {
+
+	fileName := strings.ToLower(file.FileName)
+	if !strings.HasSuffix(fileName, ".xci") && !strings.HasSuffix(fileName, ".xcz") {
+		return nil, errors.New("file is not an XCI/XCZ")
+	}
+	container, err := switchfs.OpenContainer(filepath.Join(file.BaseFolder, file.FileName))
+	if err != nil {
+		return nil, err
+	}
+	defer container.Close()
+
+	nsps, err := switchfs.ConvertXciToNsp(container)
+	if err != nil {
+		return nil, err
+	}
+	var newFiles []db.ExtendedFileInfo
+	for _, nsp := range nsps {
+		newFile := db.ExtendedFileInfo{FileName: getConvertedNspName(file.FileName, nsp), BaseFolder: file.BaseFolder, Size: nsp.Size()}
+		newFilePath := filepath.Join(newFile.BaseFolder, newFile.FileName)
+		if _, statErr := os.Stat(newFilePath); statErr == nil {
+			zap.S().Infof("%v already exists, skipping", newFile.FileName)
+			continue
+		}
+		err = writeFile(newFilePath, nsp, nsp.Size(), "converting "+newFile.FileName, updateProgress)
+		if err != nil {
+			break
+		}
+		if updateProgress != nil {
+			updateProgress.UpdateProgress(0, 0, "verifying "+newFile.FileName)
+		}
+		err = verifyFile(newFilePath)
+		if err != nil {
+			os.Remove(newFilePath)
+			break
+		}
+		newFiles = append(newFiles, newFile)
+	}
+
+	if len(newFiles) != 0 && localDbManager != nil && localDB != nil {
+		dbErr := localDbManager.AddFiles(localDB, newFiles)
+		if dbErr != nil {
+			zap.S().Warnf("failed to update the library - %v", dbErr)
+		}
+	}
+	return newFiles, err
+}
+
+// ConvertXciLibrary converts all the XCI/XCZ files in the library to NSP
+func ConvertXciLibrary(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	updateProgress db.ProgressUpdater) (int, error) {
+	var lastErr error
+	converted := 0
+	files := append(getLibraryFiles(localDB, ".xci"), getLibraryFiles(localDB, ".xcz")...)
+	for _, file := range files {
+		newFiles, err := ConvertXciToNsp(localDbManager, localDB, file, updateProgress)
+		converted += len(newFiles)
+		if err != nil {
+			zap.S().Errorf("Failed to convert %v [%v]\n", file.FileName, err)
+			lastErr = err
+		}
+	}
+	return converted, lastErr
+}
+
+// getConvertedNspName returns the name of the NSP, based on the title name in the XCI file name
+// (example: Super Mario Odyssey [0100000000010000][v0].nsp)
+func getConvertedNspName(xciFileName string, nsp *switchfs.ConvertedNsp) string {
+	name := strings.TrimSuffix(xciFileName, filepath.Ext(xciFileName))
+	name = strings.TrimSpace(db.ParseTitleNameFromFileName(name))
+	name = fmt.Sprintf("%v [%v][v%v]", name, strings.ToUpper(nsp.TitleId), nsp.Version)
+	if nsp.Type == "UPD" || nsp.Type == "DLC" {
+		name += "[" + nsp.Type + "]"
+	}
+	return name + ".nsp"
+}
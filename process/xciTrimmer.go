@@ -0,0 +1,124 @@
+package process
+
+import (
+	"errors"
+	"github.com/giwty/switch-library-manager/db"
+	"github.com/giwty/switch-library-manager/switchfs"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TrimXciFile removes the padding after the valid data end of the XCI, the padding is checked before it's removed,
+// the library is updated with the new file size
+func TrimXciFile(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	file db.ExtendedFileInfo,
+	updateProgress db.ProgressUpdater) (*db.ExtendedFileInfo, error) {
+	return resizeXciFile(localDbManager, localDB, file, "trimming ", switchfs.TrimXci, updateProgress)
+}
+
+// UntrimXciFile restores the padding of the XCI to the size of a full dump of the gamecard,
+// the library is updated with the new file size
+func UntrimXciFile(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	file db.ExtendedFileInfo,
+	updateProgress db.ProgressUpdater) (*db.ExtendedFileInfo, error) {
+	return resizeXciFile(localDbManager, localDB, file, "untrimming ", switchfs.UntrimXci, updateProgress)
+}
+
+func resizeXciFile(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	file db.ExtendedFileInfo,
+	message string,
+	resize func(filePath string) (int64, error),
+	updateProgress db.ProgressUpdater) (*db.ExtendedFileInfo, error) {
+
+	if !strings.HasSuffix(strings.ToLower(file.FileName), ".xci") {
+		return nil, errors.New("file is not an XCI")
+	}
+	if updateProgress != nil {
+		updateProgress.UpdateProgress(0, 0, message+file.FileName)
+	}
+	filePath := filepath.Join(file.BaseFolder, file.FileName)
+	_, err := resize(filePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == file.Size {
+		return &file, nil
+	}
+	newFile := db.ExtendedFileInfo{FileName: file.FileName, BaseFolder: file.BaseFolder, Size: info.Size()}
+	if localDB != nil && localDbManager != nil {
+		err = localDbManager.ReplaceFile(localDB, file, newFile)
+		if err != nil {
+			zap.S().Warnf("failed to update the library - %v", err)
+		}
+	}
+	zap.S().Infof("Resized %v from %v to %v bytes\n", file.FileName, file.Size, newFile.Size)
+	return &newFile, nil
+}
+
+// TrimLibrary trims all the XCI files in the library
+func TrimLibrary(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	updateProgress db.ProgressUpdater) (int, error) {
+	return resizeLibrary(localDbManager, localDB, TrimXciFile, updateProgress)
+}
+
+// UntrimLibrary restores the padding of all the trimmed XCI files in the library
+func UntrimLibrary(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	updateProgress db.ProgressUpdater) (int, error) {
+	return resizeLibrary(localDbManager, localDB, UntrimXciFile, updateProgress)
+}
+
+func resizeLibrary(localDbManager *db.LocalSwitchDBManager,
+	localDB *db.LocalSwitchFilesDB,
+	resize func(*db.LocalSwitchDBManager, *db.LocalSwitchFilesDB, db.ExtendedFileInfo, db.ProgressUpdater) (*db.ExtendedFileInfo, error),
+	updateProgress db.ProgressUpdater) (int, error) {
+	var lastErr error
+	resized := 0
+	for _, file := range getLibraryFiles(localDB, ".xci") {
+		newFile, err := resize(localDbManager, localDB, file, updateProgress)
+		if err != nil {
+			zap.S().Errorf("Failed to resize %v [%v]\n", file.FileName, err)
+			lastErr = err
+			continue
+		}
+		if newFile.Size != file.Size {
+			resized++
+		}
+	}
+	return resized, lastErr
+}
+
+// GetTrimStatus returns the trimmed status ("yes"/"no", empty if the title has no XCI) of the XCI files of the title,
+// and the space that can be reclaimed by trimming them
+func GetTrimStatus(localDbManager *db.LocalSwitchDBManager, title *db.SwitchGameFiles) (string, int64) {
+	status := ""
+	var reclaimable int64
+	var files []db.ExtendedFileInfo
+	for _, file := range append([]db.SwitchFileInfo{title.File}, getFiles(title)...) {
+		if containsFile(files, file.ExtendedInfo) {
+			continue
+		}
+		files = append(files, file.ExtendedInfo)
+		trimInfo := localDbManager.GetXciTrimInfo(file.ExtendedInfo)
+		if trimInfo == nil {
+			continue
+		}
+		if trimInfo.Trimmed && status == "" {
+			status = "yes"
+		} else if !trimInfo.Trimmed {
+			status = "no"
+		}
+		reclaimable += trimInfo.ReclaimableSize
+	}
+	return status, reclaimable
+}
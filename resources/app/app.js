@@ -72,7 +72,14 @@ $(function () {
             }
             else if (message.name === "libraryLoaded") {
                 state.library = JSON.parse(message.payload);
-                loadTab("#library")
+                if (state.keys === "true"){
+                    sendMessage("validateKeys", "", function (message) {
+                        state.keysReport = message ? JSON.parse(message) : undefined;
+                        loadTab("#library")
+                    });
+                }else{
+                    loadTab("#library")
+                }
             }
             else if (message.name === "missingGames") {
                 state.missingGames = JSON.parse(message.payload);
@@ -149,6 +156,31 @@ $(function () {
         };
 
 
+        function getKeysWarning(report) {
+            if (!report || report.valid){
+                return ""
+            }
+            let issues = []
+            if (report.missing_keys && report.missing_keys.length){
+                issues.push("missing keys: " + report.missing_keys.join(", "))
+            }
+            if (report.invalid_keys && report.invalid_keys.length){
+                issues.push("invalid keys: " + report.invalid_keys.join(", "))
+            }
+            issues.push("header key check: " + report.header_key_check)
+            return issues.join(" | ")
+        }
+
+        function formatSize(bytes) {
+            if (!bytes){
+                return ""
+            }
+            if (bytes >= 1024 * 1024 * 1024){
+                return (bytes / (1024 * 1024 * 1024)).toFixed(2) + " GB"
+            }
+            return (bytes / (1024 * 1024)).toFixed(1) + " MB"
+        }
+
         function loadTab(target) {
             $(target).show();
             if (target === "#settings") {
@@ -266,6 +298,7 @@ $(function () {
                         num_skipped:state.library ? (state.library.issues ? state.library.issues.length : 0) : 0,
                         num_files:state.library ? state.library.num_files : 0,
                         keys:state.keys,
+                        keysWarning:getKeysWarning(state.keysReport),
                         scanFolders:state.settings.scan_folders
                     })
                 $(target).html(html);
@@ -287,6 +320,22 @@ $(function () {
                             {title: "Type", headerSort:true, field: "type"},
                             {title: "Update", headerSort:false, field: "update"},
                             {title: "Version", headerSort:false, field: "version"},
+                            {title: "Firmware", headerSort:false, field: "firmware"},
+                            {title: "Languages", headerSort:false, field: "languages", headerFilter:"input",formatter:"textarea"},
+                            {title: "Age rating", headerSort:false, field: "age_rating", headerFilter:"input",formatter:"textarea"},
+                            {title: "Save size", headerSort:true, field: "save_size",formatter:function(cell, formatterParams, onRendered){
+                                    return formatSize(cell.getValue())
+                                }
+                            },
+                            {title: "Space saved", headerSort:true, field: "space_saved",formatter:function(cell, formatterParams, onRendered){
+                                    return formatSize(cell.getValue())
+                                }
+                            },
+                            {title: "Trimmed", headerSort:true, field: "trimmed"},
+                            {title: "Reclaimable", headerSort:true, field: "reclaimable_space",formatter:function(cell, formatterParams, onRendered){
+                                    return formatSize(cell.getValue())
+                                }
+                            },
                             {title: "File name", headerSort:false, field: "path",formatter:"textarea",cellClick:function(e, cell){
                                     //e - the click event object
                                     //cell - cell component
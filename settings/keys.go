@@ -1,23 +1,78 @@
 package settings
 
 import (
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"github.com/giwty/switch-library-manager/switchfs/_crypto"
 	"github.com/magiconair/properties"
+	"go.uber.org/zap"
 	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	maxKeyRevision = 0x20
 )
 
 var (
 	keysInstance *switchKeys
+	keyAreaTypes = []string{"application", "ocean", "system"}
 )
 
 type switchKeys struct {
-	keys map[string]string
+	keys      map[string]string
+	derived   map[string]bool
+	path      string
+	titleKeys map[string]string
+}
+
+type KeysReport struct {
+	Path           string   `json:"path"`
+	NumKeys        int      `json:"num_keys"`
+	DerivedKeys    []string `json:"derived_keys"`
+	InvalidKeys    []string `json:"invalid_keys"`
+	MissingKeys    []string `json:"missing_keys"`
+	KeyGenerations []int    `json:"key_generations"`
+	NumTitleKeys   int      `json:"num_title_keys"`
+	HeaderKeyCheck string   `json:"header_key_check"`
+	Valid          bool     `json:"valid"`
 }
 
 func (k *switchKeys) GetKey(keyName string) string {
 	return k.keys[keyName]
 }
 
+// IsDerived returns true if the key was computed from the master keys, rather than loaded from prod.keys
+func (k *switchKeys) IsDerived(keyName string) bool {
+	return k.derived[keyName]
+}
+
+func (k *switchKeys) DerivedKeys() []string {
+	var result []string
+	for keyName := range k.derived {
+		result = append(result, keyName)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// GetTitleKey returns the (encrypted) title key for the given rights id, or "" if it's unknown
+func (k *switchKeys) GetTitleKey(rightsId string) string {
+	return k.titleKeys[strings.ToLower(rightsId)]
+}
+
+// AddTitleKey adds an (encrypted) title key to the key store, returns false if the rights id was already known
+func (k *switchKeys) AddTitleKey(rightsId string, titleKey string) bool {
+	rightsId = strings.ToLower(rightsId)
+	if _, ok := k.titleKeys[rightsId]; ok {
+		return false
+	}
+	k.titleKeys[rightsId] = strings.ToLower(titleKey)
+	return true
+}
+
 func SwitchKeys() (*switchKeys, error) {
 	return keysInstance, nil
 }
@@ -26,16 +81,19 @@ func InitSwitchKeys(baseFolder string) (*switchKeys, error) {
 
 	// init from a file
 	path := filepath.Join(baseFolder, "prod.keys")
+	keysPath := path
 	p, err := properties.LoadFile(path, properties.UTF8)
 	if err != nil {
 		path = "${HOME}/.switch/prod.keys"
+		keysPath = path
 		p, err = properties.LoadFile(path, properties.UTF8)
 	}
 	settings := ReadSettings(baseFolder)
 	if err != nil {
 		path := settings.Prodkeys
 		if path != "" {
-			p, err = properties.LoadFile(filepath.Join(path, "prod.keys"), properties.UTF8)
+			keysPath = filepath.Join(path, "prod.keys")
+			p, err = properties.LoadFile(keysPath, properties.UTF8)
 		}
 	}
 	if err != nil {
@@ -43,11 +101,170 @@ func InitSwitchKeys(baseFolder string) (*switchKeys, error) {
 	}
 	settings.Prodkeys = path
 	SaveSettings(settings, baseFolder)
-	keysInstance = &switchKeys{keys: map[string]string{}}
+	keysInstance = &switchKeys{keys: map[string]string{}, derived: map[string]bool{}, path: keysPath, titleKeys: map[string]string{}}
 	for _, key := range p.Keys() {
 		value, _ := p.Get(key)
 		keysInstance.keys[key] = value
 	}
 
+	keysInstance.deriveKeys()
+	keysInstance.loadTitleKeys(filepath.Join(filepath.Dir(keysPath), "title.keys"))
+
 	return keysInstance, nil
 }
+
+// loadTitleKeys loads the title.keys file (rights id = title key), the file is optional
+func (k *switchKeys) loadTitleKeys(path string) {
+	p, err := properties.LoadFile(path, properties.UTF8)
+	if err != nil {
+		zap.S().Debugf("title.keys was not loaded [reason:%v]", err)
+		return
+	}
+	for _, rightsId := range p.Keys() {
+		titleKey, _ := p.Get(rightsId)
+		if len(rightsId) != 0x20 || len(titleKey) != 0x20 {
+			zap.S().Warnf("ignoring invalid title key [%v]", rightsId)
+			continue
+		}
+		if _, err := hex.DecodeString(rightsId + titleKey); err != nil {
+			zap.S().Warnf("ignoring invalid title key [%v]", rightsId)
+			continue
+		}
+		k.AddTitleKey(rightsId, titleKey)
+	}
+	zap.S().Infof("loaded %v title keys from [%v]", len(k.titleKeys), path)
+}
+
+// Validate checks the loaded keys - hex format and length, the available key generations and the keys
+// required for a deep scan. The header_key check (decrypting an actual NCA) is done by the caller.
+func (k *switchKeys) Validate() *KeysReport {
+	report := &KeysReport{Path: k.path, NumKeys: len(k.keys), DerivedKeys: k.DerivedKeys(),
+		InvalidKeys: []string{}, MissingKeys: []string{}, KeyGenerations: []int{}, NumTitleKeys: len(k.titleKeys)}
+
+	for keyName, value := range k.keys {
+		key, err := hex.DecodeString(value)
+		if err != nil {
+			report.InvalidKeys = append(report.InvalidKeys, keyName+" (not a valid hex string)")
+			continue
+		}
+		if expectedSize := getExpectedKeySize(keyName); expectedSize != 0 && len(key) != expectedSize {
+			report.InvalidKeys = append(report.InvalidKeys,
+				fmt.Sprintf("%v (expected %v hex chars, found %v)", keyName, expectedSize*2, len(value)))
+		}
+	}
+	sort.Strings(report.InvalidKeys)
+
+	for i := 0; i < maxKeyRevision; i++ {
+		if k.keys[fmt.Sprintf("key_area_key_application_%02x", i)] != "" {
+			report.KeyGenerations = append(report.KeyGenerations, i)
+		}
+	}
+
+	if k.keys["header_key"] == "" {
+		report.MissingKeys = append(report.MissingKeys, "header_key")
+	}
+	if len(report.KeyGenerations) == 0 {
+		report.MissingKeys = append(report.MissingKeys, "key_area_key_application_00")
+	} else {
+		//report gaps, newer generations can't be used without the older ones
+		for i := 0; i < report.KeyGenerations[len(report.KeyGenerations)-1]; i++ {
+			keyName := fmt.Sprintf("key_area_key_application_%02x", i)
+			if k.keys[keyName] == "" {
+				report.MissingKeys = append(report.MissingKeys, keyName)
+			}
+		}
+	}
+	for _, generation := range report.KeyGenerations {
+		keyName := fmt.Sprintf("titlekek_%02x", generation)
+		if k.keys[keyName] == "" {
+			report.MissingKeys = append(report.MissingKeys, keyName)
+		}
+	}
+
+	report.Valid = len(report.InvalidKeys) == 0 && k.keys["header_key"] != "" && len(report.KeyGenerations) != 0
+	return report
+}
+
+func getExpectedKeySize(keyName string) int {
+	switch keyName {
+	case "header_key", "header_key_source", "sd_card_nca_key_source", "sd_card_save_key_source":
+		return 0x20
+	}
+	if strings.HasPrefix(keyName, "key_area_key_") ||
+		strings.HasPrefix(keyName, "titlekek_") ||
+		strings.HasPrefix(keyName, "master_key_") ||
+		strings.HasSuffix(keyName, "_source") {
+		return 0x10
+	}
+	return 0
+}
+
+// deriveKeys fills the key area keys, title keks and header key that are missing from prod.keys,
+// by computing them from the master keys and the key sources (same as the switch KEK generation).
+func (k *switchKeys) deriveKeys() {
+	kekSeed := k.getKeyBytes("aes_kek_generation_source")
+	keySeed := k.getKeyBytes("aes_key_generation_source")
+	titleKekSource := k.getKeyBytes("titlekek_source")
+
+	for i := 0; i < maxKeyRevision; i++ {
+		masterKey := k.getKeyBytes(fmt.Sprintf("master_key_%02x", i))
+		if masterKey == nil {
+			continue
+		}
+
+		if titleKekSource != nil {
+			k.addDerivedKey(fmt.Sprintf("titlekek_%02x", i), _crypto.DecryptAes128Ecb(titleKekSource, masterKey))
+		}
+
+		if kekSeed == nil || keySeed == nil {
+			continue
+		}
+		for _, keyArea := range keyAreaTypes {
+			keyAreaSource := k.getKeyBytes(fmt.Sprintf("key_area_key_%v_source", keyArea))
+			if keyAreaSource == nil {
+				continue
+			}
+			k.addDerivedKey(fmt.Sprintf("key_area_key_%v_%02x", keyArea, i), generateKek(keyAreaSource, masterKey, kekSeed, keySeed))
+		}
+	}
+
+	masterKey := k.getKeyBytes("master_key_00")
+	headerKekSource := k.getKeyBytes("header_kek_source")
+	headerKeySource := k.getKeyBytes("header_key_source")
+	if masterKey != nil && headerKekSource != nil && headerKeySource != nil && kekSeed != nil && keySeed != nil {
+		headerKek := generateKek(headerKekSource, masterKey, kekSeed, keySeed)
+		k.addDerivedKey("header_key", _crypto.DecryptAes128Ecb(headerKeySource, headerKek))
+	}
+}
+
+func (k *switchKeys) addDerivedKey(keyName string, value []byte) {
+	if k.keys[keyName] != "" {
+		return
+	}
+	zap.S().Infof("derived missing key [%v]", keyName)
+	k.keys[keyName] = hex.EncodeToString(value)
+	k.derived[keyName] = true
+}
+
+// getKeyBytes returns the decoded key, or nil if the key is not available (or is not a valid key)
+func (k *switchKeys) getKeyBytes(keyName string) []byte {
+	value := k.keys[keyName]
+	if value == "" {
+		return nil
+	}
+	key, err := hex.DecodeString(value)
+	if err != nil || (len(key) != 0x10 && len(key) != 0x20) {
+		zap.S().Warnf("ignoring invalid key [%v]", keyName)
+		return nil
+	}
+	return key
+}
+
+func generateKek(source []byte, masterKey []byte, kekSeed []byte, keySeed []byte) []byte {
+	kek := _crypto.DecryptAes128Ecb(kekSeed, masterKey)
+	sourceKek := _crypto.DecryptAes128Ecb(source, kek)
+	if keySeed != nil {
+		return _crypto.DecryptAes128Ecb(keySeed, sourceKek)
+	}
+	return sourceKek
+}
@@ -47,6 +47,12 @@ type OrganizeOptions struct {
 	FileNameTemplate     string `json:"file_name_template"`
 }
 
+type CompressOptions struct {
+	Level             int  `json:"level"`
+	BlockMode         bool `json:"block_mode"`
+	BlockSizeExponent int  `json:"block_size_exponent"`
+}
+
 type AppSettings struct {
 	VersionsEtag           string          `json:"versions_etag"`
 	TitlesEtag             string          `json:"titles_etag"`
@@ -61,6 +67,8 @@ type AppSettings struct {
 	ScanRecursively        bool            `json:"scan_recursively"`
 	GuiPagingSize          int             `json:"gui_page_size"`
 	IgnoreDLCTitleIds      []string        `json:"ignore_dlc_title_ids"`
+	CompressOptions        CompressOptions `json:"compress_options"`
+	ModsFolder             string          `json:"mods_folder"`
 }
 
 func ReadSettingsAsJSON(baseFolder string) string {
@@ -77,7 +85,8 @@ func ReadSettings(baseFolder string) *AppSettings {
 		return settingsInstance
 	}
 	settingsInstance = &AppSettings{Debug: false, GuiPagingSize: 100, ScanFolders: []string{},
-		OrganizeOptions: OrganizeOptions{SwitchSafeFileNames: true}, Prodkeys: "", IgnoreDLCTitleIds: []string{"01007F600B135007"}}
+		OrganizeOptions: OrganizeOptions{SwitchSafeFileNames: true}, Prodkeys: "", IgnoreDLCTitleIds: []string{"01007F600B135007"},
+		CompressOptions: CompressOptions{Level: 18, BlockSizeExponent: 20}}
 	if _, err := os.Stat(filepath.Join(baseFolder, SETTINGS_FILENAME)); err == nil {
 		file, err := os.Open(filepath.Join(baseFolder, SETTINGS_FILENAME))
 		if err != nil {
@@ -115,6 +124,11 @@ func saveDefaultSettings(baseFolder string) *AppSettings {
 			SwitchSafeFileNames:  true,
 			DeleteOldUpdateFiles: false,
 		},
+		CompressOptions: CompressOptions{
+			Level:             18,
+			BlockMode:         false,
+			BlockSizeExponent: 20,
+		},
 	}
 	return SaveSettings(settingsInstance, baseFolder)
 }
@@ -14,3 +14,16 @@ func DecryptAes128Ecb(data, key []byte) []byte {
 
 	return decrypted
 }
+
+func EncryptAes128Ecb(data, key []byte) []byte {
+
+	cipher, _ := aes.NewCipher([]byte(key))
+	encrypted := make([]byte, len(data))
+	size := 16
+
+	for bs, be := 0, size; bs < len(data); bs, be = bs+size, be+size {
+		cipher.Encrypt(encrypted[bs:be], data[bs:be])
+	}
+
+	return encrypted
+}
@@ -30,80 +30,178 @@ type Content struct {
 }
 
 type ContentMetaAttributes struct {
-	TitleId  string `json:"title_id"`
-	Version  int    `json:"version"`
-	Type     string `json:"type"`
-	Contents map[string]Content
-	Ncap     *Nacp
+	TitleId                       string `json:"title_id"`
+	Version                       int    `json:"version"`
+	Type                          string `json:"type"`
+	MetaType                      byte   `json:"meta_type"`
+	Attributes                    byte   `json:"attributes"`
+	RequiredDownloadSystemVersion int    `json:"required_download_system_version"`
+	RequiredSystemVersion         int    `json:"required_system_version"`
+	RequiredApplicationVersion    int    `json:"required_application_version"`
+	PatchId                       string `json:"patch_id"`       //the patch of applications
+	ApplicationId                 string `json:"application_id"` //the application of patches (the original id) and DLC
+	ExtendedDataSize              int    `json:"extended_data_size"`
+	SystemUpdateVersion           int    `json:"system_update_version"` //the firmware in the update partition of the XCI
+	BuildId                       string `json:"build_id"`              //the build id of the main executable (base/update)
+	SdkVersion                    string `json:"sdk_version"`           //the SDK version of the program NCA (base/update)
+	Contents                      []Content
+	ContentMetas                  []ContentMetaEntry
+	Digest                        string `json:"digest"`
+	Ncap                          *Nacp
+}
+
+// ContentMetaEntry is a title referenced by the cnmt (e.g. the titles of a system update)
+type ContentMetaEntry struct {
+	TitleId    string `json:"title_id"`
+	Version    int    `json:"version"`
+	Type       byte   `json:"type"`
+	Attributes byte   `json:"attributes"`
+}
+
+// GetContent returns the first content of the given type (e.g. Control), nil if there is none
+func (c *ContentMetaAttributes) GetContent(contentType string) *Content {
+	for i, content := range c.Contents {
+		if content.Type == contentType {
+			return &c.Contents[i]
+		}
+	}
+	return nil
+}
+
+// GetContents returns all the contents of the given type (e.g. all the Data NCAs)
+func (c *ContentMetaAttributes) GetContents(contentType string) []Content {
+	var result []Content
+	for _, content := range c.Contents {
+		if content.Type == contentType {
+			result = append(result, content)
+		}
+	}
+	return result
 }
 
 type ContentMeta struct {
-	XMLName                       xml.Name `xml:"ContentMeta"`
-	Text                          string   `xml:",chardata"`
-	Type                          string   `xml:"Type"`
-	ID                            string   `xml:"Id"`
-	Version                       int      `xml:"Version"`
-	RequiredDownloadSystemVersion string   `xml:"RequiredDownloadSystemVersion"`
-	Content                       []struct {
-		Text          string `xml:",chardata"`
-		Type          string `xml:"Type"`
-		ID            string `xml:"Id"`
-		Size          string `xml:"Size"`
-		Hash          string `xml:"Hash"`
-		KeyGeneration string `xml:"KeyGeneration"`
-	} `xml:"Content"`
-	Digest                string `xml:"Digest"`
-	KeyGenerationMin      string `xml:"KeyGenerationMin"`
-	RequiredSystemVersion string `xml:"RequiredSystemVersion"`
-	OriginalId            string `xml:"OriginalId"`
+	XMLName                       xml.Name  `xml:"ContentMeta"`
+	Text                          string    `xml:",chardata"`
+	Type                          string    `xml:"Type"`
+	ID                            string    `xml:"Id"`
+	Version                       int       `xml:"Version"`
+	RequiredDownloadSystemVersion string    `xml:"RequiredDownloadSystemVersion"`
+	Content                       []Content `xml:"Content"`
+	Digest                        string    `xml:"Digest"`
+	KeyGenerationMin              string    `xml:"KeyGenerationMin"`
+	RequiredSystemVersion         string    `xml:"RequiredSystemVersion"`
+	OriginalId                    string    `xml:"OriginalId,omitempty"`
+	PatchId                       string    `xml:"PatchId,omitempty"`
+	ApplicationId                 string    `xml:"ApplicationId,omitempty"`
+	RequiredApplicationVersion    string    `xml:"RequiredApplicationVersion,omitempty"`
 }
 
-func readBinaryCnmt(pfs0 *PFS0, data []byte) (*ContentMetaAttributes, error) {
-	if pfs0 == nil || len(pfs0.Files) != 1 {
-		return nil, errors.New("unexpected pfs0")
+// https://switchbrew.org/wiki/CNMT
+func readBinaryCnmt(cnmt []byte) (*ContentMetaAttributes, error) {
+	if len(cnmt) < 0x20 {
+		return nil, errors.New("invalid cnmt")
+	}
+	extendedHeaderSize := int(binary.LittleEndian.Uint16(cnmt[0xE:0x10]))
+	contentEntryCount := int(binary.LittleEndian.Uint16(cnmt[0x10:0x12]))
+	metaEntryCount := int(binary.LittleEndian.Uint16(cnmt[0x12:0x14]))
+	metaEntriesOffset := 0x20 + extendedHeaderSize + contentEntryCount*0x38
+	if len(cnmt) < metaEntriesOffset+metaEntryCount*0x10+0x20 {
+		return nil, errors.New("invalid cnmt, the content records exceed the cnmt size")
 	}
-	cnmtFile := pfs0.Files[0]
-	cnmt := data[int64(cnmtFile.StartOffset):]
 	titleId := binary.LittleEndian.Uint64(cnmt[0:0x8])
-	version := binary.LittleEndian.Uint32(cnmt[0x8:0xC])
-	tableOffset := binary.LittleEndian.Uint16(cnmt[0xE:0x10])
-	contentEntryCount := binary.LittleEndian.Uint16(cnmt[0x10:0x12])
-	//metaEntryCount := binary.LittleEndian.Uint16(cnmt[0x12:0x14])
-	contents := map[string]Content{}
-	for i := uint16(0); i < contentEntryCount; i++ {
-		position := 0x20 /*size of cnmt header*/ + tableOffset + (i * uint16(0x38))
-		ncaId := cnmt[position+0x20 : position+0x20+0x10]
-		//fmt.Println(fmt.Sprintf("0%x", ncaId))
-		contentType := ""
-		switch cnmt[position+0x36 : position+0x36+1][0] {
-		case 0:
-			contentType = "Meta"
-		case 1:
-			contentType = "Program"
-		case 2:
-			contentType = "Data"
-		case 3:
-			contentType = "Control"
-		case 4:
-			contentType = "HtmlDocument"
-		case 5:
-			contentType = "LegalInformation"
-		case 6:
-			contentType = "DeltaFragment"
-		}
-		contents[contentType] = Content{ID: fmt.Sprintf("%x", ncaId)}
+	result := &ContentMetaAttributes{
+		TitleId:                       fmt.Sprintf("0%x", titleId),
+		Version:                       int(binary.LittleEndian.Uint32(cnmt[0x8:0xC])),
+		MetaType:                      cnmt[0xC],
+		Attributes:                    cnmt[0x14],
+		RequiredDownloadSystemVersion: int(binary.LittleEndian.Uint32(cnmt[0x18:0x1C])),
+		Contents:                      readCnmtContents(cnmt),
+		Digest:                        fmt.Sprintf("%x", cnmt[len(cnmt)-0x20:]),
 	}
-	metaType := ""
-	switch cnmt[0xC:0xD][0] {
+	switch result.MetaType {
 	case ContentMetaType_Application:
-		metaType = "BASE"
+		result.Type = "BASE"
 	case ContentMetaType_AddOnContent:
-		metaType = "DLC"
+		result.Type = "DLC"
 	case ContentMetaType_Patch:
-		metaType = "UPD"
+		result.Type = "UPD"
 	}
 
-	return &ContentMetaAttributes{Contents: contents, Version: int(version), TitleId: fmt.Sprintf("0%x", titleId), Type: metaType}, nil
+	extendedHeader := cnmt[0x20 : 0x20+extendedHeaderSize]
+	switch {
+	case result.MetaType == ContentMetaType_Application && len(extendedHeader) >= 0x10:
+		result.PatchId = fmt.Sprintf("%016x", binary.LittleEndian.Uint64(extendedHeader[0x0:0x8]))
+		result.RequiredSystemVersion = int(binary.LittleEndian.Uint32(extendedHeader[0x8:0xC]))
+		result.RequiredApplicationVersion = int(binary.LittleEndian.Uint32(extendedHeader[0xC:0x10]))
+	case result.MetaType == ContentMetaType_Patch && len(extendedHeader) >= 0x10:
+		result.ApplicationId = fmt.Sprintf("%016x", binary.LittleEndian.Uint64(extendedHeader[0x0:0x8]))
+		result.RequiredSystemVersion = int(binary.LittleEndian.Uint32(extendedHeader[0x8:0xC]))
+		result.ExtendedDataSize = int(binary.LittleEndian.Uint32(extendedHeader[0xC:0x10]))
+	case result.MetaType == ContentMetaType_AddOnContent && len(extendedHeader) >= 0xC:
+		result.ApplicationId = fmt.Sprintf("%016x", binary.LittleEndian.Uint64(extendedHeader[0x0:0x8]))
+		result.RequiredApplicationVersion = int(binary.LittleEndian.Uint32(extendedHeader[0x8:0xC]))
+	case result.MetaType == ContentMetaType_Delta && len(extendedHeader) >= 0xC:
+		result.ApplicationId = fmt.Sprintf("%016x", binary.LittleEndian.Uint64(extendedHeader[0x0:0x8]))
+		result.ExtendedDataSize = int(binary.LittleEndian.Uint32(extendedHeader[0x8:0xC]))
+	case result.MetaType == ContentMetaType_SystemUpdate && len(extendedHeader) >= 0x4:
+		result.ExtendedDataSize = int(binary.LittleEndian.Uint32(extendedHeader[0x0:0x4]))
+	}
+
+	for i := 0; i < metaEntryCount; i++ {
+		entry := cnmt[metaEntriesOffset+i*0x10 : metaEntriesOffset+(i+1)*0x10]
+		result.ContentMetas = append(result.ContentMetas, ContentMetaEntry{
+			TitleId:    fmt.Sprintf("%016x", binary.LittleEndian.Uint64(entry[0x0:0x8])),
+			Version:    int(binary.LittleEndian.Uint32(entry[0x8:0xC])),
+			Type:       entry[0xC],
+			Attributes: entry[0xD],
+		})
+	}
+	return result, nil
+}
+
+// readCnmtContents returns the content records (NCA id, size, SHA-256 and type) of the binary cnmt
+func readCnmtContents(cnmt []byte) []Content {
+	tableOffset := binary.LittleEndian.Uint16(cnmt[0xE:0x10])
+	contentEntryCount := binary.LittleEndian.Uint16(cnmt[0x10:0x12])
+	var contents []Content
+	for i := 0; i < int(contentEntryCount); i++ {
+		position := 0x20 /*size of cnmt header*/ + int(tableOffset) + i*0x38
+		if position+0x38 > len(cnmt) {
+			break
+		}
+		hash := cnmt[position : position+0x20]
+		ncaId := cnmt[position+0x20 : position+0x20+0x10]
+		sizeBytes := make([]byte, 8)
+		copy(sizeBytes, cnmt[position+0x30:position+0x36])
+		contents = append(contents, Content{Type: getContentTypeName(cnmt[position+0x36]), ID: fmt.Sprintf("%x", ncaId),
+			Size: fmt.Sprintf("%v", binary.LittleEndian.Uint64(sizeBytes)), Hash: fmt.Sprintf("%x", hash)})
+	}
+	return contents
+}
+
+func getContentTypeName(contentType byte) string {
+	switch contentType {
+	case 0:
+		return "Meta"
+	case 1:
+		return "Program"
+	case 2:
+		return "Data"
+	case 3:
+		return "Control"
+	case 4:
+		return "HtmlDocument"
+	case 5:
+		return "LegalInformation"
+	case 6:
+		return "DeltaFragment"
+	}
+	return ""
+}
+
+// FormatSystemVersion returns the readable firmware version (e.g. 9.1.0) of the system version
+func FormatSystemVersion(version int) string {
+	return fmt.Sprintf("%v.%v.%v", (version>>26)&0x3F, (version>>20)&0x3F, (version>>16)&0xF)
 }
 
 func readXmlCnmt(xmlBytes []byte) (*ContentMetaAttributes, error) {
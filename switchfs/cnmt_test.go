@@ -0,0 +1,55 @@
+package switchfs
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestReadBinaryCnmt(t *testing.T) {
+	const extendedHeaderSize = 0x10
+	cnmt := make([]byte, 0x20+extendedHeaderSize+3*0x38+0x10+0x20)
+	binary.LittleEndian.PutUint64(cnmt[0x0:], 0x0100000000010000)
+	binary.LittleEndian.PutUint32(cnmt[0x8:], 0x10000)
+	cnmt[0xC] = ContentMetaType_Application
+	binary.LittleEndian.PutUint16(cnmt[0xE:], extendedHeaderSize)
+	binary.LittleEndian.PutUint16(cnmt[0x10:], 3)
+	binary.LittleEndian.PutUint16(cnmt[0x12:], 1)
+	binary.LittleEndian.PutUint64(cnmt[0x20:], 0x0100000000010800)
+	binary.LittleEndian.PutUint32(cnmt[0x28:], 0x24000000)
+	for i, contentType := range []byte{1, 2, 2} {
+		record := cnmt[0x30+i*0x38:]
+		record[0x20] = byte(i)
+		binary.LittleEndian.PutUint32(record[0x30:], uint32(0x1000*(i+1)))
+		record[0x36] = contentType
+	}
+	metaEntry := cnmt[0x30+3*0x38:]
+	binary.LittleEndian.PutUint64(metaEntry, 0x0100000000001000)
+	metaEntry[0xC] = ContentMetaType_SystemData
+	cnmt[len(cnmt)-1] = 0xAB
+
+	attributes, err := readBinaryCnmt(cnmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attributes.TitleId != "0100000000010000" || attributes.Type != "BASE" || attributes.Version != 0x10000 {
+		t.Fatalf("unexpected header %+v", attributes)
+	}
+	if attributes.PatchId != "0100000000010800" || FormatSystemVersion(attributes.RequiredSystemVersion) != "9.0.0" {
+		t.Fatalf("unexpected extended header %v %v", attributes.PatchId, attributes.RequiredSystemVersion)
+	}
+	data := attributes.GetContents("Data")
+	if len(attributes.Contents) != 3 || len(data) != 2 || data[1].ID != "02000000000000000000000000000000" || data[1].Size != "12288" {
+		t.Fatalf("unexpected contents %+v", attributes.Contents)
+	}
+	if len(attributes.ContentMetas) != 1 || attributes.ContentMetas[0].TitleId != "0100000000001000" {
+		t.Fatalf("unexpected content metas %+v", attributes.ContentMetas)
+	}
+	if attributes.Digest[len(attributes.Digest)-2:] != "ab" {
+		t.Fatalf("unexpected digest %v", attributes.Digest)
+	}
+
+	_, err = readBinaryCnmt(cnmt[:0x100])
+	if err == nil {
+		t.Fatal("expected an error for a truncated cnmt")
+	}
+}
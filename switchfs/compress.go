@@ -0,0 +1,458 @@
+package switchfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultCompressionLevel  = 18
+	DefaultBlockSizeExponent = 20
+	nczBlockVersion          = 2
+	nczBlockType             = 1
+	compressionChunkSize     = 0x400000
+	minCompressibleNcaSize   = 0x100000
+)
+
+type CompressOptions struct {
+	Level             int  //zstd level (1-22)
+	BlockMode         bool //block compression allows random access to the NCA, solid compression gives a better ratio
+	BlockSizeExponent int  //block size = 2^exponent (14-32)
+}
+
+// CompressNsp writes the NSP as NSZ - program and data NCAs are compressed to NCZ, other files are copied as is
+func CompressNsp(input io.ReaderAt, output io.WriteSeeker, options CompressOptions) error {
+	options, err := getCompressOptions(options)
+	if err != nil {
+		return err
+	}
+	pfs0, err := readPfs0(input, 0x0)
+	if err != nil {
+		return err
+	}
+	if pfs0.Magic != pfs0Magic {
+		return errors.New("file is not an NSP")
+	}
+	_, err = output.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = compressPartition(input, pfs0, 0, output, options)
+	return err
+}
+
+// CompressXci writes the XCI as XCZ - the NCAs of the secure partition are compressed like in CompressNsp,
+// the other partitions are copied as is, and the root partition and the gamecard header are updated
+func CompressXci(input io.ReaderAt, output io.WriteSeeker, options CompressOptions) error {
+	options, err := getCompressOptions(options)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 0x200)
+	_, err = input.ReadAt(header, 0)
+	if err != nil {
+		return err
+	}
+	if string(header[0x100:0x104]) != "HEAD" {
+		return errors.New("file is not an XCI")
+	}
+	rootPartitionOffset := int64(binary.LittleEndian.Uint64(header[0x130:0x138]))
+	rootHfs0, err := readPfs0(input, rootPartitionOffset)
+	if err != nil {
+		return err
+	}
+	if rootHfs0.Magic != hfs0Magic {
+		return errors.New("invalid XCI root partition")
+	}
+
+	entries := make([]partitionEntry, len(rootHfs0.Files))
+	for i, hfs0File := range rootHfs0.Files {
+		entries[i] = partitionEntry{name: hfs0File.Name, size: int64(hfs0File.Size), hashedRegionSize: hfs0File.HashedRegionSize,
+			reader: io.NewSectionReader(input, rootPartitionOffset+int64(hfs0File.StartOffset), int64(hfs0File.Size))}
+	}
+	rootPartition, err := newPartitionBuilder(hfs0Magic, entries, rootHfs0.StringTableSize)
+	if err != nil {
+		return err
+	}
+	_, err = output.Seek(rootPartitionOffset+rootPartition.HeaderSize(), io.SeekStart)
+	if err != nil {
+		return err
+	}
+	for i, hfs0File := range rootHfs0.Files {
+		offset := rootPartitionOffset + int64(hfs0File.StartOffset)
+		if hfs0File.Name != "secure" {
+			_, err = io.CopyBuffer(output, io.NewSectionReader(input, offset, int64(hfs0File.Size)), make([]byte, compressionChunkSize))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		secureHfs0, err := readPfs0(input, offset)
+		if err != nil {
+			return err
+		}
+		securePartition, err := compressPartition(input, secureHfs0, offset, output, options)
+		if err != nil {
+			return err
+		}
+		entries[i].reader = securePartition
+		entries[i].size = securePartition.Size()
+	}
+
+	rootPartition, err = newPartitionBuilder(hfs0Magic, entries, rootHfs0.StringTableSize)
+	if err != nil {
+		return err
+	}
+	xciHeader := make([]byte, rootPartitionOffset)
+	_, err = input.ReadAt(xciHeader, 0)
+	if err != nil {
+		return err
+	}
+	rootHeader := make([]byte, rootPartition.HeaderSize())
+	_, err = rootPartition.ReadAt(rootHeader, 0)
+	if err != nil {
+		return err
+	}
+	updateXciHeader(xciHeader, rootHeader, rootPartitionOffset+rootPartition.Size())
+	_, err = output.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(append(xciHeader, rootHeader...))
+	if err != nil {
+		return err
+	}
+	_, err = output.Seek(0, io.SeekEnd)
+	return err
+}
+
+func getCompressOptions(options CompressOptions) (CompressOptions, error) {
+	if options.Level <= 0 {
+		options.Level = DefaultCompressionLevel
+	}
+	if options.BlockSizeExponent == 0 {
+		options.BlockSizeExponent = DefaultBlockSizeExponent
+	}
+	if options.BlockSizeExponent < 14 || options.BlockSizeExponent > 32 {
+		return options, fmt.Errorf("invalid block size exponent [%v]", options.BlockSizeExponent)
+	}
+	return options, nil
+}
+
+// compressPartition writes the partition (header + files) at the current output position, program and data NCAs
+// are compressed to NCZ. The returned partition only serves the header, the NCZ entries read the original NCA,
+// which has the same hashed region, as the NCZ starts with the original NCA header.
+func compressPartition(input io.ReaderAt, pfs0 *PFS0, pfs0Offset int64, output io.WriteSeeker, options CompressOptions) (*partitionBuilder, error) {
+	start, err := output.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	titleKeys := readTitleKeys(input, pfs0, pfs0Offset)
+
+	entries := make([]partitionEntry, len(pfs0.Files))
+	compress := make([]bool, len(pfs0.Files))
+	for i, pfs0File := range pfs0.Files {
+		fileReader := io.NewSectionReader(input, pfs0Offset+int64(pfs0File.StartOffset), int64(pfs0File.Size))
+		entries[i] = partitionEntry{name: pfs0File.Name, size: int64(pfs0File.Size), reader: fileReader,
+			hashedRegionSize: pfs0File.HashedRegionSize}
+		compress[i], err = isCompressibleNca(fileReader, pfs0File.Name)
+		if err != nil {
+			return nil, errors.New("failed to read [" + pfs0File.Name + "] - " + err.Error())
+		}
+		if compress[i] {
+			if pfs0File.HashedRegionSize > nczHeaderSize {
+				return nil, fmt.Errorf("unsupported hashed region size [0x%x] for [%v]", pfs0File.HashedRegionSize, pfs0File.Name)
+			}
+			entries[i].name = pfs0File.Name[:len(pfs0File.Name)-len(".nca")] + ".ncz"
+		}
+	}
+
+	//the header size only depends on the file names, so the data can be written before the header
+	partition, err := newPartitionBuilder(pfs0.Magic, entries, pfs0.StringTableSize)
+	if err != nil {
+		return nil, err
+	}
+	_, err = output.Seek(start+partition.HeaderSize(), io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, pfs0File := range pfs0.Files {
+		fileReader := io.NewSectionReader(input, pfs0Offset+int64(pfs0File.StartOffset), int64(pfs0File.Size))
+		if compress[i] {
+			entries[i].size, err = compressNca(fileReader, int64(pfs0File.Size), titleKeys, output, options)
+			if err != nil {
+				return nil, errors.New("failed to compress [" + pfs0File.Name + "] - " + err.Error())
+			}
+			continue
+		}
+		_, err = io.CopyBuffer(output, fileReader, make([]byte, compressionChunkSize))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	partition, err = newPartitionBuilder(pfs0.Magic, entries, pfs0.StringTableSize)
+	if err != nil {
+		return nil, err
+	}
+	_, err = output.Seek(start, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, partition.HeaderSize())
+	_, err = partition.ReadAt(header, 0)
+	if err != nil {
+		return nil, err
+	}
+	_, err = output.Write(header)
+	if err != nil {
+		return nil, err
+	}
+	_, err = output.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	return partition, nil
+}
+
+// isCompressibleNca returns true for program and data NCAs
+func isCompressibleNca(reader *io.SectionReader, name string) (bool, error) {
+	if !strings.HasSuffix(strings.ToLower(name), ".nca") || strings.HasSuffix(strings.ToLower(name), ".cnmt.nca") ||
+		reader.Size() < minCompressibleNcaSize {
+		return false, nil
+	}
+	ncaHeader, err := readNcaHeader(reader, 0)
+	if err != nil {
+		return false, err
+	}
+	return ncaHeader.contentType == NcaContentType_Program ||
+		ncaHeader.contentType == NcaContentType_Data ||
+		ncaHeader.contentType == NcaContentType_PublicData, nil
+}
+
+// compressNca writes the NCA as NCZ - the header is kept as is, the sections are decrypted and compressed,
+// returns the size of the NCZ
+func compressNca(reader io.ReaderAt, size int64, titleKeys map[string][]byte, output io.WriteSeeker, options CompressOptions) (int64, error) {
+	start, err := output.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	sections, err := getNczSections(reader, size, titleKeys)
+	if err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, nczHeaderSize+0x10+nczSectionSize*len(sections))
+	_, err = reader.ReadAt(header[:nczHeaderSize], 0)
+	if err != nil {
+		return 0, err
+	}
+	copy(header[nczHeaderSize:], nczSectionMagic)
+	binary.LittleEndian.PutUint64(header[nczHeaderSize+0x8:], uint64(len(sections)))
+	for i, section := range sections {
+		sectionBytes := header[nczHeaderSize+0x10+nczSectionSize*i : nczHeaderSize+0x10+nczSectionSize*(i+1)]
+		binary.LittleEndian.PutUint64(sectionBytes[0x0:0x8], section.Offset)
+		binary.LittleEndian.PutUint64(sectionBytes[0x8:0x10], section.Size)
+		binary.LittleEndian.PutUint64(sectionBytes[0x10:0x18], section.CryptoType)
+		copy(sectionBytes[0x20:0x30], section.CryptoKey)
+		copy(sectionBytes[0x30:0x40], section.CryptoCounter)
+	}
+	_, err = output.Write(header)
+	if err != nil {
+		return 0, err
+	}
+
+	level := zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(options.Level))
+	if options.BlockMode {
+		err = compressNcaBlocks(reader, size, sections, output, options.BlockSizeExponent, level)
+	} else {
+		err = compressNcaSolid(reader, size, sections, output, level)
+	}
+	if err != nil {
+		return 0, err
+	}
+	end, err := output.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	return end - start, nil
+}
+
+// getNczSections returns the NCA sections (with the AES-CTR key and counter), the gaps between the sections
+// are added as unencrypted sections, so that the sections cover the NCA up to its end
+func getNczSections(reader io.ReaderAt, size int64, titleKeys map[string][]byte) ([]NczSection, error) {
+	ncaHeader, err := readNcaHeader(reader, 0)
+	if err != nil {
+		return nil, err
+	}
+	var titleKey []byte
+	if ncaHeader.HasRightsId() {
+		titleKey, err = getTitleKey(ncaHeader, titleKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var sections []NczSection
+	for i := 0; i < 4; i++ {
+		entry := getFsEntry(ncaHeader, i)
+		if entry.Size == 0 {
+			continue
+		}
+		fsHeader, err := getFsHeader(ncaHeader, i)
+		if err != nil {
+			return nil, err
+		}
+		section := NczSection{Offset: uint64(entry.StartOffset), Size: uint64(entry.Size),
+			CryptoType: FsEncryptionType_None, CryptoKey: make([]byte, 0x10), CryptoCounter: make([]byte, 0x10)}
+		switch fsHeader.encType {
+		case FsEncryptionType_None:
+		case FsEncryptionType_AesCtr, FsEncryptionType_AesCtrEx:
+			key, err := getSectionKey(ncaHeader, titleKey)
+			if err != nil {
+				return nil, err
+			}
+			//AesCtrEx sections are handled as AesCtr, the data may compress poorly, but it's restored as is
+			section.CryptoType = uint64(fsHeader.encType)
+			section.CryptoKey = key
+			copy(section.CryptoCounter, getSectionCounter(fsHeader))
+		default:
+			return nil, fmt.Errorf("non supported encryption type [encryption type:%v]", fsHeader.encType)
+		}
+		sections = append(sections, section)
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		return sections[i].Offset < sections[j].Offset
+	})
+
+	var result []NczSection
+	position := uint64(nczHeaderSize)
+	for _, section := range sections {
+		if section.Offset > position {
+			result = append(result, newPlainNczSection(position, section.Offset-position))
+		}
+		result = append(result, section)
+		if section.Offset+section.Size > position {
+			position = section.Offset + section.Size
+		}
+	}
+	if uint64(size) > position {
+		result = append(result, newPlainNczSection(position, uint64(size)-position))
+	}
+	return result, nil
+}
+
+func newPlainNczSection(offset uint64, size uint64) NczSection {
+	return NczSection{Offset: offset, Size: size, CryptoType: FsEncryptionType_None,
+		CryptoKey: make([]byte, 0x10), CryptoCounter: make([]byte, 0x10)}
+}
+
+// readDecrypted reads the NCA body (after the NCZ header) in chunks, and passes the decrypted chunks to the handler
+func readDecrypted(reader io.ReaderAt, size int64, sections []NczSection, chunkSize int64, handler func(chunk []byte) error) error {
+	buffer := make([]byte, chunkSize)
+	for offset := int64(nczHeaderSize); offset < size; offset += chunkSize {
+		chunk := buffer[:min64(chunkSize, size-offset)]
+		_, err := reader.ReadAt(chunk, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		applySectionCrypto(sections, chunk, offset)
+		err = handler(chunk)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compressNcaSolid(reader io.ReaderAt, size int64, sections []NczSection, output io.Writer, level zstd.EOption) error {
+	encoder, err := zstd.NewWriter(output, level)
+	if err != nil {
+		return err
+	}
+	err = readDecrypted(reader, size, sections, compressionChunkSize, func(chunk []byte) error {
+		_, err := encoder.Write(chunk)
+		return err
+	})
+	if err != nil {
+		encoder.Close()
+		return err
+	}
+	return encoder.Close()
+}
+
+func compressNcaBlocks(reader io.ReaderAt, size int64, sections []NczSection, output io.WriteSeeker, blockSizeExponent int, level zstd.EOption) error {
+	blockSize := int64(1) << blockSizeExponent
+	decompressedSize := size - nczHeaderSize
+	numberOfBlocks := (decompressedSize + blockSize - 1) / blockSize
+
+	blockHeader := make([]byte, nczBlockHeaderSize+4*numberOfBlocks)
+	copy(blockHeader, nczBlockMagic)
+	blockHeader[0x8] = nczBlockVersion
+	blockHeader[0x9] = nczBlockType
+	blockHeader[0xB] = byte(blockSizeExponent)
+	binary.LittleEndian.PutUint32(blockHeader[0xC:0x10], uint32(numberOfBlocks))
+	binary.LittleEndian.PutUint64(blockHeader[0x10:0x18], uint64(decompressedSize))
+
+	//the block sizes are only known after compression, the table is written at the end
+	headerOffset, err := output.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(blockHeader)
+	if err != nil {
+		return err
+	}
+
+	encoder, err := zstd.NewWriter(nil, level, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return err
+	}
+	defer encoder.Close()
+	blockIndex := 0
+	compressed := make([]byte, 0, blockSize)
+	err = readDecrypted(reader, size, sections, blockSize, func(block []byte) error {
+		compressed = encoder.EncodeAll(block, compressed[:0])
+		data := compressed
+		if len(compressed) >= len(block) {
+			//not worth compressing, the block is stored as is
+			data = block
+		}
+		binary.LittleEndian.PutUint32(blockHeader[nczBlockHeaderSize+4*blockIndex:], uint32(len(data)))
+		blockIndex++
+		_, err := output.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = output.Seek(headerOffset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(blockHeader)
+	if err != nil {
+		return err
+	}
+	_, err = output.Seek(0, io.SeekEnd)
+	return err
+}
+
+// GetCompressedFileName returns the NSZ/XCZ file name of an NSP/XCI file
+func GetCompressedFileName(fileName string) string {
+	lowerName := strings.ToLower(fileName)
+	if strings.HasSuffix(lowerName, ".nsp") {
+		return fileName[:len(fileName)-len(".nsp")] + ".nsz"
+	}
+	if strings.HasSuffix(lowerName, ".xci") {
+		return fileName[:len(fileName)-len(".xci")] + ".xcz"
+	}
+	return fileName
+}
@@ -0,0 +1,181 @@
+package switchfs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// DecompressedFile exposes an NSZ/XCZ file as the original NSP/XCI - every NCZ entry is replaced
+// by the decompressed (and re-encrypted) NCA
+type DecompressedFile struct {
+	file        ReadAtCloser
+	nczReaders  []*NczReader
+	parts       []readerPart
+	size        int64
+	isGamecard  bool
+	numNczFiles int
+}
+
+// OpenDecompressed opens an NSZ/XCZ file for reading as NSP/XCI
+func OpenDecompressed(filePath string) (*DecompressedFile, error) {
+	file, err := OpenFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	result := &DecompressedFile{file: file}
+
+	header := make([]byte, 0x200)
+	_, err = file.ReadAt(header, 0)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if string(header[0x100:0x104]) == "HEAD" {
+		err = result.openXcz(header)
+	} else {
+		err = result.openNsz()
+	}
+	if err != nil {
+		result.Close()
+		return nil, err
+	}
+	if result.numNczFiles == 0 {
+		result.Close()
+		return nil, errors.New("file does not contain NCZ files")
+	}
+	return result, nil
+}
+
+func (d *DecompressedFile) openNsz() error {
+	pfs0, err := readPfs0(d.file, 0x0)
+	if err != nil {
+		return err
+	}
+	partition, err := d.decompressPartition(pfs0, 0)
+	if err != nil {
+		return err
+	}
+	d.parts = []readerPart{{offset: 0, size: partition.Size(), reader: partition}}
+	d.size = partition.Size()
+	return nil
+}
+
+func (d *DecompressedFile) openXcz(header []byte) error {
+	d.isGamecard = true
+	rootPartitionOffset := int64(binary.LittleEndian.Uint64(header[0x130:0x138]))
+	rootHfs0, err := readPfs0(d.file, rootPartitionOffset)
+	if err != nil {
+		return err
+	}
+
+	var entries []partitionEntry
+	for _, hfs0File := range rootHfs0.Files {
+		offset := rootPartitionOffset + int64(hfs0File.StartOffset)
+		entry := partitionEntry{name: hfs0File.Name, size: int64(hfs0File.Size), hashedRegionSize: hfs0File.HashedRegionSize,
+			reader: io.NewSectionReader(d.file, offset, int64(hfs0File.Size))}
+		if hfs0File.Name == "secure" {
+			secureHfs0, err := readPfs0(d.file, offset)
+			if err != nil {
+				return err
+			}
+			securePartition, err := d.decompressPartition(secureHfs0, offset)
+			if err != nil {
+				return err
+			}
+			entry.reader = securePartition
+			entry.size = securePartition.Size()
+		}
+		entries = append(entries, entry)
+	}
+	rootPartition, err := newPartitionBuilder(hfs0Magic, entries, rootHfs0.StringTableSize)
+	if err != nil {
+		return err
+	}
+
+	xciHeader := make([]byte, rootPartitionOffset)
+	_, err = d.file.ReadAt(xciHeader, 0)
+	if err != nil {
+		return err
+	}
+	rootHeader := make([]byte, rootPartition.HeaderSize())
+	_, err = rootPartition.ReadAt(rootHeader, 0)
+	if err != nil {
+		return err
+	}
+	d.size = rootPartitionOffset + rootPartition.Size()
+	updateXciHeader(xciHeader, rootHeader, d.size)
+
+	d.parts = []readerPart{
+		{offset: 0, size: rootPartitionOffset, reader: byteReaderAt(xciHeader)},
+		{offset: rootPartitionOffset, size: rootPartition.Size(), reader: rootPartition},
+	}
+	return nil
+}
+
+// updateXciHeader updates the root partition header size/hash, and the valid data end address (in media units)
+// of the gamecard header, after the root partition was rebuilt
+func updateXciHeader(xciHeader []byte, rootHeader []byte, size int64) {
+	rootHeaderHash := sha256.Sum256(rootHeader)
+	binary.LittleEndian.PutUint64(xciHeader[0x118:0x120], uint64((size+0x1FF)/0x200-1))
+	binary.LittleEndian.PutUint64(xciHeader[0x138:0x140], uint64(len(rootHeader)))
+	copy(xciHeader[0x140:0x160], rootHeaderHash[:])
+}
+
+// decompressPartition rebuilds the partition, replacing the NCZ entries with the decompressed NCA
+func (d *DecompressedFile) decompressPartition(pfs0 *PFS0, pfs0Offset int64) (*partitionBuilder, error) {
+	var entries []partitionEntry
+	for _, pfs0File := range pfs0.Files {
+		offset := pfs0Offset + int64(pfs0File.StartOffset)
+		entry := partitionEntry{name: pfs0File.Name, size: int64(pfs0File.Size), hashedRegionSize: pfs0File.HashedRegionSize,
+			reader: io.NewSectionReader(d.file, offset, int64(pfs0File.Size))}
+		if isNcz(pfs0File.Name) {
+			nczReader, err := NewNczReader(d.file, offset, int64(pfs0File.Size))
+			if err != nil {
+				return nil, errors.New("failed to open [" + pfs0File.Name + "] - " + err.Error())
+			}
+			d.nczReaders = append(d.nczReaders, nczReader)
+			d.numNczFiles++
+			entry.name = pfs0File.Name[:len(pfs0File.Name)-len(".ncz")] + ".nca"
+			entry.reader = nczReader
+			entry.size = nczReader.Size()
+		}
+		entries = append(entries, entry)
+	}
+	return newPartitionBuilder(pfs0.Magic, entries, pfs0.StringTableSize)
+}
+
+// Size returns the size of the decompressed NSP/XCI
+func (d *DecompressedFile) Size() int64 {
+	return d.size
+}
+
+// IsGamecard returns true for XCZ files
+func (d *DecompressedFile) IsGamecard() bool {
+	return d.isGamecard
+}
+
+func (d *DecompressedFile) ReadAt(p []byte, off int64) (int, error) {
+	return readParts(d.parts, d.size, p, off)
+}
+
+func (d *DecompressedFile) Close() error {
+	for _, nczReader := range d.nczReaders {
+		nczReader.Close()
+	}
+	return d.file.Close()
+}
+
+// GetDecompressedFileName returns the NSP/XCI file name of an NSZ/XCZ file
+func GetDecompressedFileName(fileName string) string {
+	lowerName := strings.ToLower(fileName)
+	if strings.HasSuffix(lowerName, ".nsz") {
+		return fileName[:len(fileName)-len(".nsz")] + ".nsp"
+	}
+	if strings.HasSuffix(lowerName, ".xcz") {
+		return fileName[:len(fileName)-len(".xcz")] + ".xci"
+	}
+	return fileName
+}
@@ -0,0 +1,208 @@
+package switchfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+//https://switchbrew.org/wiki/NPDM
+//https://switchbrew.org/wiki/NSO
+
+const (
+	nsoHeaderSize = 0x100
+)
+
+// Npdm is the program metadata (main.npdm) of the ExeFS
+type Npdm struct {
+	Name                 string
+	ProductCode          string
+	Version              uint32
+	Is64Bit              bool
+	AddressSpaceType     byte
+	MainThreadPriority   byte
+	MainThreadCoreNumber byte
+	MainThreadStackSize  uint32
+	SystemResourceSize   uint32
+	ProgramId            string //the program id of the ACI0
+	ProgramIdMin         string //the program id range allowed by the ACID
+	ProgramIdMax         string
+	FsPermissions        uint64
+	Services             []string //the services the program can access
+	HostedServices       []string //the services the program can register
+	KernelCapabilities   []uint32
+}
+
+// NsoSegment is a text/ro/data segment of the NSO
+type NsoSegment struct {
+	FileOffset   uint32
+	MemoryOffset uint32
+	Size         uint32 //the decompressed size
+	FileSize     uint32 //the compressed size
+	Compressed   bool
+	CheckHash    bool
+	Hash         string
+}
+
+// NsoHeader is the header of an executable of the ExeFS (e.g. main)
+type NsoHeader struct {
+	Version uint32
+	Flags   uint32
+	BuildId string //the module id, the hex of the 0x20 bytes (cheats use the first 8 bytes)
+	Text    NsoSegment
+	Ro      NsoSegment
+	Data    NsoSegment
+	BssSize uint32
+}
+
+// ExeFsInfo is the metadata of the program NCA of a title
+type ExeFsInfo struct {
+	Npdm       *Npdm
+	Main       *NsoHeader
+	SdkVersion string
+}
+
+// ExtractExeFsInfo reads main.npdm and the header of the main NSO from the ExeFS of the program NCA of the title,
+// and the SDK version from the program NCA header
+func ExtractExeFsInfo(cnmt *ContentMetaAttributes, partition *Partition) (*ExeFsInfo, error) {
+	program := cnmt.GetContent("Program")
+	if program == nil {
+		return nil, errors.New("no program NCA found")
+	}
+	programNca, err := partition.FindNca(program.ID)
+	if err != nil {
+		return nil, errors.New("unable to find the program NCA by id " + program.ID + " - " + err.Error())
+	}
+	result := &ExeFsInfo{SdkVersion: programNca.header.getSdkVersion()}
+	section, err := programNca.Section(0)
+	if err != nil {
+		return nil, err
+	}
+	if section.IsRomFS() {
+		return nil, errors.New("the first section of the program NCA is not an ExeFS")
+	}
+	npdmData, err := fs.ReadFile(section, "main.npdm")
+	if err != nil {
+		return nil, err
+	}
+	result.Npdm, err = readNpdm(npdmData)
+	if err != nil {
+		return nil, err
+	}
+	mainFile, err := section.Open("main")
+	if err != nil {
+		return nil, err
+	}
+	defer mainFile.Close()
+	nsoHeader := make([]byte, nsoHeaderSize)
+	_, err = io.ReadFull(mainFile, nsoHeader)
+	if err != nil {
+		return nil, err
+	}
+	result.Main, err = readNsoHeader(nsoHeader)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func readNpdm(data []byte) (*Npdm, error) {
+	if len(data) < 0x80 || string(data[0x0:0x4]) != "META" {
+		return nil, errors.New("invalid main.npdm, 'META' magic was not found")
+	}
+	result := &Npdm{
+		Is64Bit:              data[0xC]&1 != 0,
+		AddressSpaceType:     (data[0xC] >> 1) & 7,
+		MainThreadPriority:   data[0xE],
+		MainThreadCoreNumber: data[0xF],
+		SystemResourceSize:   binary.LittleEndian.Uint32(data[0x14:0x18]),
+		Version:              binary.LittleEndian.Uint32(data[0x18:0x1C]),
+		MainThreadStackSize:  binary.LittleEndian.Uint32(data[0x1C:0x20]),
+		Name:                 string(readBytesUntilZero(data[0x20:0x30])),
+		ProductCode:          string(readBytesUntilZero(data[0x30:0x40])),
+	}
+
+	aci, err := getNpdmSection(data, binary.LittleEndian.Uint32(data[0x70:0x74]), binary.LittleEndian.Uint32(data[0x74:0x78]))
+	if err != nil || len(aci) < 0x38 || string(aci[0x0:0x4]) != "ACI0" {
+		return nil, errors.New("invalid main.npdm, 'ACI0' was not found")
+	}
+	result.ProgramId = fmt.Sprintf("%016x", binary.LittleEndian.Uint64(aci[0x10:0x18]))
+	fah, err := getNpdmSection(aci, binary.LittleEndian.Uint32(aci[0x20:0x24]), binary.LittleEndian.Uint32(aci[0x24:0x28]))
+	if err == nil && len(fah) >= 0xC {
+		result.FsPermissions = binary.LittleEndian.Uint64(fah[0x4:0xC])
+	}
+	sac, err := getNpdmSection(aci, binary.LittleEndian.Uint32(aci[0x28:0x2C]), binary.LittleEndian.Uint32(aci[0x2C:0x30]))
+	if err == nil {
+		result.Services, result.HostedServices = readServiceAccessControl(sac)
+	}
+	kac, err := getNpdmSection(aci, binary.LittleEndian.Uint32(aci[0x30:0x34]), binary.LittleEndian.Uint32(aci[0x34:0x38]))
+	if err == nil {
+		for i := 0; i+4 <= len(kac); i += 4 {
+			result.KernelCapabilities = append(result.KernelCapabilities, binary.LittleEndian.Uint32(kac[i:i+4]))
+		}
+	}
+
+	acid, err := getNpdmSection(data, binary.LittleEndian.Uint32(data[0x78:0x7C]), binary.LittleEndian.Uint32(data[0x7C:0x80]))
+	if err == nil && len(acid) >= 0x220 && string(acid[0x200:0x204]) == "ACID" {
+		result.ProgramIdMin = fmt.Sprintf("%016x", binary.LittleEndian.Uint64(acid[0x210:0x218]))
+		result.ProgramIdMax = fmt.Sprintf("%016x", binary.LittleEndian.Uint64(acid[0x218:0x220]))
+	}
+	return result, nil
+}
+
+func getNpdmSection(data []byte, offset uint32, size uint32) ([]byte, error) {
+	if uint64(offset)+uint64(size) > uint64(len(data)) {
+		return nil, errors.New("invalid main.npdm section")
+	}
+	return data[offset : offset+size], nil
+}
+
+// readServiceAccessControl returns the service names, each entry starts with a control byte
+// (the length of the name - 1, and the server flag in the top bit)
+func readServiceAccessControl(sac []byte) ([]string, []string) {
+	var services, hostedServices []string
+	for i := 0; i < len(sac); {
+		control := sac[i]
+		length := int(control&7) + 1
+		if i+1+length > len(sac) {
+			break
+		}
+		name := string(sac[i+1 : i+1+length])
+		if control&0x80 != 0 {
+			hostedServices = append(hostedServices, name)
+		} else {
+			services = append(services, name)
+		}
+		i += 1 + length
+	}
+	return services, hostedServices
+}
+
+func readNsoHeader(data []byte) (*NsoHeader, error) {
+	if len(data) < nsoHeaderSize || string(data[0x0:0x4]) != "NSO0" {
+		return nil, errors.New("invalid NSO, 'NSO0' magic was not found")
+	}
+	flags := binary.LittleEndian.Uint32(data[0xC:0x10])
+	readSegment := func(index int, headerOffset int, fileSizeOffset int, hashOffset int) NsoSegment {
+		return NsoSegment{
+			FileOffset:   binary.LittleEndian.Uint32(data[headerOffset : headerOffset+0x4]),
+			MemoryOffset: binary.LittleEndian.Uint32(data[headerOffset+0x4 : headerOffset+0x8]),
+			Size:         binary.LittleEndian.Uint32(data[headerOffset+0x8 : headerOffset+0xC]),
+			FileSize:     binary.LittleEndian.Uint32(data[fileSizeOffset : fileSizeOffset+0x4]),
+			Compressed:   flags&(1<<index) != 0,
+			CheckHash:    flags&(1<<(index+3)) != 0,
+			Hash:         fmt.Sprintf("%x", data[hashOffset:hashOffset+0x20]),
+		}
+	}
+	return &NsoHeader{
+		Version: binary.LittleEndian.Uint32(data[0x4:0x8]),
+		Flags:   flags,
+		Text:    readSegment(0, 0x10, 0x60, 0xA0),
+		Ro:      readSegment(1, 0x20, 0x64, 0xC0),
+		Data:    readSegment(2, 0x30, 0x68, 0xE0),
+		BssSize: binary.LittleEndian.Uint32(data[0x3C:0x40]),
+		BuildId: fmt.Sprintf("%X", data[0x40:0x60]),
+	}, nil
+}
@@ -0,0 +1,96 @@
+package switchfs
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func testNpdm() []byte {
+	const aciOffset, aciSize = 0x80, 0x60
+	const acidOffset, acidSize = 0xE0, 0x240
+	data := make([]byte, acidOffset+acidSize)
+	copy(data, "META")
+	data[0xC] = 1 | 2<<1
+	binary.LittleEndian.PutUint32(data[0x1C:], 0x100000)
+	copy(data[0x20:], "Application")
+	binary.LittleEndian.PutUint32(data[0x70:], aciOffset)
+	binary.LittleEndian.PutUint32(data[0x74:], aciSize)
+	binary.LittleEndian.PutUint32(data[0x78:], acidOffset)
+	binary.LittleEndian.PutUint32(data[0x7C:], acidSize)
+
+	aci := data[aciOffset : aciOffset+aciSize]
+	copy(aci, "ACI0")
+	binary.LittleEndian.PutUint64(aci[0x10:], 0x0100000000010000)
+	binary.LittleEndian.PutUint32(aci[0x20:], 0x40)
+	binary.LittleEndian.PutUint32(aci[0x24:], 0xC)
+	binary.LittleEndian.PutUint64(aci[0x44:], 0x8000000000000801)
+	binary.LittleEndian.PutUint32(aci[0x28:], 0x4C)
+	binary.LittleEndian.PutUint32(aci[0x2C:], 0x10)
+	sac := aci[0x4C:0x5C]
+	sac[0] = 0x80 | 4 //"hosts" 5 chars, server
+	copy(sac[1:], "hosts")
+	sac[6] = 2 //"fsp" 3 chars
+	copy(sac[7:], "fsp")
+	sac[10] = 4 //"hid:x" 5 chars
+	copy(sac[11:], "hid:x")
+	binary.LittleEndian.PutUint32(aci[0x30:], 0x5C)
+	binary.LittleEndian.PutUint32(aci[0x34:], 0x4)
+	binary.LittleEndian.PutUint32(aci[0x5C:], 0x3FFFF7)
+
+	acid := data[acidOffset:]
+	copy(acid[0x200:], "ACID")
+	binary.LittleEndian.PutUint64(acid[0x210:], 0x0100000000010000)
+	binary.LittleEndian.PutUint64(acid[0x218:], 0x01000000000100FF)
+	return data
+}
+
+func TestReadNpdm(t *testing.T) {
+	npdm, err := readNpdm(testNpdm())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if npdm.Name != "Application" || !npdm.Is64Bit || npdm.AddressSpaceType != 2 || npdm.MainThreadStackSize != 0x100000 {
+		t.Fatalf("unexpected header %+v", npdm)
+	}
+	if npdm.ProgramId != "0100000000010000" || npdm.ProgramIdMin != "0100000000010000" || npdm.ProgramIdMax != "01000000000100ff" {
+		t.Fatalf("unexpected program ids %v %v %v", npdm.ProgramId, npdm.ProgramIdMin, npdm.ProgramIdMax)
+	}
+	if npdm.FsPermissions != 0x8000000000000801 {
+		t.Fatalf("unexpected fs permissions %x", npdm.FsPermissions)
+	}
+	if !reflect.DeepEqual(npdm.Services, []string{"fsp", "hid:x"}) || !reflect.DeepEqual(npdm.HostedServices, []string{"hosts"}) {
+		t.Fatalf("unexpected services %v %v", npdm.Services, npdm.HostedServices)
+	}
+	if !reflect.DeepEqual(npdm.KernelCapabilities, []uint32{0x3FFFF7}) {
+		t.Fatalf("unexpected kernel capabilities %v", npdm.KernelCapabilities)
+	}
+}
+
+func TestReadNsoHeader(t *testing.T) {
+	data := make([]byte, nsoHeaderSize)
+	copy(data, "NSO0")
+	binary.LittleEndian.PutUint32(data[0xC:], 0x3F)
+	binary.LittleEndian.PutUint32(data[0x10:], 0x100)
+	binary.LittleEndian.PutUint32(data[0x18:], 0x2000)
+	binary.LittleEndian.PutUint32(data[0x28:], 0x1000)
+	binary.LittleEndian.PutUint32(data[0x3C:], 0x500)
+	for i := 0; i < 0x20; i++ {
+		data[0x40+i] = byte(0xA0 + i)
+	}
+	binary.LittleEndian.PutUint32(data[0x60:], 0x1800)
+
+	nso, err := readNsoHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nso.BuildId[:16] != "A0A1A2A3A4A5A6A7" || len(nso.BuildId) != 0x40 {
+		t.Fatalf("unexpected build id %v", nso.BuildId)
+	}
+	if nso.Text.FileOffset != 0x100 || nso.Text.Size != 0x2000 || nso.Text.FileSize != 0x1800 || !nso.Text.Compressed || !nso.Data.CheckHash {
+		t.Fatalf("unexpected text segment %+v", nso.Text)
+	}
+	if nso.Ro.Size != 0x1000 || nso.BssSize != 0x500 {
+		t.Fatalf("unexpected segments %+v %v", nso.Ro, nso.BssSize)
+	}
+}
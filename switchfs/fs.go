@@ -7,12 +7,21 @@ import (
 	"errors"
 )
 
+const (
+	FsEncryptionType_Auto = iota
+	FsEncryptionType_None
+	FsEncryptionType_AesXts
+	FsEncryptionType_AesCtr
+	FsEncryptionType_AesCtrEx
+)
+
 type fsHeader struct {
-	encType       byte //(0 = Auto, 1 = None, 2 = AesCtrOld, 3 = AesCtr, 4 = AesCtrEx)
+	encType       byte //(0 = Auto, 1 = None, 2 = AesXts, 3 = AesCtr, 4 = AesCtrEx)
 	fsType        byte //(0 = RomFs, 1 = PartitionFs)
 	hashType      byte // (0 = Auto, 2 = HierarchicalSha256, 3 = HierarchicalIntegrity (Ivfc))
 	fsHeaderBytes []byte
 	generation    uint32
+	secureValue   uint32
 }
 
 type fsEntry struct {
@@ -26,6 +35,26 @@ type hashInfo struct {
 	pfs0size         uint64
 }
 
+const (
+	HashType_HierarchicalSha256    = 2
+	HashType_HierarchicalIntegrity = 3
+)
+
+type hashLevel struct {
+	offset    int64 //relative to the section
+	size      int64
+	blockSize int64
+}
+
+// hashTree describes the hash levels of a section, the master hash covers the first level,
+// and each level holds the hashes of the blocks of the next level (the last level is the data)
+type hashTree struct {
+	hashType   byte
+	masterHash []byte
+	levels     []hashLevel
+	padBlocks  bool //IVFC hashes the last block of a level padded with zeros to the block size
+}
+
 func getFsEntry(ncaHeader *ncaHeader, index int) fsEntry {
 	fsEntryOffset := 0x240 + 0x10*index
 	fsEntryBytes := ncaHeader.headerBytes[fsEntryOffset : fsEntryOffset+0x10]
@@ -48,7 +77,10 @@ func getFsHeader(ncaHeader *ncaHeader, index int) (*fsHeader, error) {
 	if bytes.Compare(actualHash[:], fsHeaderHash) != 0 {
 		return nil, errors.New("fs headerBytes hash mismatch")
 	}
+	return parseFsHeader(fsHeaderBytes), nil
+}
 
+func parseFsHeader(fsHeaderBytes []byte) *fsHeader {
 	result := fsHeader{fsHeaderBytes: fsHeaderBytes}
 
 	result.fsType = fsHeaderBytes[0x2:0x3][0]
@@ -57,8 +89,9 @@ func getFsHeader(ncaHeader *ncaHeader, index int) (*fsHeader, error) {
 
 	generationBytes := fsHeaderBytes[0x140 : 0x140+0x4] //generation
 	result.generation = binary.LittleEndian.Uint32(generationBytes)
+	result.secureValue = binary.LittleEndian.Uint32(fsHeaderBytes[0x144 : 0x144+0x4])
 
-	return &result, nil
+	return &result
 }
 
 func (fh *fsHeader) getHashInfo() (*hashInfo, error) {
@@ -76,3 +109,43 @@ func (fh *fsHeader) getHashInfo() (*hashInfo, error) {
 	}
 	return nil, errors.New("non supported hash type")
 }
+
+func (fh *fsHeader) getHashTree() (*hashTree, error) {
+	hashInfoBytes := fh.fsHeaderBytes[0x8:0x100]
+	result := hashTree{hashType: fh.hashType}
+	if fh.hashType == HashType_HierarchicalSha256 {
+		result.masterHash = hashInfoBytes[0x0:0x20]
+		blockSize := int64(binary.LittleEndian.Uint32(hashInfoBytes[0x20:0x24]))
+		levelCount := binary.LittleEndian.Uint32(hashInfoBytes[0x24:0x28])
+		if levelCount != 2 || blockSize == 0 {
+			return nil, errors.New("unsupported HierarchicalSha256 layout")
+		}
+		hashTable := hashLevel{offset: int64(binary.LittleEndian.Uint64(hashInfoBytes[0x28:0x30])),
+			size: int64(binary.LittleEndian.Uint64(hashInfoBytes[0x30:0x38]))}
+		hashTable.blockSize = hashTable.size
+		data := hashLevel{offset: int64(binary.LittleEndian.Uint64(hashInfoBytes[0x38:0x40])),
+			size: int64(binary.LittleEndian.Uint64(hashInfoBytes[0x40:0x48])), blockSize: blockSize}
+		result.levels = []hashLevel{hashTable, data}
+		return &result, nil
+	} else if fh.hashType == HashType_HierarchicalIntegrity {
+		if string(hashInfoBytes[0x0:0x4]) != "IVFC" {
+			return nil, errors.New("invalid IVFC header")
+		}
+		levelCount := binary.LittleEndian.Uint32(hashInfoBytes[0xC:0x10])
+		if levelCount < 2 || levelCount > 7 {
+			return nil, errors.New("unsupported IVFC level count")
+		}
+		result.padBlocks = true
+		result.masterHash = hashInfoBytes[0xC0:0xE0]
+		//the level count includes the master hash
+		for i := uint32(0); i < levelCount-1; i++ {
+			levelBytes := hashInfoBytes[0x10+0x18*i : 0x10+0x18*(i+1)]
+			level := hashLevel{offset: int64(binary.LittleEndian.Uint64(levelBytes[0x0:0x8])),
+				size:      int64(binary.LittleEndian.Uint64(levelBytes[0x8:0x10])),
+				blockSize: int64(1) << binary.LittleEndian.Uint32(levelBytes[0x10:0x14])}
+			result.levels = append(result.levels, level)
+		}
+		return &result, nil
+	}
+	return nil, errors.New("non supported hash type")
+}
@@ -0,0 +1,208 @@
+package switchfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	integrityChunkSize = 0x400000
+)
+
+// BlockRange is a range of consecutive blocks that failed verification, the offset is relative to the NCA
+type BlockRange struct {
+	Level     int
+	Offset    int64
+	Size      int64
+	Truncated bool //the blocks could not be read (the file is shorter than expected)
+}
+
+func (r BlockRange) String() string {
+	if r.Truncated {
+		return fmt.Sprintf("level %v [0x%x-0x%x] is truncated", r.Level, r.Offset, r.Offset+r.Size)
+	}
+	return fmt.Sprintf("level %v [0x%x-0x%x] hash mismatch", r.Level, r.Offset, r.Offset+r.Size)
+}
+
+// SectionIntegrity is the result of verifying the hash levels of an NCA section
+type SectionIntegrity struct {
+	Section      int
+	HashType     string
+	Skipped      string //the reason the section was not verified
+	FailedRanges []BlockRange
+}
+
+func (s SectionIntegrity) IsValid() bool {
+	return len(s.FailedRanges) == 0
+}
+
+// VerifyNcaIntegrity verifies the hash levels (HierarchicalSha256 / IVFC) of every section of the given NCA
+// in the NSP/XCI file, and returns the block ranges that failed verification
+func VerifyNcaIntegrity(filePath string, ncaFileName string) ([]SectionIntegrity, error) {
+	container, err := OpenContainer(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer container.Close()
+
+	nca, err := container.NcaPartition().OpenNca(ncaFileName)
+	if err != nil {
+		return nil, err
+	}
+	return verifyNcaIntegrity(nca)
+}
+
+func verifyNcaIntegrity(nca *Nca) ([]SectionIntegrity, error) {
+	var result []SectionIntegrity
+	for _, section := range nca.sections {
+		sectionResult := SectionIntegrity{Section: section.index}
+		tree, err := section.fsHeader.getHashTree()
+		if err != nil {
+			sectionResult.Skipped = err.Error()
+			result = append(result, sectionResult)
+			continue
+		}
+		if tree.hashType == HashType_HierarchicalSha256 {
+			sectionResult.HashType = "HierarchicalSha256"
+		} else {
+			sectionResult.HashType = "HierarchicalIntegrity"
+		}
+		if section.fsHeader.encType == FsEncryptionType_AesCtrEx {
+			//patch sections can only be verified on top of the base title
+			sectionResult.Skipped = "patch (BKTR) section"
+			result = append(result, sectionResult)
+			continue
+		}
+		sectionResult.FailedRanges = verifyHashTree(section.reader, tree)
+		result = append(result, sectionResult)
+	}
+	return result, nil
+}
+
+// verifyHashTree verifies each level against the hashes of the previous level (the first level against the master hash)
+func verifyHashTree(section *sectionReader, tree *hashTree) []BlockRange {
+	var result []BlockRange
+	hashes := tree.masterHash
+	for i, level := range tree.levels {
+		failed, levelData := verifyHashLevel(section, level, hashes, tree.padBlocks, i == len(tree.levels)-1)
+		for j := range failed {
+			failed[j].Level = i
+			failed[j].Offset += section.start
+		}
+		result = append(result, failed...)
+		hashes = levelData
+	}
+	return result
+}
+
+// rebuildHashTree recomputes the hash levels of the (decrypted) section data after it was modified,
+// from the data level up to the master hash
+func rebuildHashTree(section []byte, tree *hashTree) error {
+	for i := len(tree.levels) - 1; i >= 0; i-- {
+		level := tree.levels[i]
+		if level.blockSize <= 0 || level.offset+level.size > int64(len(section)) {
+			return errors.New("invalid hash level")
+		}
+		hashes := tree.masterHash
+		if i > 0 {
+			parent := tree.levels[i-1]
+			hashes = section[parent.offset : parent.offset+parent.size]
+		}
+		for blockIndex := int64(0); blockIndex*level.blockSize < level.size; blockIndex++ {
+			blockOffset := level.offset + blockIndex*level.blockSize
+			block := section[blockOffset : blockOffset+min64(level.blockSize, level.size-blockIndex*level.blockSize)]
+			if tree.padBlocks && int64(len(block)) < level.blockSize {
+				block = append(append([]byte{}, block...), make([]byte, level.blockSize-int64(len(block)))...)
+			}
+			if (blockIndex+1)*sha256.Size > int64(len(hashes)) {
+				return errors.New("hash level is too small")
+			}
+			hash := sha256.Sum256(block)
+			copy(hashes[blockIndex*sha256.Size:], hash[:])
+		}
+	}
+	return nil
+}
+
+// verifyHashLevel hashes the blocks of the level, and returns the ranges (relative to the section) of the blocks
+// that don't match, the level data is returned as well (the hashes of the next level), unless it's the last level
+func verifyHashLevel(reader io.ReaderAt, level hashLevel, hashes []byte, padBlocks bool, isLast bool) ([]BlockRange, []byte) {
+	var result []BlockRange
+	var levelData []byte
+	if level.blockSize <= 0 {
+		return nil, nil
+	}
+	if !isLast {
+		levelData = make([]byte, level.size)
+	}
+	addRange := func(offset int64, size int64, truncated bool) {
+		if len(result) != 0 {
+			last := &result[len(result)-1]
+			if last.Offset+last.Size == offset && last.Truncated == truncated {
+				last.Size += size
+				return
+			}
+		}
+		result = append(result, BlockRange{Offset: offset, Size: size, Truncated: truncated})
+	}
+
+	chunkSize := integrityChunkSize - integrityChunkSize%level.blockSize
+	if chunkSize == 0 {
+		chunkSize = level.blockSize
+	}
+	chunk := make([]byte, chunkSize)
+	padded := make([]byte, level.blockSize)
+	blockIndex := int64(0)
+	for chunkOffset := int64(0); chunkOffset < level.size; chunkOffset += chunkSize {
+		length := min64(chunkSize, level.size-chunkOffset)
+		n, _ := reader.ReadAt(chunk[:length], level.offset+chunkOffset)
+		if levelData != nil {
+			copy(levelData[chunkOffset:], chunk[:n])
+		}
+		for blockOffset := int64(0); blockOffset < length; blockOffset += level.blockSize {
+			blockSize := min64(level.blockSize, length-blockOffset)
+			offset := level.offset + chunkOffset + blockOffset
+			expected := getBlockHash(hashes, blockIndex)
+			blockIndex++
+			if blockOffset+blockSize > int64(n) {
+				addRange(offset, blockSize, true)
+				continue
+			}
+			block := chunk[blockOffset : blockOffset+blockSize]
+			if padBlocks && blockSize < level.blockSize {
+				copy(padded, block)
+				for k := blockSize; k < level.blockSize; k++ {
+					padded[k] = 0
+				}
+				block = padded
+			}
+			actual := sha256.Sum256(block)
+			if expected == nil || !bytes.Equal(actual[:], expected) {
+				addRange(offset, blockSize, false)
+			}
+		}
+	}
+	return result, levelData
+}
+
+func getBlockHash(hashes []byte, blockIndex int64) []byte {
+	if (blockIndex+1)*sha256.Size > int64(len(hashes)) {
+		return nil
+	}
+	return hashes[blockIndex*sha256.Size : (blockIndex+1)*sha256.Size]
+}
+
+// FormatIntegrityIssues returns a readable description of the failed sections
+func FormatIntegrityIssues(sections []SectionIntegrity) string {
+	var issues []string
+	for _, section := range sections {
+		for _, failedRange := range section.FailedRanges {
+			issues = append(issues, fmt.Sprintf("section %v %v", section.Section, failedRange))
+		}
+	}
+	return strings.Join(issues, ", ")
+}
@@ -3,7 +3,8 @@ package switchfs
 import (
 	"encoding/binary"
 	"errors"
-	"io"
+	"io/fs"
+	"strconv"
 )
 
 type Language int
@@ -22,8 +23,9 @@ const (
 	Portuguese
 	Russian
 	Korean
-	Taiwanese
-	Chinese
+	TraditionalChinese
+	SimplifiedChinese
+	BrazilianPortuguese
 )
 
 type NacpTitle struct {
@@ -31,11 +33,81 @@ type NacpTitle struct {
 	Title    string
 }
 
+// rating organizations, in the order of the NACP rating ages
+var ratingOrganizations = []string{"CERO", "GRACGCRB", "GSRMR", "ESRB", "ClassInd", "USK", "PEGI", "PEGIPortugal",
+	"PEGIBBFC", "Russian", "ACB", "OFLC", "IARCGeneric"}
+
 type Nacp struct {
-	TitleName             map[string]NacpTitle
-	Isbn                  string
-	DisplayVersion        string
-	SupportedLanguageFlag uint32
+	TitleName                             map[string]NacpTitle
+	Isbn                                  string
+	StartupUserAccount                    byte
+	UserAccountSwitchLock                 byte
+	AddOnContentRegistrationType          byte
+	AttributeFlag                         uint32
+	SupportedLanguageFlag                 uint32
+	SupportedLanguages                    []string
+	ParentalControlFlag                   uint32
+	Screenshot                            byte
+	VideoCapture                          byte
+	DataLossConfirmation                  byte
+	PlayLogPolicy                         byte
+	PresenceGroupId                       uint64
+	RatingAge                             map[string]int //minimal age by rating organization (e.g. PEGI), unrated organizations are omitted
+	DisplayVersion                        string
+	AddOnContentBaseId                    uint64
+	SaveDataOwnerId                       uint64
+	UserAccountSaveDataSize               int64
+	UserAccountSaveDataJournalSize        int64
+	DeviceSaveDataSize                    int64
+	DeviceSaveDataJournalSize             int64
+	BcatDeliveryCacheStorageSize          int64
+	ApplicationErrorCodeCategory          string
+	LocalCommunicationId                  []uint64
+	LogoType                              byte
+	LogoHandling                          byte
+	RuntimeAddOnContentInstall            byte
+	RuntimeParameterDelivery              byte
+	CrashReport                           byte
+	Hdcp                                  byte
+	SeedForPseudoDeviceId                 uint64
+	BcatPassphrase                        string
+	StartupUserAccountOption              byte
+	UserAccountSaveDataSizeMax            int64
+	UserAccountSaveDataJournalSizeMax     int64
+	DeviceSaveDataSizeMax                 int64
+	DeviceSaveDataJournalSizeMax          int64
+	TemporaryStorageSize                  int64
+	CacheStorageSize                      int64
+	CacheStorageJournalSize               int64
+	CacheStorageDataAndJournalSizeMax     int64
+	CacheStorageIndexMax                  uint16
+	PlayLogQueryableApplicationId         []uint64
+	PlayLogQueryCapability                byte
+	RepairFlag                            byte
+	ProgramIndex                          byte
+	RequiredNetworkServiceLicenseOnLaunch byte
+	icon                                  []byte //the JPEG icon, not persisted with the metadata
+}
+
+// Icon returns the JPEG icon of the title (AmericanEnglish if available), nil if it was not extracted
+func (n *Nacp) Icon() []byte {
+	return n.icon
+}
+
+// TotalSaveDataSize returns the size of the user and device save data (including the journals)
+func (n *Nacp) TotalSaveDataSize() int64 {
+	return n.UserAccountSaveDataSize + n.UserAccountSaveDataJournalSize + n.DeviceSaveDataSize + n.DeviceSaveDataJournalSize
+}
+
+// RatingAges returns the readable rating ages (e.g. "PEGI 12"), in the order of the rating organizations
+func (n *Nacp) RatingAges() []string {
+	var result []string
+	for _, organization := range ratingOrganizations {
+		if age, ok := n.RatingAge[organization]; ok {
+			result = append(result, organization+" "+strconv.Itoa(age))
+		}
+	}
+	return result
 }
 
 func (l Language) String() string {
@@ -53,140 +125,140 @@ func (l Language) String() string {
 		"Portuguese",
 		"Russian",
 		"Korean",
-		"Taiwanese",
-		"Chinese",
-		"Chinese"}[l]
+		"TraditionalChinese",
+		"SimplifiedChinese",
+		"BrazilianPortuguese"}[l]
 }
 
-func ExtractNacp(cnmt *ContentMetaAttributes, file io.ReaderAt, securePartition *PFS0, securePartitionOffset int64) (*Nacp, error) {
-	if control, ok := cnmt.Contents["Control"]; ok {
-		controlNca := getNcaById(securePartition, control.ID)
-		if controlNca != nil {
-			fsHeader, section, err := openMetaNcaDataSection(file, securePartitionOffset+int64(controlNca.StartOffset))
-			if err != nil {
-				return nil, err
-			}
-			if fsHeader.fsType == 0 {
-				romFsHeader, err := readRomfsHeader(section)
-				if err != nil {
-					return nil, err
-				}
-				fEntries, err := readRomfsFileEntry(section, romFsHeader)
-				if err != nil {
-					return nil, err
-				}
-
-				if entry, ok := fEntries["control.nacp"]; ok {
-					nacp, err := readNacp(section, romFsHeader, entry)
-					if err != nil {
-						return nil, err
-					}
-					return &nacp, nil
-				}
-			} else {
-				return nil, errors.New("unsupported type " + control.ID)
-			}
-		} else {
-			return nil, errors.New("unable to find control.nacp by id " + control.ID)
+func ExtractNacp(cnmt *ContentMetaAttributes, partition *Partition) (*Nacp, error) {
+	if control := cnmt.GetContent("Control"); control != nil {
+		controlNca, err := partition.FindNca(control.ID)
+		if err != nil {
+			return nil, errors.New("unable to find control.nacp by id " + control.ID + " - " + err.Error())
 		}
-
+		section, err := controlNca.Section(0)
+		if err != nil {
+			return nil, err
+		}
+		if !section.IsRomFS() {
+			return nil, errors.New("unsupported type " + control.ID)
+		}
+		data, err := fs.ReadFile(section, "control.nacp")
+		if err != nil {
+			return nil, err
+		}
+		nacp, err := readNacp(data)
+		if err != nil {
+			return nil, err
+		}
+		nacp.icon = readIcon(section, nacp)
+		return &nacp, nil
 	}
 	return nil, errors.New("no control.nacp found")
 }
 
+// readIcon reads the icon of the first supported language (icon_<Language>.dat), AmericanEnglish is preferred
+func readIcon(section fs.FS, nacp Nacp) []byte {
+	languages := append([]string{Language(AmericanEnglish).String()}, nacp.SupportedLanguages...)
+	for _, language := range languages {
+		icon, err := fs.ReadFile(section, "icon_"+language+".dat")
+		if err == nil && len(icon) != 0 {
+			return icon
+		}
+	}
+	//fallback to any icon, the supported languages flag is not always set
+	names, _ := fs.Glob(section, "icon_*.dat")
+	for _, name := range names {
+		icon, err := fs.ReadFile(section, name)
+		if err == nil && len(icon) != 0 {
+			return icon
+		}
+	}
+	return nil
+}
+
 /*https://switchbrew.org/wiki/NACP_Format*/
-func readNacp(data []byte, romFsHeader RomfsHeader, fileEntry RomfsFileEntry) (Nacp, error) {
-	offset := romFsHeader.DataOffset + fileEntry.offset
+func readNacp(data []byte) (Nacp, error) {
+	if len(data) < 0x4000 {
+		return Nacp{}, errors.New("invalid control.nacp size")
+	}
 	titles := map[string]NacpTitle{}
 	for i := 0; i < 16; i++ {
 		//lang := i
-		appTitleBytes := data[offset+(uint64(i)*0x300) : offset+(uint64(i)*0x300)+0x200]
+		appTitleBytes := data[i*0x300 : i*0x300+0x200]
 		nameBytes := readBytesUntilZero(appTitleBytes)
 		titles[Language(i).String()] = NacpTitle{Language: Language(i), Title: string(nameBytes)}
 	}
 
-	isbn := readBytesUntilZero(data[offset+0x3000 : offset+0x3000+0x25])
-	displayVersion := readBytesUntilZero(data[offset+0x3060 : offset+0x3060+0x10])
-	supportedLanguageFlag := binary.BigEndian.Uint32(data[offset+0x302C : offset+0x302C+0x4])
-
-	return Nacp{TitleName: titles, Isbn: string(isbn), DisplayVersion: string(displayVersion), SupportedLanguageFlag: supportedLanguageFlag}, nil
-	/*
-
-
-	   Isbn = reader.ReadUtf8Z(37);
-	   reader.BaseStream.Position = start + 0x3025;
-	   StartupUserAccount = reader.ReadByte();
-	   UserAccountSwitchLock = reader.ReadByte();
-	   AocRegistrationType = reader.ReadByte();
-	   AttributeFlag = reader.ReadInt32();
-	   supportedLanguageFlag = reader.ReadUInt32();
-	   ParentalControlFlag = reader.ReadUInt32();
-	   Screenshot = reader.ReadByte();
-	   VideoCapture = reader.ReadByte();
-	   DataLossConfirmation = reader.ReadByte();
-	   PlayLogPolicy = reader.ReadByte();
-	   PresenceGroupId = reader.ReadUInt64();
-
-	   for (int i = 0; i < RatingAge.Length; i++)
-	   {
-	       RatingAge[i] = reader.ReadSByte();
-	   }
-
-	   DisplayVersion = reader.ReadUtf8Z(16);
-	   reader.BaseStream.Position = start + 0x3070;
-	   AddOnContentBaseId = reader.ReadUInt64();
-	   SaveDataOwnerId = reader.ReadUInt64();
-	   UserAccountSaveDataSize = reader.ReadInt64();
-	   UserAccountSaveDataJournalSize = reader.ReadInt64();
-	   DeviceSaveDataSize = reader.ReadInt64();
-	   DeviceSaveDataJournalSize = reader.ReadInt64();
-	   BcatDeliveryCacheStorageSize = reader.ReadInt64();
-	   ApplicationErrorCodeCategory = reader.ReadUtf8Z(8);
-	   reader.BaseStream.Position = start + 0x30B0;
-
-	   for (int i = 0; i < LocalCommunicationId.Length; i++)
-	   {
-	       LocalCommunicationId[i] = reader.ReadUInt64();
-	   }
-
-	   LogoType = reader.ReadByte();
-	   LogoHandling = reader.ReadByte();
-	   RuntimeAddOnContentInstall = reader.ReadByte();
-	   Reserved00 = reader.ReadBytes(3);
-	   CrashReport = reader.ReadByte();
-	   Hdcp = reader.ReadByte();
-	   SeedForPseudoDeviceId = reader.ReadUInt64();
-	   BcatPassphrase = reader.ReadUtf8Z(65);
-
-	   reader.BaseStream.Position = start + 0x3141;
-	   Reserved01 = reader.ReadByte();
-	   Reserved02 = reader.ReadBytes(6);
-
-	   UserAccountSaveDataSizeMax = reader.ReadInt64();
-	   UserAccountSaveDataJournalSizeMax = reader.ReadInt64();
-	   DeviceSaveDataSizeMax = reader.ReadInt64();
-	   DeviceSaveDataJournalSizeMax = reader.ReadInt64();
-	   TemporaryStorageSize = reader.ReadInt64();
-	   CacheStorageSize = reader.ReadInt64();
-	   CacheStorageJournalSize = reader.ReadInt64();
-	   CacheStorageDataAndJournalSizeMax = reader.ReadInt64();
-	   CacheStorageIndex = reader.ReadInt16();
-	   Reserved03 = reader.ReadBytes(6);
-
-	   for (int i = 0; i < 16; i++)
-	   {
-	       ulong value = reader.ReadUInt64();
-	       if (value != 0) PlayLogQueryableApplicationId.Add(value);
-	   }
-
-	   PlayLogQueryCapability = reader.ReadByte();
-	   RepairFlag = reader.ReadByte();
-	   ProgramIndex = reader.ReadByte();
-
-	   UserTotalSaveDataSize = UserAccountSaveDataSize + UserAccountSaveDataJournalSize;
-	   DeviceTotalSaveDataSize = DeviceSaveDataSize + DeviceSaveDataJournalSize;
-	   TotalSaveDataSize = UserTotalSaveDataSize + DeviceTotalSaveDataSize;
-	*/
+	nacp := Nacp{
+		TitleName:                             titles,
+		Isbn:                                  string(readBytesUntilZero(data[0x3000 : 0x3000+0x25])),
+		StartupUserAccount:                    data[0x3025],
+		UserAccountSwitchLock:                 data[0x3026],
+		AddOnContentRegistrationType:          data[0x3027],
+		AttributeFlag:                         binary.LittleEndian.Uint32(data[0x3028:0x302C]),
+		SupportedLanguageFlag:                 binary.LittleEndian.Uint32(data[0x302C:0x3030]),
+		ParentalControlFlag:                   binary.LittleEndian.Uint32(data[0x3030:0x3034]),
+		Screenshot:                            data[0x3034],
+		VideoCapture:                          data[0x3035],
+		DataLossConfirmation:                  data[0x3036],
+		PlayLogPolicy:                         data[0x3037],
+		PresenceGroupId:                       binary.LittleEndian.Uint64(data[0x3038:0x3040]),
+		RatingAge:                             map[string]int{},
+		DisplayVersion:                        string(readBytesUntilZero(data[0x3060 : 0x3060+0x10])),
+		AddOnContentBaseId:                    binary.LittleEndian.Uint64(data[0x3070:0x3078]),
+		SaveDataOwnerId:                       binary.LittleEndian.Uint64(data[0x3078:0x3080]),
+		UserAccountSaveDataSize:               int64(binary.LittleEndian.Uint64(data[0x3080:0x3088])),
+		UserAccountSaveDataJournalSize:        int64(binary.LittleEndian.Uint64(data[0x3088:0x3090])),
+		DeviceSaveDataSize:                    int64(binary.LittleEndian.Uint64(data[0x3090:0x3098])),
+		DeviceSaveDataJournalSize:             int64(binary.LittleEndian.Uint64(data[0x3098:0x30A0])),
+		BcatDeliveryCacheStorageSize:          int64(binary.LittleEndian.Uint64(data[0x30A0:0x30A8])),
+		ApplicationErrorCodeCategory:          string(readBytesUntilZero(data[0x30A8:0x30B0])),
+		LogoType:                              data[0x30F0],
+		LogoHandling:                          data[0x30F1],
+		RuntimeAddOnContentInstall:            data[0x30F2],
+		RuntimeParameterDelivery:              data[0x30F3],
+		CrashReport:                           data[0x30F6],
+		Hdcp:                                  data[0x30F7],
+		SeedForPseudoDeviceId:                 binary.LittleEndian.Uint64(data[0x30F8:0x3100]),
+		BcatPassphrase:                        string(readBytesUntilZero(data[0x3100:0x3141])),
+		StartupUserAccountOption:              data[0x3141],
+		UserAccountSaveDataSizeMax:            int64(binary.LittleEndian.Uint64(data[0x3148:0x3150])),
+		UserAccountSaveDataJournalSizeMax:     int64(binary.LittleEndian.Uint64(data[0x3150:0x3158])),
+		DeviceSaveDataSizeMax:                 int64(binary.LittleEndian.Uint64(data[0x3158:0x3160])),
+		DeviceSaveDataJournalSizeMax:          int64(binary.LittleEndian.Uint64(data[0x3160:0x3168])),
+		TemporaryStorageSize:                  int64(binary.LittleEndian.Uint64(data[0x3168:0x3170])),
+		CacheStorageSize:                      int64(binary.LittleEndian.Uint64(data[0x3170:0x3178])),
+		CacheStorageJournalSize:               int64(binary.LittleEndian.Uint64(data[0x3178:0x3180])),
+		CacheStorageDataAndJournalSizeMax:     int64(binary.LittleEndian.Uint64(data[0x3180:0x3188])),
+		CacheStorageIndexMax:                  binary.LittleEndian.Uint16(data[0x3188:0x318A]),
+		PlayLogQueryCapability:                data[0x3210],
+		RepairFlag:                            data[0x3211],
+		ProgramIndex:                          data[0x3212],
+		RequiredNetworkServiceLicenseOnLaunch: data[0x3213],
+	}
+	for i := 0; i < 16; i++ {
+		if nacp.SupportedLanguageFlag&(1<<i) != 0 {
+			nacp.SupportedLanguages = append(nacp.SupportedLanguages, Language(i).String())
+		}
+	}
+	for i, organization := range ratingOrganizations {
+		//-1 means the title was not rated by the organization
+		if age := int8(data[0x3040+i]); age >= 0 {
+			nacp.RatingAge[organization] = int(age)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		if id := binary.LittleEndian.Uint64(data[0x30B0+i*8 : 0x30B0+(i+1)*8]); id != 0 {
+			nacp.LocalCommunicationId = append(nacp.LocalCommunicationId, id)
+		}
+	}
+	for i := 0; i < 16; i++ {
+		if id := binary.LittleEndian.Uint64(data[0x3190+i*8 : 0x3190+(i+1)*8]); id != 0 {
+			nacp.PlayLogQueryableApplicationId = append(nacp.PlayLogQueryableApplicationId, id)
+		}
+	}
+	return nacp, nil
 }
 
 func readBytesUntilZero(appTitleBytes []byte) []byte {
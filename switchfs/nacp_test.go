@@ -0,0 +1,51 @@
+package switchfs
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestReadNacp(t *testing.T) {
+	data := make([]byte, 0x4000)
+	copy(data, "Title")
+	copy(data[0x3060:], "1.0.2")
+	copy(data[13*0x300:], "Traditional")
+	copy(data[14*0x300:], "Simplified")
+	binary.LittleEndian.PutUint32(data[0x302C:], 1<<AmericanEnglish|1<<Japanese|1<<German|
+		1<<TraditionalChinese|1<<SimplifiedChinese|1<<BrazilianPortuguese)
+	for i := 0; i < 0x20; i++ {
+		data[0x3040+i] = 0xFF
+	}
+	data[0x3040+3] = 10 //ESRB
+	data[0x3040+6] = 12 //PEGI
+	binary.LittleEndian.PutUint64(data[0x3070:], 0x0100000000011000)
+	binary.LittleEndian.PutUint64(data[0x3080:], 0x400000)
+	binary.LittleEndian.PutUint64(data[0x3088:], 0x100000)
+	binary.LittleEndian.PutUint64(data[0x30B0:], 0x0100000000010000)
+
+	nacp, err := readNacp(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nacp.TitleName["AmericanEnglish"].Title != "Title" || nacp.DisplayVersion != "1.0.2" {
+		t.Fatalf("unexpected title %+v %v", nacp.TitleName["AmericanEnglish"], nacp.DisplayVersion)
+	}
+	if !reflect.DeepEqual(nacp.SupportedLanguages, []string{"AmericanEnglish", "Japanese", "German",
+		"TraditionalChinese", "SimplifiedChinese", "BrazilianPortuguese"}) {
+		t.Fatalf("unexpected languages %v", nacp.SupportedLanguages)
+	}
+	if nacp.TitleName["TraditionalChinese"].Title != "Traditional" || nacp.TitleName["SimplifiedChinese"].Title != "Simplified" ||
+		len(nacp.TitleName) != 16 {
+		t.Fatalf("unexpected titles %+v", nacp.TitleName)
+	}
+	if !reflect.DeepEqual(nacp.RatingAges(), []string{"ESRB 10", "PEGI 12"}) {
+		t.Fatalf("unexpected rating ages %v", nacp.RatingAges())
+	}
+	if nacp.AddOnContentBaseId != 0x0100000000011000 || nacp.TotalSaveDataSize() != 0x500000 {
+		t.Fatalf("unexpected nacp %+v", nacp)
+	}
+	if !reflect.DeepEqual(nacp.LocalCommunicationId, []uint64{0x0100000000010000}) {
+		t.Fatalf("unexpected local communication ids %v", nacp.LocalCommunicationId)
+	}
+}
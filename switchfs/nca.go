@@ -1,8 +1,6 @@
 package switchfs
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -26,107 +24,97 @@ const (
 	NcaContentType_PublicData
 )
 
-func openMetaNcaDataSection(reader io.ReaderAt, ncaOffset int64) (*fsHeader, []byte, error) {
-	//read the NCA headerBytes
-	encNcaHeader := make([]byte, 0xC00)
-	n, err := reader.ReadAt(encNcaHeader, ncaOffset)
+// key area encryption key index, as stored in the NCA header
+const (
+	NcaKeyAreaKey_Application = iota
+	NcaKeyAreaKey_Ocean
+	NcaKeyAreaKey_System
+)
 
-	if err != nil {
-		return nil, nil, errors.New("failed to read NCA header " + err.Error())
-	}
-	if n != 0xC00 {
-		return nil, nil, errors.New("failed to read NCA header")
+// MissingKeyError is returned when a key required to decrypt the content is not found in prod.keys
+type MissingKeyError struct {
+	KeyName string
+}
+
+func (e *MissingKeyError) Error() string {
+	return "missing key - " + e.KeyName
+}
+
+func getKeyAreaKeyName(cryptoType byte, keyRevision int) (string, error) {
+	keyArea := ""
+	switch cryptoType {
+	case NcaKeyAreaKey_Application:
+		keyArea = "application"
+	case NcaKeyAreaKey_Ocean:
+		keyArea = "ocean"
+	case NcaKeyAreaKey_System:
+		keyArea = "system"
+	default:
+		return "", fmt.Errorf("unsupported key area crypto type [%v]", cryptoType)
 	}
+	return fmt.Sprintf("key_area_key_%v_%02x", keyArea, keyRevision), nil
+}
 
-	keys, err := settings.SwitchKeys()
-	if err != nil {
-		return nil, nil, err
+// getKey returns the decoded value of the given key, or MissingKeyError if it's not available
+func getKey(keyName string) ([]byte, error) {
+	keys, _ := settings.SwitchKeys()
+	if keys == nil {
+		return nil, &MissingKeyError{KeyName: keyName}
 	}
-	headerKey := keys.GetKey("header_key")
-	if headerKey == "" {
-		return nil, nil, errors.New("missing key - header_key")
+	keyString := keys.GetKey(keyName)
+	if keyString == "" {
+		return nil, &MissingKeyError{KeyName: keyName}
 	}
-	ncaHeader, err := DecryptNcaHeader(headerKey, encNcaHeader)
+	key, err := hex.DecodeString(keyString)
 	if err != nil {
-		return nil, nil, err
+		return nil, fmt.Errorf("invalid key - %v [reason:%v]", keyName, err)
 	}
+	return key, nil
+}
 
-	if ncaHeader.HasRightsId() {
-		//fail - need title keys
-		return nil, nil, errors.New("non standard encryption is not supported")
-	}
-
-	/*if ncaHeader.contentType != NcaContentType_Meta {
-		return nil, errors.New("not a meta NCA")
-	}*/
-
-	dataSectionIndex := 0
+func readNcaHeader(reader io.ReaderAt, ncaOffset int64) (*ncaHeader, error) {
+	//read the NCA headerBytes
+	encNcaHeader := make([]byte, 0xC00)
+	n, err := reader.ReadAt(encNcaHeader, ncaOffset)
 
-	fsHeader, err := getFsHeader(ncaHeader, dataSectionIndex)
 	if err != nil {
-		return nil, nil, err
+		return nil, errors.New("failed to read NCA header " + err.Error())
 	}
-
-	entry := getFsEntry(ncaHeader, dataSectionIndex)
-
-	if entry.Size == 0 {
-		return nil, nil, errors.New("empty section")
+	if n != 0xC00 {
+		return nil, errors.New("failed to read NCA header")
 	}
 
-	encodedEntryContent := make([]byte, entry.Size)
-	entryOffset := ncaOffset + int64(entry.StartOffset)
-	_, err = reader.ReadAt(encodedEntryContent, entryOffset)
+	keys, err := settings.SwitchKeys()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	if fsHeader.encType != 3 {
-		return nil, nil, errors.New("non supported encryption type [encryption type:" + string(fsHeader.encType))
+	if keys == nil || keys.GetKey("header_key") == "" {
+		return nil, &MissingKeyError{KeyName: "header_key"}
 	}
+	return DecryptNcaHeader(keys.GetKey("header_key"), encNcaHeader)
+}
 
-	/*if fsHeader.hashType != 2 { //Sha256 (FS_TYPE_PFS0)
-		return nil, errors.New("non FS_TYPE_PFS0")
-	}*/
-	decoded, err := decryptAesCtr(ncaHeader, fsHeader, entry.StartOffset, entry.Size, encodedEntryContent)
+// getSectionKey returns the AES-CTR key of the NCA sections - the title key (if the NCA has a rights id),
+// or the decrypted key from the key area
+func getSectionKey(ncaHeader *ncaHeader, titleKey []byte) ([]byte, error) {
+	if titleKey != nil {
+		return titleKey, nil
+	}
+	keyName, err := getKeyAreaKeyName(ncaHeader.cryptoType, ncaHeader.getKeyRevision())
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	hashInfo, err := fsHeader.getHashInfo()
+	key, err := getKey(keyName)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-
-	return fsHeader, decoded[hashInfo.pfs0HeaderOffset:], nil
+	return _crypto.DecryptAes128Ecb(ncaHeader.encryptedKeys[0x20:0x30], key), nil
 }
 
-func decryptAesCtr(ncaHeader *ncaHeader, fsHeader *fsHeader, offset uint32, size uint32, encoded []byte) ([]byte, error) {
-	keyRevision := ncaHeader.getKeyRevision()
-	cryptoType := ncaHeader.cryptoType
-
-	if cryptoType != 0 {
-		return []byte{}, errors.New("unsupported crypto type")
-	}
-
-	keys, _ := settings.SwitchKeys()
-
-	keyName := fmt.Sprintf("key_area_key_application_0%x", keyRevision)
-	KeyString := keys.GetKey(keyName)
-	if KeyString == "" {
-		return nil, errors.New(fmt.Sprintf("missing Key_area_key[%v]", keyName))
-	}
-	key, _ := hex.DecodeString(KeyString)
-
-	decKey := _crypto.DecryptAes128Ecb(ncaHeader.encryptedKeys[0x20:0x30], key)
-
-	counter := make([]byte, 0x10)
-	binary.BigEndian.PutUint64(counter, uint64(fsHeader.generation))
-	binary.BigEndian.PutUint64(counter[8:], uint64(offset/0x10))
-
-	c, _ := aes.NewCipher(decKey)
-
-	decContent := make([]byte, size)
-
-	s := cipher.NewCTR(c, counter)
-	s.XORKeyStream(decContent, encoded[0:size])
-
-	return decContent, nil
+// getSectionCounter returns the upper 8 bytes of the section AES-CTR counter
+func getSectionCounter(fsHeader *fsHeader) []byte {
+	counter := make([]byte, 0x8)
+	binary.BigEndian.PutUint32(counter, fsHeader.secureValue)
+	binary.BigEndian.PutUint32(counter[4:], fsHeader.generation)
+	return counter
 }
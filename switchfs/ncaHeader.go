@@ -4,8 +4,12 @@ import (
 	"crypto/aes"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/giwty/switch-library-manager/settings"
 	"github.com/giwty/switch-library-manager/switchfs/_crypto"
 	"strconv"
+	"strings"
 )
 
 //https://switchbrew.org/wiki/NCA_Format
@@ -20,7 +24,7 @@ type ncaHeader struct {
 	keyGeneration1 byte
 	encryptedKeys  []byte // 4 * 0x10
 	cryptoType     byte   //(0x00 = Application, 0x01 = Ocean, 0x02 = System)
-
+	sdkVersion     uint32
 }
 
 func (n *ncaHeader) HasRightsId() bool {
@@ -41,6 +45,11 @@ func (n *ncaHeader) getKeyRevision() int {
 	return int(keyRevision)
 }
 
+// getSdkVersion returns the SDK version the NCA was built with (e.g. 9.3.0)
+func (n *ncaHeader) getSdkVersion() string {
+	return fmt.Sprintf("%v.%v.%v", (n.sdkVersion>>24)&0xFF, (n.sdkVersion>>16)&0xFF, (n.sdkVersion>>8)&0xFF)
+}
+
 func max(a byte, b byte) byte {
 	if a > b {
 		return a
@@ -79,6 +88,7 @@ func DecryptNcaHeader(key string, encHeader []byte) (*ncaHeader, error) {
 	result.titleId = []byte(strconv.FormatInt(int64(title_id_dec), 16))
 	result.keyGeneration1 = decryptNcaHeader[0x206:0x207][0]
 	result.keyGeneration2 = decryptNcaHeader[0x220:0x221][0]
+	result.sdkVersion = binary.LittleEndian.Uint32(decryptNcaHeader[0x21C:0x220])
 
 	encryptedKeysAreaOffset := 0x300
 	result.encryptedKeys = decryptNcaHeader[encryptedKeysAreaOffset : encryptedKeysAreaOffset+(0x10*4)]
@@ -88,6 +98,72 @@ func DecryptNcaHeader(key string, encHeader []byte) (*ncaHeader, error) {
 	return &result, nil
 }
 
+// CheckHeaderKey decrypts the header of the first NCA found in the given NSP/XCI file,
+// and verifies that the header_key produces a valid NCA magic
+func CheckHeaderKey(filePath string) error {
+	keys, _ := settings.SwitchKeys()
+	if keys == nil || keys.GetKey("header_key") == "" {
+		return &MissingKeyError{KeyName: "header_key"}
+	}
+
+	file, err := OpenFile(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	partition, partitionOffset, err := openPartition(file)
+	if err != nil {
+		return err
+	}
+
+	for _, pfs0File := range partition.Files {
+		name := strings.ToLower(pfs0File.Name)
+		if !strings.HasSuffix(name, ".nca") && !strings.HasSuffix(name, ".ncz") {
+			continue
+		}
+		encNcaHeader := make([]byte, 0xC00)
+		_, err = file.ReadAt(encNcaHeader, partitionOffset+int64(pfs0File.StartOffset))
+		if err != nil {
+			return errors.New("failed to read NCA header " + err.Error())
+		}
+		ncaHeader, err := DecryptNcaHeader(keys.GetKey("header_key"), encNcaHeader)
+		if err != nil {
+			return err
+		}
+		magic := string(ncaHeader.headerBytes[0x200:0x204])
+		if magic != "NCA3" && magic != "NCA2" {
+			return errors.New("header_key is invalid, failed to decrypt NCA header [" + pfs0File.Name + "]")
+		}
+		return nil
+	}
+	return errors.New("no NCA found in " + filePath)
+}
+
+// encryptNcaHeader encrypts the (decrypted) NCA3 header with the header key
+func encryptNcaHeader(headerBytes []byte) ([]byte, error) {
+	if len(headerBytes) != 0xC00 || string(headerBytes[0x200:0x204]) != "NCA3" {
+		return nil, errors.New("only NCA3 headers are supported")
+	}
+	keys, _ := settings.SwitchKeys()
+	if keys == nil || keys.GetKey("header_key") == "" {
+		return nil, &MissingKeyError{KeyName: "header_key"}
+	}
+	headerKey, _ := hex.DecodeString(keys.GetKey("header_key"))
+	c, err := _crypto.NewCipher(aes.NewCipher, headerKey)
+	if err != nil {
+		return nil, err
+	}
+	sectorSize := 0x200
+	encrypted := make([]byte, len(headerBytes))
+	for sectorNum := 0; sectorNum*sectorSize < len(headerBytes); sectorNum++ {
+		tweak := getNintendoTweak(sectorNum)
+		pos := sectorSize * sectorNum
+		c.EncryptSector(encrypted[pos:pos+sectorSize], headerBytes[pos:pos+sectorSize], &tweak)
+	}
+	return encrypted, nil
+}
+
 func _decryptNcaHeader(c *_crypto.Cipher, header []byte, end int, sectorSize int, sectorNum int) ([]byte, error) {
 	decrypted := make([]byte, len(header))
 	for pos := 0; pos < end; pos += sectorSize {
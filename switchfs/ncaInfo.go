@@ -0,0 +1,216 @@
+package switchfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+//https://switchbrew.org/wiki/NCA_Format
+
+// NcaDistributionType is the distribution type of the NCA (download / gamecard)
+type NcaDistributionType byte
+
+// NcaContentType is the content type of the NCA (program, meta, control...)
+type NcaContentType byte
+
+// NcaKeyAreaKeyIndex is the key area encryption key of the NCA (application, ocean, system)
+type NcaKeyAreaKeyIndex byte
+
+// FsType is the filesystem of an NCA section
+type FsType byte
+
+// HashType is the hash layout of an NCA section
+type HashType byte
+
+// EncryptionType is the encryption of an NCA section
+type EncryptionType byte
+
+func (t NcaDistributionType) String() string {
+	return getEnumName([]string{"Download", "GameCard"}, byte(t))
+}
+
+func (t NcaContentType) String() string {
+	return getEnumName([]string{"Program", "Meta", "Control", "Manual", "Data", "PublicData"}, byte(t))
+}
+
+func (t NcaKeyAreaKeyIndex) String() string {
+	return getEnumName([]string{"Application", "Ocean", "System"}, byte(t))
+}
+
+func (t FsType) String() string {
+	return getEnumName([]string{"RomFs", "PartitionFs"}, byte(t))
+}
+
+func (t HashType) String() string {
+	return getEnumName([]string{"Auto", "None", "HierarchicalSha256", "HierarchicalIntegrity",
+		"AutoSha3", "HierarchicalSha3256", "HierarchicalIntegritySha3"}, byte(t))
+}
+
+func (t EncryptionType) String() string {
+	return getEnumName([]string{"Auto", "None", "AesXts", "AesCtr", "AesCtrEx",
+		"AesCtrSkipLayerHash", "AesCtrExSkipLayerHash"}, byte(t))
+}
+
+func getEnumName(names []string, value byte) string {
+	if int(value) < len(names) {
+		return names[value]
+	}
+	return fmt.Sprintf("Unknown (%v)", value)
+}
+
+// NcaInfo holds the fields of the NCA header, and of the FS header of each section
+type NcaInfo struct {
+	Magic                  string
+	FixedKeySignature      string
+	NpdmSignature          string
+	Distribution           NcaDistributionType
+	ContentType            NcaContentType
+	KeyGenerationOld       byte
+	KeyAreaKeyIndex        NcaKeyAreaKeyIndex
+	ContentSize            uint64
+	ProgramId              string
+	ContentIndex           uint32
+	SdkAddonVersion        uint32
+	SdkVersion             string
+	KeyGeneration          byte
+	SignatureKeyGeneration byte
+	RightsId               string //empty if the NCA doesn't use titlekey crypto
+	EncryptedKeyArea       []string
+	Sections               []NcaSectionInfo
+}
+
+// NcaSectionInfo holds the section table entry and the FS header fields of an NCA section
+type NcaSectionInfo struct {
+	Index               int
+	StartOffset         int64 //relative to the NCA
+	EndOffset           int64
+	Size                int64
+	HeaderHash          string
+	HeaderHashValid     bool
+	Version             uint16
+	FsType              FsType
+	HashType            HashType
+	EncryptionType      EncryptionType
+	MetaDataHashType    byte
+	MasterHash          string
+	HashLevels          []NcaHashLevel
+	Generation          uint32
+	SecureValue         uint32
+	Counter             string //the upper 8 bytes of the AES-CTR counter
+	PatchInfo           *NcaPatchInfo
+	HasSparseInfo       bool
+	HasCompressionInfo  bool
+	HasMetaDataHashInfo bool
+}
+
+// NcaHashLevel is a hash level of the section (the last level is the data), the offset is relative to the section
+type NcaHashLevel struct {
+	Offset    int64
+	Size      int64
+	BlockSize int64
+}
+
+// NcaPatchInfo is the BKTR info of patch sections (the indirect and AesCtrEx bucket trees)
+type NcaPatchInfo struct {
+	IndirectOffset int64
+	IndirectSize   int64
+	AesCtrExOffset int64
+	AesCtrExSize   int64
+}
+
+// InspectNca decrypts the header of the NCA at the given offset, and returns all the header fields,
+// the sections with an invalid FS header hash are returned as well (HeaderHashValid is false)
+func InspectNca(reader io.ReaderAt, offset int64) (*NcaInfo, error) {
+	header, err := readNcaHeader(reader, offset)
+	if err != nil {
+		return nil, err
+	}
+	headerBytes := header.headerBytes
+	result := &NcaInfo{
+		Magic:                  string(headerBytes[0x200:0x204]),
+		FixedKeySignature:      fmt.Sprintf("%x", headerBytes[0x0:0x100]),
+		NpdmSignature:          fmt.Sprintf("%x", headerBytes[0x100:0x200]),
+		Distribution:           NcaDistributionType(header.distribution),
+		ContentType:            NcaContentType(header.contentType),
+		KeyGenerationOld:       header.keyGeneration1,
+		KeyAreaKeyIndex:        NcaKeyAreaKeyIndex(header.cryptoType),
+		ContentSize:            binary.LittleEndian.Uint64(headerBytes[0x208:0x210]),
+		ProgramId:              fmt.Sprintf("%016x", binary.LittleEndian.Uint64(headerBytes[0x210:0x218])),
+		ContentIndex:           binary.LittleEndian.Uint32(headerBytes[0x218:0x21C]),
+		SdkAddonVersion:        header.sdkVersion,
+		SdkVersion:             header.getSdkVersion(),
+		KeyGeneration:          header.keyGeneration2,
+		SignatureKeyGeneration: headerBytes[0x221],
+	}
+	if header.HasRightsId() {
+		result.RightsId = fmt.Sprintf("%x", header.rightsId)
+	}
+	for i := 0; i < 4; i++ {
+		result.EncryptedKeyArea = append(result.EncryptedKeyArea, fmt.Sprintf("%x", header.encryptedKeys[i*0x10:(i+1)*0x10]))
+	}
+	if len(headerBytes) < 0xC00 {
+		//NCA2 headers are not supported, the FS headers are encrypted separately
+		return result, nil
+	}
+
+	for i := 0; i < 4; i++ {
+		entryBytes := headerBytes[0x240+0x10*i : 0x240+0x10*(i+1)]
+		startOffset := int64(binary.LittleEndian.Uint32(entryBytes[0x0:0x4])) * 0x200
+		endOffset := int64(binary.LittleEndian.Uint32(entryBytes[0x4:0x8])) * 0x200
+		if endOffset == 0 {
+			continue
+		}
+		fsHeaderBytes := headerBytes[0x400+0x200*i : 0x400+0x200*(i+1)]
+		expectedHash := headerBytes[0x280+0x20*i : 0x280+0x20*(i+1)]
+		actualHash := sha256.Sum256(fsHeaderBytes)
+		fsHeader := parseFsHeader(fsHeaderBytes)
+		section := NcaSectionInfo{
+			Index:               i,
+			StartOffset:         startOffset,
+			EndOffset:           endOffset,
+			Size:                endOffset - startOffset,
+			HeaderHash:          fmt.Sprintf("%x", expectedHash),
+			HeaderHashValid:     bytes.Equal(actualHash[:], expectedHash),
+			Version:             binary.LittleEndian.Uint16(fsHeaderBytes[0x0:0x2]),
+			FsType:              FsType(fsHeader.fsType),
+			HashType:            HashType(fsHeader.hashType),
+			EncryptionType:      EncryptionType(fsHeader.encType),
+			MetaDataHashType:    fsHeaderBytes[0x5],
+			Generation:          fsHeader.generation,
+			SecureValue:         fsHeader.secureValue,
+			Counter:             fmt.Sprintf("%x", getSectionCounter(fsHeader)),
+			HasSparseInfo:       !isZero(fsHeaderBytes[0x148:0x178]),
+			HasCompressionInfo:  !isZero(fsHeaderBytes[0x178:0x1A0]),
+			HasMetaDataHashInfo: !isZero(fsHeaderBytes[0x1A0:0x1D0]),
+		}
+		if tree, err := fsHeader.getHashTree(); err == nil {
+			section.MasterHash = fmt.Sprintf("%x", tree.masterHash)
+			for _, level := range tree.levels {
+				section.HashLevels = append(section.HashLevels, NcaHashLevel{Offset: level.offset, Size: level.size, BlockSize: level.blockSize})
+			}
+		}
+		patchInfo := fsHeaderBytes[0x100:0x140]
+		if !isZero(patchInfo) {
+			section.PatchInfo = &NcaPatchInfo{
+				IndirectOffset: int64(binary.LittleEndian.Uint64(patchInfo[0x0:0x8])),
+				IndirectSize:   int64(binary.LittleEndian.Uint64(patchInfo[0x8:0x10])),
+				AesCtrExOffset: int64(binary.LittleEndian.Uint64(patchInfo[0x20:0x28])),
+				AesCtrExSize:   int64(binary.LittleEndian.Uint64(patchInfo[0x28:0x30])),
+			}
+		}
+		result.Sections = append(result.Sections, section)
+	}
+	return result, nil
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package switchfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/giwty/switch-library-manager/settings"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestInspectNca(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "prod.keys"), []byte("header_key = "+hex.EncodeToString(bytes.Repeat([]byte{0x11}, 0x20))+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = settings.InitSwitchKeys(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	header := make([]byte, 0xC00)
+	header[0x0] = 0xAA
+	header[0x100] = 0xBB
+	copy(header[0x200:], "NCA3")
+	header[0x204] = 1 //gamecard
+	header[0x205] = NcaContentType_Program
+	header[0x206] = 2
+	header[0x207] = 1 //ocean
+	binary.LittleEndian.PutUint64(header[0x208:], 0x10000)
+	binary.LittleEndian.PutUint64(header[0x210:], 0x0100000000010800)
+	binary.LittleEndian.PutUint32(header[0x218:], 3)
+	binary.LittleEndian.PutUint32(header[0x21C:], 0x0C010400)
+	header[0x220] = 0xB
+	header[0x221] = 1
+	copy(header[0x230:], bytes.Repeat([]byte{0xCD}, 0x10))
+
+	//section 0: PFS0 with a valid FS header hash
+	binary.LittleEndian.PutUint32(header[0x240:], 0x4000/0x200)
+	binary.LittleEndian.PutUint32(header[0x244:], 0x8000/0x200)
+	fsHeader0 := header[0x400:0x600]
+	binary.LittleEndian.PutUint16(fsHeader0[0x0:], 2)
+	fsHeader0[0x2] = 1
+	fsHeader0[0x3] = HashType_HierarchicalSha256
+	fsHeader0[0x4] = FsEncryptionType_AesCtr
+	copy(fsHeader0[0x8:0x28], bytes.Repeat([]byte{0xEE}, 0x20))
+	binary.LittleEndian.PutUint32(fsHeader0[0x28:], 0x1000)
+	binary.LittleEndian.PutUint32(fsHeader0[0x2C:], 2)
+	binary.LittleEndian.PutUint64(fsHeader0[0x38:], 0x200)
+	binary.LittleEndian.PutUint64(fsHeader0[0x40:], 0x200)
+	binary.LittleEndian.PutUint64(fsHeader0[0x48:], 0x3E00)
+	binary.LittleEndian.PutUint32(fsHeader0[0x140:], 7)
+	binary.LittleEndian.PutUint32(fsHeader0[0x144:], 9)
+	hash := sha256.Sum256(fsHeader0)
+	copy(header[0x280:], hash[:])
+
+	//section 2: patch RomFS (AesCtrEx) with an invalid FS header hash
+	binary.LittleEndian.PutUint32(header[0x260:], 0x8000/0x200)
+	binary.LittleEndian.PutUint32(header[0x264:], 0x10000/0x200)
+	fsHeader2 := header[0x800:0xA00]
+	fsHeader2[0x3] = HashType_HierarchicalIntegrity
+	fsHeader2[0x4] = FsEncryptionType_AesCtrEx
+	binary.LittleEndian.PutUint64(fsHeader2[0x100:], 0x6000)
+	binary.LittleEndian.PutUint64(fsHeader2[0x108:], 0x800)
+	binary.LittleEndian.PutUint64(fsHeader2[0x120:], 0x7000)
+	binary.LittleEndian.PutUint64(fsHeader2[0x128:], 0x400)
+	copy(header[0x2C0:], bytes.Repeat([]byte{0x1}, 0x20))
+
+	encrypted, err := encryptNcaHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nca := append(make([]byte, 0x100), encrypted...)
+	info, err := InspectNca(bytes.NewReader(nca), 0x100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Magic != "NCA3" || info.FixedKeySignature[:4] != "aa00" || info.NpdmSignature[:4] != "bb00" ||
+		info.Distribution.String() != "GameCard" || info.ContentType.String() != "Program" ||
+		info.KeyGenerationOld != 2 || info.KeyAreaKeyIndex.String() != "Ocean" || info.ContentSize != 0x10000 ||
+		info.ProgramId != "0100000000010800" || info.ContentIndex != 3 || info.SdkAddonVersion != 0x0C010400 ||
+		info.SdkVersion != "12.1.4" || info.KeyGeneration != 0xB || info.SignatureKeyGeneration != 1 ||
+		info.RightsId != hex.EncodeToString(bytes.Repeat([]byte{0xCD}, 0x10)) {
+		t.Fatalf("unexpected header %+v", info)
+	}
+	if len(info.Sections) != 2 {
+		t.Fatalf("expected 2 sections, got %v", len(info.Sections))
+	}
+
+	section := info.Sections[0]
+	if section.Index != 0 || section.StartOffset != 0x4000 || section.EndOffset != 0x8000 || section.Size != 0x4000 ||
+		!section.HeaderHashValid || section.Version != 2 || section.FsType.String() != "PartitionFs" ||
+		section.HashType.String() != "HierarchicalSha256" || section.EncryptionType.String() != "AesCtr" ||
+		section.MasterHash != hex.EncodeToString(bytes.Repeat([]byte{0xEE}, 0x20)) ||
+		section.Generation != 7 || section.SecureValue != 9 || section.Counter != "0000000900000007" || section.PatchInfo != nil {
+		t.Fatalf("unexpected section 0 %+v", section)
+	}
+	expectedLevels := []NcaHashLevel{{Offset: 0, Size: 0x200, BlockSize: 0x200}, {Offset: 0x200, Size: 0x3E00, BlockSize: 0x1000}}
+	if !reflect.DeepEqual(section.HashLevels, expectedLevels) {
+		t.Fatalf("unexpected hash levels %+v", section.HashLevels)
+	}
+
+	section = info.Sections[1]
+	if section.Index != 2 || section.StartOffset != 0x8000 || section.Size != 0x8000 || section.HeaderHashValid ||
+		section.FsType.String() != "RomFs" || section.EncryptionType.String() != "AesCtrEx" {
+		t.Fatalf("unexpected section 2 %+v", section)
+	}
+	expectedPatchInfo := &NcaPatchInfo{IndirectOffset: 0x6000, IndirectSize: 0x800, AesCtrExOffset: 0x7000, AesCtrExSize: 0x400}
+	if !reflect.DeepEqual(section.PatchInfo, expectedPatchInfo) {
+		t.Fatalf("unexpected patch info %+v", section.PatchInfo)
+	}
+}
+
+func TestParseFsHeader(t *testing.T) {
+	data := make([]byte, 0x200)
+	data[0x2] = 0x1
+	data[0x3] = HashType_HierarchicalSha256
+	data[0x4] = FsEncryptionType_AesCtr
+	binary.LittleEndian.PutUint32(data[0x140:], 7)
+	binary.LittleEndian.PutUint32(data[0x144:], 9)
+
+	header := parseFsHeader(data)
+	if FsType(header.fsType).String() != "PartitionFs" || HashType(header.hashType).String() != "HierarchicalSha256" ||
+		EncryptionType(header.encType).String() != "AesCtr" {
+		t.Fatalf("unexpected types %v %v %v", FsType(header.fsType), HashType(header.hashType), EncryptionType(header.encType))
+	}
+	if header.generation != 7 || header.secureValue != 9 {
+		t.Fatalf("unexpected generation %v secure value %v", header.generation, header.secureValue)
+	}
+	if EncryptionType(0x20).String() != "Unknown (32)" {
+		t.Fatalf("unexpected name %v", EncryptionType(0x20))
+	}
+}
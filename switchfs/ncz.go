@@ -0,0 +1,372 @@
+package switchfs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+//https://github.com/nicoboss/nsz
+
+const (
+	nczSectionMagic    = "NCZSECTN"
+	nczBlockMagic      = "NCZBLOCK"
+	nczHeaderSize      = 0x4000
+	nczSectionSize     = 0x40
+	nczBlockHeaderSize = 0x18
+)
+
+var (
+	// stateless decoder, safe for concurrent use of DecodeAll
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+type NczSection struct {
+	Offset        uint64
+	Size          uint64
+	CryptoType    uint64
+	CryptoKey     []byte
+	CryptoCounter []byte
+}
+
+type nczBlockHeader struct {
+	version           byte
+	blockType         byte
+	blockSizeExponent byte
+	numberOfBlocks    uint32
+	decompressedSize  uint64
+	blockSizes        []uint32
+	blockOffsets      []int64 //offset of each compressed block, relative to the data offset
+}
+
+// NczReader exposes an NCZ file as the original (encrypted) NCA - the sections are decompressed
+// and re-encrypted on the fly
+type NczReader struct {
+	sync.Mutex
+	reader     io.ReaderAt
+	offset     int64
+	dataOffset int64
+	dataSize   int64
+	size       int64
+	Sections   []NczSection
+	block      *nczBlockHeader
+
+	//block mode cache
+	cachedBlock     int
+	cachedBlockData []byte
+
+	//solid mode stream
+	stream    *zstd.Decoder
+	streamPos int64
+}
+
+// NewNczReader parses the NCZ section table (and block table if exists) at the given offset
+func NewNczReader(reader io.ReaderAt, offset int64, size int64) (*NczReader, error) {
+	result := &NczReader{reader: reader, offset: offset, cachedBlock: -1}
+
+	header := make([]byte, 0x10)
+	_, err := reader.ReadAt(header, offset+nczHeaderSize)
+	if err != nil {
+		return nil, errors.New("failed to read NCZ header " + err.Error())
+	}
+	if string(header[0x0:0x8]) != nczSectionMagic {
+		return nil, errors.New("Invalid NCZ header. Expected '" + nczSectionMagic + "', got '" + string(header[0x0:0x8]) + "'")
+	}
+	sectionCount := binary.LittleEndian.Uint64(header[0x8:0x10])
+	if sectionCount == 0 || sectionCount > 0x100 {
+		return nil, fmt.Errorf("invalid NCZ section count [%v]", sectionCount)
+	}
+	sectionsBytes := make([]byte, nczSectionSize*sectionCount)
+	_, err = reader.ReadAt(sectionsBytes, offset+nczHeaderSize+0x10)
+	if err != nil {
+		return nil, errors.New("failed to read NCZ sections " + err.Error())
+	}
+	for i := uint64(0); i < sectionCount; i++ {
+		sectionBytes := sectionsBytes[i*nczSectionSize : (i+1)*nczSectionSize]
+		section := NczSection{}
+		section.Offset = binary.LittleEndian.Uint64(sectionBytes[0x0:0x8])
+		section.Size = binary.LittleEndian.Uint64(sectionBytes[0x8:0x10])
+		section.CryptoType = binary.LittleEndian.Uint64(sectionBytes[0x10:0x18])
+		section.CryptoKey = sectionBytes[0x20:0x30]
+		section.CryptoCounter = sectionBytes[0x30:0x40]
+		result.Sections = append(result.Sections, section)
+		if int64(section.Offset+section.Size) > result.size {
+			result.size = int64(section.Offset + section.Size)
+		}
+	}
+
+	result.dataOffset = offset + nczHeaderSize + 0x10 + int64(nczSectionSize*sectionCount)
+	result.dataSize = size - (result.dataOffset - offset)
+
+	block, err := readNczBlockHeader(reader, result.dataOffset)
+	if err != nil {
+		return nil, err
+	}
+	if block != nil {
+		result.block = block
+		result.dataOffset += nczBlockHeaderSize + int64(4*block.numberOfBlocks)
+		result.size = nczHeaderSize + int64(block.decompressedSize)
+	}
+
+	return result, nil
+}
+
+func readNczBlockHeader(reader io.ReaderAt, offset int64) (*nczBlockHeader, error) {
+	header := make([]byte, nczBlockHeaderSize)
+	_, err := reader.ReadAt(header, offset)
+	if err != nil {
+		return nil, errors.New("failed to read NCZ block header " + err.Error())
+	}
+	if string(header[0x0:0x8]) != nczBlockMagic {
+		//solid compression
+		return nil, nil
+	}
+	result := &nczBlockHeader{}
+	result.version = header[0x8]
+	result.blockType = header[0x9]
+	result.blockSizeExponent = header[0xB]
+	result.numberOfBlocks = binary.LittleEndian.Uint32(header[0xC:0x10])
+	result.decompressedSize = binary.LittleEndian.Uint64(header[0x10:0x18])
+
+	if result.blockSizeExponent < 14 || result.blockSizeExponent > 32 {
+		return nil, fmt.Errorf("invalid NCZ block size exponent [%v]", result.blockSizeExponent)
+	}
+
+	blockSizesBytes := make([]byte, 4*result.numberOfBlocks)
+	_, err = reader.ReadAt(blockSizesBytes, offset+nczBlockHeaderSize)
+	if err != nil {
+		return nil, errors.New("failed to read NCZ block table " + err.Error())
+	}
+	result.blockSizes = make([]uint32, result.numberOfBlocks)
+	result.blockOffsets = make([]int64, result.numberOfBlocks)
+	blockOffset := int64(0)
+	for i := uint32(0); i < result.numberOfBlocks; i++ {
+		result.blockSizes[i] = binary.LittleEndian.Uint32(blockSizesBytes[i*4 : (i+1)*4])
+		result.blockOffsets[i] = blockOffset
+		blockOffset += int64(result.blockSizes[i])
+	}
+	return result, nil
+}
+
+// Size returns the size of the decompressed NCA
+func (n *NczReader) Size() int64 {
+	return n.size
+}
+
+// IsBlockCompressed returns true if the NCZ uses block compression (allowing random access), false for solid compression
+func (n *NczReader) IsBlockCompressed() bool {
+	return n.block != nil
+}
+
+func (n *NczReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= n.size {
+		return 0, io.EOF
+	}
+	length := len(p)
+	if off+int64(length) > n.size {
+		length = int(n.size - off)
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	read := 0
+	//the NCA header is kept as is
+	if off < nczHeaderSize {
+		headerLength := length
+		if off+int64(headerLength) > nczHeaderSize {
+			headerLength = int(nczHeaderSize - off)
+		}
+		_, err := n.reader.ReadAt(p[:headerLength], n.offset+off)
+		if err != nil {
+			return 0, err
+		}
+		read = headerLength
+	}
+
+	if read < length {
+		dataOff := off + int64(read)
+		err := n.readDecompressed(p[read:length], dataOff-nczHeaderSize)
+		if err != nil {
+			return read, err
+		}
+		n.encrypt(p[read:length], dataOff)
+		read = length
+	}
+
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+func (n *NczReader) Close() error {
+	n.Lock()
+	defer n.Unlock()
+	if n.stream != nil {
+		n.stream.Close()
+		n.stream = nil
+	}
+	return nil
+}
+
+// encrypt re-encrypts the decompressed data using the section crypto (data is at the given NCA offset)
+func (n *NczReader) encrypt(data []byte, ncaOffset int64) {
+	applySectionCrypto(n.Sections, data, ncaOffset)
+}
+
+// applySectionCrypto applies the AES-CTR of the sections to the data (at the given NCA offset),
+// encryption and decryption are the same operation
+func applySectionCrypto(sections []NczSection, data []byte, ncaOffset int64) {
+	for _, section := range sections {
+		if section.CryptoType != FsEncryptionType_AesCtr && section.CryptoType != FsEncryptionType_AesCtrEx {
+			continue
+		}
+		start := max64(ncaOffset, int64(section.Offset))
+		end := min64(ncaOffset+int64(len(data)), int64(section.Offset+section.Size))
+		if start >= end {
+			continue
+		}
+		chunk := data[start-ncaOffset : end-ncaOffset]
+		stream := newAesCtrStream(section.CryptoKey, section.CryptoCounter[0:8], start)
+		stream.XORKeyStream(chunk, chunk)
+	}
+}
+
+func (n *NczReader) readDecompressed(p []byte, off int64) error {
+	if n.block != nil {
+		return n.readBlocks(p, off)
+	}
+	return n.readSolid(p, off)
+}
+
+func (n *NczReader) readBlocks(p []byte, off int64) error {
+	blockSize := int64(1) << n.block.blockSizeExponent
+	read := 0
+	for read < len(p) {
+		currOff := off + int64(read)
+		blockIndex := int(currOff / blockSize)
+		if blockIndex >= len(n.block.blockSizes) {
+			return io.ErrUnexpectedEOF
+		}
+		data, err := n.getBlock(blockIndex, blockSize)
+		if err != nil {
+			return err
+		}
+		inBlockOffset := currOff - int64(blockIndex)*blockSize
+		if inBlockOffset >= int64(len(data)) {
+			return io.ErrUnexpectedEOF
+		}
+		read += copy(p[read:], data[inBlockOffset:])
+	}
+	return nil
+}
+
+func (n *NczReader) getBlock(blockIndex int, blockSize int64) ([]byte, error) {
+	if n.cachedBlock == blockIndex {
+		return n.cachedBlockData, nil
+	}
+	decompressedBlockSize := blockSize
+	if blockIndex == len(n.block.blockSizes)-1 {
+		if remainder := int64(n.block.decompressedSize) % blockSize; remainder != 0 {
+			decompressedBlockSize = remainder
+		}
+	}
+	compressed := make([]byte, n.block.blockSizes[blockIndex])
+	_, err := n.reader.ReadAt(compressed, n.dataOffset+n.block.blockOffsets[blockIndex])
+	if err != nil {
+		return nil, errors.New("failed to read NCZ block " + err.Error())
+	}
+	var data []byte
+	if int64(len(compressed)) < decompressedBlockSize {
+		data, err = zstdDecoder.DecodeAll(compressed, make([]byte, 0, decompressedBlockSize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress NCZ block [%v] - %v", blockIndex, err)
+		}
+	} else {
+		//block was stored uncompressed
+		data = compressed
+	}
+	n.cachedBlock = blockIndex
+	n.cachedBlockData = data
+	return data, nil
+}
+
+// readSolid reads from the solid zstd stream, the stream can only move forward, so reading
+// an earlier offset restarts the decompression
+func (n *NczReader) readSolid(p []byte, off int64) error {
+	if n.stream == nil || off < n.streamPos {
+		err := n.resetStream()
+		if err != nil {
+			return err
+		}
+	}
+	if off > n.streamPos {
+		skipped, err := io.CopyN(ioutil.Discard, n.stream, off-n.streamPos)
+		n.streamPos += skipped
+		if err != nil {
+			return err
+		}
+	}
+	read, err := io.ReadFull(n.stream, p)
+	n.streamPos += int64(read)
+	return err
+}
+
+func (n *NczReader) resetStream() error {
+	sectionReader := io.NewSectionReader(n.reader, n.dataOffset, n.dataSize)
+	if n.stream == nil {
+		stream, err := zstd.NewReader(sectionReader, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		n.stream = stream
+	} else {
+		err := n.stream.Reset(sectionReader)
+		if err != nil {
+			return err
+		}
+	}
+	n.streamPos = 0
+	return nil
+}
+
+// newAesCtrStream returns an AES-CTR stream positioned at the given offset of the section
+func newAesCtrStream(key []byte, nonce []byte, offset int64) cipher.Stream {
+	counter := make([]byte, 0x10)
+	copy(counter, nonce[0:8])
+	binary.BigEndian.PutUint64(counter[8:], uint64(offset/0x10))
+	c, _ := aes.NewCipher(key)
+	stream := cipher.NewCTR(c, counter)
+	//skip to the exact offset within the block
+	if skip := offset % 0x10; skip != 0 {
+		discard := make([]byte, skip)
+		stream.XORKeyStream(discard, discard)
+	}
+	return stream
+}
+
+func isNcz(fileName string) bool {
+	return strings.HasSuffix(strings.ToLower(fileName), ".ncz")
+}
+
+func max64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min64(a int64, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
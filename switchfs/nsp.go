@@ -1,69 +1,82 @@
 package switchfs
 
 import (
-	"bytes"
 	"errors"
+	"fmt"
 	"go.uber.org/zap"
+	"io/fs"
 	"strings"
 )
 
 func ReadNspMetadata(filePath string) (map[string]*ContentMetaAttributes, error) {
-
-	pfs0, err := ReadPfs0File(filePath)
+	container, err := OpenContainer(filePath)
 	if err != nil {
-		return nil, errors.New("Invalid NSP file, reason - [" + err.Error() + "]")
+		return nil, fmt.Errorf("Invalid NSP file, reason - [%w]", err)
 	}
+	defer container.Close()
 
-	file, err := OpenFile(filePath)
-	if err != nil {
-		return nil, err
+	if container.IsXci() {
+		return nil, errors.New("Invalid NSP file, reason - [XCI file]")
 	}
+	return readContainerMetadata(container)
+}
 
-	defer file.Close()
-
+// readContainerMetadata reads the cnmt (and the nacp of base/update titles) of every title in the NCA partition
+func readContainerMetadata(container *Container) (map[string]*ContentMetaAttributes, error) {
+	partition := container.NcaPartition()
 	contentMap := map[string]*ContentMetaAttributes{}
-
-	for _, pfs0File := range pfs0.Files {
-
-		fileOffset := int64(pfs0File.StartOffset)
-
-		if strings.Contains(pfs0File.Name, "cnmt.nca") {
-			_, section, err := openMetaNcaDataSection(file, fileOffset)
-			if err != nil {
-				return nil, err
-			}
-			currpfs0, err := readPfs0(bytes.NewReader(section), 0x0)
+	for _, name := range partition.FileNames() {
+		if !isCnmtNca(name) {
+			continue
+		}
+		cnmtNca, err := partition.OpenNca(name)
+		if err != nil {
+			return nil, err
+		}
+		cnmt, err := readCnmtFile(cnmtNca)
+		if err != nil {
+			return nil, err
+		}
+		currCnmt, err := readBinaryCnmt(cnmt)
+		if err != nil {
+			return nil, err
+		}
+		if currCnmt.Type == "BASE" || currCnmt.Type == "UPD" {
+			nacp, err := ExtractNacp(currCnmt, partition)
 			if err != nil {
-				return nil, err
+				zap.S().Debugf("Failed to extract nacp [%v]\n", err.Error())
 			}
-			currCnmt, err := readBinaryCnmt(currpfs0, section)
+			currCnmt.Ncap = nacp
+			exeFsInfo, err := ExtractExeFsInfo(currCnmt, partition)
 			if err != nil {
-				return nil, err
-			}
-			if currCnmt.Type != "DLC" {
-				nacp, err := ExtractNacp(currCnmt, file, pfs0, 0)
-				if err != nil {
-					zap.S().Debug("Failed to extract nacp [%v]\n", err.Error())
-				}
-				currCnmt.Ncap = nacp
+				zap.S().Debugf("Failed to read the ExeFS [%v]\n", err.Error())
+			} else {
+				currCnmt.BuildId = exeFsInfo.Main.BuildId
+				currCnmt.SdkVersion = exeFsInfo.SdkVersion
 			}
+		}
 
-			contentMap[currCnmt.TitleId] = currCnmt
-
-		} /*else if strings.Contains(pfs0File.Name, ".cnmt.xml") {
-			xmlBytes := make([]byte, pfs0File.Size)
-			_, err = file.ReadAt(xmlBytes, fileOffset)
-			if err != nil {
-				return nil, err
-			}
-
-			currCnmt, err := readXmlCnmt(xmlBytes)
-			if err != nil {
-				return nil, err
-			}
-			contentMap[currCnmt.TitleId] = currCnmt
-		}*/
+		contentMap[currCnmt.TitleId] = currCnmt
 	}
 	return contentMap, nil
+}
+
+// readCnmtFile reads the binary cnmt, the single file in the meta NCA PFS0 (e.g. Application_<title id>.cnmt)
+func readCnmtFile(cnmtNca *Nca) ([]byte, error) {
+	section, err := cnmtNca.Section(0)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(section, ".")
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, errors.New("unexpected pfs0")
+	}
+	return fs.ReadFile(section, entries[0].Name())
+}
 
+func isCnmtNca(name string) bool {
+	return strings.HasSuffix(name, ".cnmt.nca") || strings.HasSuffix(name, ".cnmt.ncz")
 }
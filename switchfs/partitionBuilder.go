@@ -0,0 +1,205 @@
+package switchfs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	partitionCopyBufferSize = 0x400000
+)
+
+// PartitionFile is a file of the partition to build, the data is read from the reader only when the partition is read
+type PartitionFile struct {
+	Name   string
+	Size   int64
+	Reader io.ReaderAt
+}
+
+type partitionEntry struct {
+	name             string
+	size             int64
+	reader           io.ReaderAt
+	hashedRegionSize uint32 //HFS0 only
+}
+
+// partitionBuilder lays out a PFS0/HFS0 partition (header + files) without copying the data,
+// the partition is exposed as an io.ReaderAt, so that it can be streamed to a file, or nested in another partition
+type partitionBuilder struct {
+	magic   string
+	entries []partitionEntry
+	header  []byte
+	parts   []readerPart
+	size    int64
+}
+
+type readerPart struct {
+	offset int64
+	size   int64
+	reader io.ReaderAt
+}
+
+// newPartitionBuilder builds the partition header, the string table is padded to stringTableSize (if it fits),
+// to keep the layout of the original partition, a stringTableSize of 0 aligns the header to 0x20
+func newPartitionBuilder(magic string, entries []partitionEntry, stringTableSize uint32) (*partitionBuilder, error) {
+	entryTableSize := PfsfileEntryTableSize
+	if magic == hfs0Magic {
+		entryTableSize = HfsfileEntryTableSize
+	} else if magic != pfs0Magic {
+		return nil, errors.New("unsupported partition type [" + magic + "]")
+	}
+
+	var stringTable []byte
+	nameOffsets := make([]uint32, len(entries))
+	for i, entry := range entries {
+		nameOffsets[i] = uint32(len(stringTable))
+		stringTable = append(stringTable, []byte(entry.name)...)
+		stringTable = append(stringTable, 0x0)
+	}
+	if stringTableSize != 0 && uint32(len(stringTable)) <= stringTableSize {
+		stringTable = append(stringTable, make([]byte, stringTableSize-uint32(len(stringTable)))...)
+	} else {
+		//align the header to 0x20
+		headerLen := 0x10 + entryTableSize*len(entries) + len(stringTable)
+		if remainder := headerLen % 0x20; remainder != 0 {
+			stringTable = append(stringTable, make([]byte, 0x20-remainder)...)
+		}
+	}
+
+	headerLen := 0x10 + entryTableSize*len(entries) + len(stringTable)
+	header := make([]byte, headerLen)
+	copy(header[0x0:0x4], magic)
+	binary.LittleEndian.PutUint32(header[0x4:0x8], uint32(len(entries)))
+	binary.LittleEndian.PutUint32(header[0x8:0xC], uint32(len(stringTable)))
+	copy(header[0x10+entryTableSize*len(entries):], stringTable)
+
+	result := &partitionBuilder{magic: magic, entries: entries, header: header}
+	result.parts = append(result.parts, readerPart{offset: 0, size: int64(headerLen), reader: byteReaderAt(header)})
+
+	dataOffset := int64(0)
+	for i, entry := range entries {
+		entryBytes := header[0x10+entryTableSize*i : 0x10+entryTableSize*(i+1)]
+		binary.LittleEndian.PutUint64(entryBytes[0x0:0x8], uint64(dataOffset))
+		binary.LittleEndian.PutUint64(entryBytes[0x8:0x10], uint64(entry.size))
+		binary.LittleEndian.PutUint32(entryBytes[0x10:0x14], nameOffsets[i])
+		if magic == hfs0Magic {
+			hashedRegionSize := int64(entry.hashedRegionSize)
+			if hashedRegionSize > entry.size {
+				hashedRegionSize = entry.size
+			}
+			hashedRegion := make([]byte, hashedRegionSize)
+			_, err := entry.reader.ReadAt(hashedRegion, 0)
+			if err != nil {
+				return nil, errors.New("failed to read [" + entry.name + "] - " + err.Error())
+			}
+			hash := sha256.Sum256(hashedRegion)
+			binary.LittleEndian.PutUint32(entryBytes[0x14:0x18], uint32(hashedRegionSize))
+			copy(entryBytes[0x20:0x40], hash[:])
+		}
+		result.parts = append(result.parts, readerPart{offset: int64(headerLen) + dataOffset, size: entry.size, reader: entry.reader})
+		dataOffset += entry.size
+	}
+	result.size = int64(headerLen) + dataOffset
+	return result, nil
+}
+
+// NewPfs0Reader lays out a PFS0 (header, string table aligned to 0x20, files) and exposes it as a reader,
+// the files are read on demand, so NCAs are never fully read to memory
+func NewPfs0Reader(files []PartitionFile) (*io.SectionReader, error) {
+	names := map[string]bool{}
+	entries := make([]partitionEntry, len(files))
+	for i, file := range files {
+		if file.Name == "" || strings.Contains(file.Name, "/") {
+			return nil, errors.New("invalid file name [" + file.Name + "]")
+		}
+		if names[file.Name] {
+			return nil, errors.New("duplicate file name [" + file.Name + "]")
+		}
+		names[file.Name] = true
+		if file.Size < 0 || file.Reader == nil {
+			return nil, errors.New("invalid file [" + file.Name + "]")
+		}
+		entries[i] = partitionEntry{name: file.Name, size: file.Size, reader: file.Reader}
+	}
+	builder, err := newPartitionBuilder(pfs0Magic, entries, 0)
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(builder, 0, builder.Size()), nil
+}
+
+// WritePfs0 streams the PFS0 to the output (see NewPfs0Reader), and returns the number of bytes written
+func WritePfs0(output io.Writer, files []PartitionFile) (int64, error) {
+	reader, err := NewPfs0Reader(files)
+	if err != nil {
+		return 0, err
+	}
+	return io.CopyBuffer(output, reader, make([]byte, partitionCopyBufferSize))
+}
+
+func (b *partitionBuilder) Size() int64 {
+	return b.size
+}
+
+func (b *partitionBuilder) HeaderSize() int64 {
+	return int64(len(b.header))
+}
+
+func (b *partitionBuilder) ReadAt(p []byte, off int64) (int, error) {
+	return readParts(b.parts, b.size, p, off)
+}
+
+// multiReaderAt concatenates the parts into a single reader
+type multiReaderAt struct {
+	parts []readerPart
+	size  int64
+}
+
+func (m *multiReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return readParts(m.parts, m.size, p, off)
+}
+
+// readParts reads from consecutive parts, as if they were a single file
+func readParts(parts []readerPart, size int64, p []byte, off int64) (int, error) {
+	if off >= size {
+		return 0, io.EOF
+	}
+	index := sort.Search(len(parts), func(i int) bool {
+		return parts[i].offset+parts[i].size > off
+	})
+	read := 0
+	for ; index < len(parts) && read < len(p); index++ {
+		part := parts[index]
+		partOffset := off + int64(read) - part.offset
+		length := int64(len(p) - read)
+		if partOffset+length > part.size {
+			length = part.size - partOffset
+		}
+		n, err := part.reader.ReadAt(p[read:int64(read)+length], partOffset)
+		read += n
+		if err != nil && !(err == io.EOF && int64(n) == length) {
+			return read, err
+		}
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+type byteReaderAt []byte
+
+func (b byteReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
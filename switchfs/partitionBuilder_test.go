@@ -0,0 +1,139 @@
+package switchfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// maxReadReaderAt records the largest read, to make sure files are streamed
+type maxReadReaderAt struct {
+	reader  io.ReaderAt
+	maxRead int
+}
+
+func (r *maxReadReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) > r.maxRead {
+		r.maxRead = len(p)
+	}
+	return r.reader.ReadAt(p, off)
+}
+
+func syntheticNca(size int, seed byte) []byte {
+	nca := make([]byte, size)
+	for i := range nca {
+		nca[i] = byte(i/0x100) ^ seed
+	}
+	return nca
+}
+
+func TestWritePfs0(t *testing.T) {
+	program := &maxReadReaderAt{reader: bytes.NewReader(syntheticNca(partitionCopyBufferSize*2+0x123, 0x1))}
+	files := []PartitionFile{
+		{Name: "0123456789abcdef0123456789abcdef.nca", Size: partitionCopyBufferSize*2 + 0x123, Reader: program},
+		{Name: "fedcba9876543210fedcba9876543210.cnmt.nca", Size: 0xC00, Reader: bytes.NewReader(syntheticNca(0xC00, 0x2))},
+		{Name: "0100000000010000000000000000000a.tik", Size: 0x2C0, Reader: bytes.NewReader(syntheticNca(0x2C0, 0x3))},
+		{Name: "empty.cert", Size: 0, Reader: bytes.NewReader(nil)},
+	}
+
+	output := &bytes.Buffer{}
+	written, err := WritePfs0(output, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != int64(output.Len()) {
+		t.Fatalf("expected %v bytes written, got %v", output.Len(), written)
+	}
+	if program.maxRead > partitionCopyBufferSize {
+		t.Fatalf("the NCA was read in a single [0x%x] read", program.maxRead)
+	}
+
+	pfs0, err := readPfs0(bytes.NewReader(output.Bytes()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pfs0.HeaderLen%0x20 != 0 {
+		t.Fatalf("header is not aligned [0x%x]", pfs0.HeaderLen)
+	}
+	if len(pfs0.Files) != len(files) {
+		t.Fatalf("expected %v files, got %v", len(files), len(pfs0.Files))
+	}
+	expectedSize := int64(pfs0.HeaderLen)
+	for i, file := range files {
+		entry := pfs0.Files[i]
+		if entry.Name != file.Name || int64(entry.Size) != file.Size {
+			t.Fatalf("unexpected entry [%v] [%v]", entry.Name, entry.Size)
+		}
+		expected := make([]byte, file.Size)
+		file.Reader.ReadAt(expected, 0)
+		if !bytes.Equal(output.Bytes()[entry.StartOffset:entry.StartOffset+entry.Size], expected) {
+			t.Fatalf("data mismatch [%v]", file.Name)
+		}
+		expectedSize += file.Size
+	}
+	if expectedSize != written {
+		t.Fatalf("expected size %v, got %v", expectedSize, written)
+	}
+}
+
+func TestWritePfs0InvalidFiles(t *testing.T) {
+	data := bytes.NewReader([]byte{0x1})
+	invalid := [][]PartitionFile{
+		{{Name: "a.nca", Size: 1, Reader: data}, {Name: "a.nca", Size: 1, Reader: data}},
+		{{Name: "", Size: 1, Reader: data}},
+		{{Name: "a/b.nca", Size: 1, Reader: data}},
+		{{Name: "a.nca", Size: 1}},
+	}
+	for _, files := range invalid {
+		if _, err := WritePfs0(io.Discard, files); err == nil {
+			t.Fatalf("expected an error for %v", files[len(files)-1].Name)
+		}
+	}
+}
+
+func TestPartitionBuilderUnpaddedStringTable(t *testing.T) {
+	names := []string{"a.nca", "bc.tik"}
+	data := [][]byte{syntheticNca(0x30, 0x1), syntheticNca(0x11, 0x2)}
+
+	// the string table is exactly "a.nca\0bc.tik\0", so the header (0x10+0x18*2+0xD) isn't aligned to 0x20
+	var stringTable []byte
+	header := make([]byte, 0x10+PfsfileEntryTableSize*len(names))
+	copy(header[0x0:0x4], pfs0Magic)
+	binary.LittleEndian.PutUint32(header[0x4:0x8], uint32(len(names)))
+	dataOffset := uint64(0)
+	for i, name := range names {
+		entryBytes := header[0x10+PfsfileEntryTableSize*i:]
+		binary.LittleEndian.PutUint64(entryBytes[0x0:0x8], dataOffset)
+		binary.LittleEndian.PutUint64(entryBytes[0x8:0x10], uint64(len(data[i])))
+		binary.LittleEndian.PutUint32(entryBytes[0x10:0x14], uint32(len(stringTable)))
+		stringTable = append(append(stringTable, []byte(name)...), 0x0)
+		dataOffset += uint64(len(data[i]))
+	}
+	binary.LittleEndian.PutUint32(header[0x8:0xC], uint32(len(stringTable)))
+	original := append(append(header, stringTable...), bytes.Join(data, nil)...)
+	if (len(header)+len(stringTable))%0x20 == 0 {
+		t.Fatal("the test header must not be aligned")
+	}
+
+	pfs0, err := readPfs0(bytes.NewReader(original), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []partitionEntry
+	for _, file := range pfs0.Files {
+		entries = append(entries, partitionEntry{name: file.Name, size: int64(file.Size),
+			reader: io.NewSectionReader(bytes.NewReader(original), int64(file.StartOffset), int64(file.Size))})
+	}
+	builder, err := newPartitionBuilder(pfs0.Magic, entries, pfs0.StringTableSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebuilt := make([]byte, builder.Size())
+	if _, err = builder.ReadAt(rebuilt, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rebuilt, original) {
+		t.Fatalf("unexpected partition, expected %v bytes, got %v", len(original), len(rebuilt))
+	}
+}
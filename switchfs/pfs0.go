@@ -14,17 +14,21 @@ const (
 )
 
 type fileEntry struct {
-	StartOffset uint64
-	Size        uint64
-	Name        string
+	StartOffset      uint64
+	Size             uint64
+	Name             string
+	HashedRegionSize uint32 //HFS0 only
+	Hash             []byte //HFS0 only
 }
 
 // PFS0 struct to represent PFS0 filesystem of NSP
 type PFS0 struct {
-	Filepath  string
-	Size      uint64
-	HeaderLen uint16
-	Files     []fileEntry
+	Filepath        string
+	Magic           string
+	Size            uint64
+	HeaderLen       uint16
+	StringTableSize uint32
+	Files           []fileEntry
 }
 
 // https://wiki.oatmealdome.me/PFS0_(File_Format)
@@ -63,7 +67,7 @@ func readPfs0(reader io.ReaderAt, offset int64) (*PFS0, error) {
 	} else {
 		return nil, errors.New("Invalid NSP headerBytes. Expected 'PFS0'/'HFS0', got '" + string(header[:0x4]) + "'")
 	}
-	p := &PFS0{}
+	p := &PFS0{Magic: string(header[:0x4])}
 
 	fileCount := binary.LittleEndian.Uint16(header[0x4:0x8])
 
@@ -71,6 +75,7 @@ func readPfs0(reader io.ReaderAt, offset int64) (*PFS0, error) {
 
 	stringsLen := binary.LittleEndian.Uint16(header[0x8:0xC])
 	p.HeaderLen = fileEntryTableOffset + stringsLen
+	p.StringTableSize = uint32(stringsLen)
 	fileNamesBuffer := make([]byte, stringsLen)
 	_, err = reader.ReadAt(fileNamesBuffer, offset+int64(fileEntryTableOffset))
 	if err != nil {
@@ -97,9 +102,12 @@ func readPfs0(reader io.ReaderAt, offset int64) (*PFS0, error) {
 			}
 		}
 
-		p.Files[i] = fileEntry{fileOffset + uint64(p.HeaderLen), fileSize, string(nameBytes)}
+		p.Files[i] = fileEntry{StartOffset: fileOffset + uint64(p.HeaderLen), Size: fileSize, Name: string(nameBytes)}
+		if fileEntryTableSize == HfsfileEntryTableSize {
+			p.Files[i].HashedRegionSize = binary.LittleEndian.Uint32(fileEntryTable[20:24])
+			p.Files[i].Hash = fileEntryTable[0x20:0x40]
+		}
 	}
-	p.HeaderLen += stringsLen
 
 	return p, nil
 }
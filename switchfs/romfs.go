@@ -3,6 +3,20 @@ package switchfs
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//https://switchbrew.org/wiki/RomFS
+
+const (
+	romfsHeaderSize = 0x50
+	romfsEmptyEntry = 0xFFFFFFFF
 )
 
 type RomfsHeader struct {
@@ -18,18 +32,31 @@ type RomfsHeader struct {
 	DataOffset          uint64
 }
 
-type RomfsFileEntry struct {
-	parent    uint32
-	sibling   uint32
-	offset    uint64
-	size      uint64
-	hash      uint32
-	name_size uint32
-	name      string
+type romfsDir struct {
+	name  string
+	dirs  []*romfsDir
+	files []*romfsFile
+}
+
+type romfsFile struct {
+	name   string
+	offset int64 //relative to the data offset
+	size   int64
+}
+
+// RomFS is a read only fs.FS of a RomFS image, files are read from the image on demand
+type RomFS struct {
+	reader io.ReaderAt
+	header RomfsHeader
+	root   *romfsDir
+	closer io.Closer
 }
 
 func readRomfsHeader(data []byte) (RomfsHeader, error) {
 	header := RomfsHeader{}
+	if len(data) < romfsHeaderSize {
+		return header, errors.New("invalid romfs header")
+	}
 	header.HeaderSize = binary.LittleEndian.Uint64(data[0x0+(0x8*0) : 0x0+(0x8*1)])
 	header.DirHashTableOffset = binary.LittleEndian.Uint64(data[0x0+(0x8*1) : 0x0+(0x8*2)])
 	header.DirHashTableSize = binary.LittleEndian.Uint64(data[0x0+(0x8*2) : 0x0+(0x8*3)])
@@ -40,29 +67,261 @@ func readRomfsHeader(data []byte) (RomfsHeader, error) {
 	header.FileMetaTableOffset = binary.LittleEndian.Uint64(data[0x0+(0x8*7) : 0x0+(0x8*8)])
 	header.FileMetaTableSize = binary.LittleEndian.Uint64(data[0x0+(0x8*8) : 0x0+(0x8*9)])
 	header.DataOffset = binary.LittleEndian.Uint64(data[0x0+(0x8*9) : 0x0+(0x8*10)])
+	if header.HeaderSize != romfsHeaderSize {
+		return header, fmt.Errorf("invalid romfs header size [0x%x]", header.HeaderSize)
+	}
 	return header, nil
 }
 
-func readRomfsFileEntry(data []byte, header RomfsHeader) (map[string]RomfsFileEntry, error) {
-	if header.FileMetaTableOffset+header.FileMetaTableSize > uint64(len(data)) {
-		return nil, errors.New("failed to read romfs")
-	}
-	dirBytes := data[header.FileMetaTableOffset : header.FileMetaTableOffset+header.FileMetaTableSize]
-	result := map[string]RomfsFileEntry{}
-	offset := uint32(0x0)
-	for offset < uint32(header.FileHashTableSize) {
-		entry := RomfsFileEntry{}
-		entry.parent = binary.LittleEndian.Uint32(dirBytes[offset : offset+0x4])
-		entry.sibling = binary.LittleEndian.Uint32(dirBytes[offset+0x4 : offset+0x8])
-		entry.offset = binary.LittleEndian.Uint64(dirBytes[offset+0x8 : offset+0x10])
-		entry.size = binary.LittleEndian.Uint64(dirBytes[offset+0x10 : offset+0x18])
-		entry.hash = binary.LittleEndian.Uint32(dirBytes[offset+0x18 : offset+0x1C])
-		entry.name_size = binary.LittleEndian.Uint32(dirBytes[offset+0x1C : offset+0x20])
-		entry.name = string(dirBytes[offset+0x20 : (offset+0x20)+entry.name_size])
-		result[entry.name] = entry
-		offset = offset + 0x20 + entry.name_size
+// OpenRomFS reads the directory and file tables of the RomFS image
+func OpenRomFS(reader io.ReaderAt) (*RomFS, error) {
+	headerBytes := make([]byte, romfsHeaderSize)
+	_, err := reader.ReadAt(headerBytes, 0)
+	if err != nil {
+		return nil, errors.New("failed to read romfs header " + err.Error())
 	}
+	header, err := readRomfsHeader(headerBytes)
+	if err != nil {
+		return nil, err
+	}
+	if header.DirMetaTableSize > 0x10000000 || header.FileMetaTableSize > 0x10000000 {
+		return nil, errors.New("invalid romfs meta tables")
+	}
+	dirTable := make([]byte, header.DirMetaTableSize)
+	_, err = reader.ReadAt(dirTable, int64(header.DirMetaTableOffset))
+	if err != nil {
+		return nil, errors.New("failed to read romfs directory table " + err.Error())
+	}
+	fileTable := make([]byte, header.FileMetaTableSize)
+	_, err = reader.ReadAt(fileTable, int64(header.FileMetaTableOffset))
+	if err != nil {
+		return nil, errors.New("failed to read romfs file table " + err.Error())
+	}
+
+	result := &RomFS{reader: reader, header: header}
+	result.root, err = readRomfsDir(dirTable, fileTable, 0, map[uint32]bool{}, map[uint32]bool{})
+	if err != nil {
+		return nil, err
+	}
+	result.root.name = "."
 	return result, nil
+}
+
+// readRomfsDir reads the directory entry at the given offset, and its children (recursively). Every entry
+// is read once, so a malformed table with a loop in the sibling or child chains fails instead of looping forever.
+func readRomfsDir(dirTable []byte, fileTable []byte, offset uint32, visitedDirs map[uint32]bool, visitedFiles map[uint32]bool) (*romfsDir, error) {
+	if visitedDirs[offset] {
+		return nil, errors.New("invalid romfs directory table (loop)")
+	}
+	visitedDirs[offset] = true
+	if len(visitedDirs) > len(dirTable)/0x18 {
+		return nil, errors.New("invalid romfs directory table (too many entries)")
+	}
+	if uint64(offset)+0x18 > uint64(len(dirTable)) {
+		return nil, errors.New("invalid romfs directory entry offset")
+	}
+	entry := dirTable[offset:]
+	childDir := binary.LittleEndian.Uint32(entry[0x8:0xC])
+	childFile := binary.LittleEndian.Uint32(entry[0xC:0x10])
+	nameSize := binary.LittleEndian.Uint32(entry[0x14:0x18])
+	if uint64(nameSize)+0x18 > uint64(len(entry)) {
+		return nil, errors.New("invalid romfs directory name")
+	}
+	dir := &romfsDir{name: string(entry[0x18 : 0x18+nameSize])}
+
+	for fileOffset := childFile; fileOffset != romfsEmptyEntry; {
+		if visitedFiles[fileOffset] {
+			return nil, errors.New("invalid romfs file table (loop)")
+		}
+		visitedFiles[fileOffset] = true
+		if len(visitedFiles) > len(fileTable)/0x20 {
+			return nil, errors.New("invalid romfs file table (too many entries)")
+		}
+		if uint64(fileOffset)+0x20 > uint64(len(fileTable)) {
+			return nil, errors.New("invalid romfs file entry offset")
+		}
+		fileEntry := fileTable[fileOffset:]
+		fileNameSize := binary.LittleEndian.Uint32(fileEntry[0x1C:0x20])
+		if uint64(fileNameSize)+0x20 > uint64(len(fileEntry)) {
+			return nil, errors.New("invalid romfs file name")
+		}
+		dir.files = append(dir.files, &romfsFile{name: string(fileEntry[0x20 : 0x20+fileNameSize]),
+			offset: int64(binary.LittleEndian.Uint64(fileEntry[0x8:0x10])),
+			size:   int64(binary.LittleEndian.Uint64(fileEntry[0x10:0x18]))})
+		fileOffset = binary.LittleEndian.Uint32(fileEntry[0x4:0x8])
+	}
+
+	for dirOffset := childDir; dirOffset != romfsEmptyEntry; {
+		subDir, err := readRomfsDir(dirTable, fileTable, dirOffset, visitedDirs, visitedFiles)
+		if err != nil {
+			return nil, err
+		}
+		dir.dirs = append(dir.dirs, subDir)
+		dirOffset = binary.LittleEndian.Uint32(dirTable[dirOffset+0x4 : dirOffset+0x8])
+	}
+	return dir, nil
+}
+
+// OpenNcaRomFS opens the RomFS of an NCA section in the NSP/XCI file, the section is decrypted on the fly
+func OpenNcaRomFS(filePath string, ncaFileName string, sectionIndex int) (*RomFS, error) {
+	container, err := OpenContainer(filePath)
+	if err != nil {
+		return nil, err
+	}
+	romfs, err := openNcaRomFS(container.NcaPartition(), ncaFileName, sectionIndex)
+	if err != nil {
+		container.Close()
+		return nil, err
+	}
+	romfs.closer = container
+	return romfs, nil
+}
+
+func openNcaRomFS(partition *Partition, ncaFileName string, sectionIndex int) (*RomFS, error) {
+	nca, err := partition.OpenNca(ncaFileName)
+	if err != nil {
+		return nil, err
+	}
+	section, err := nca.Section(sectionIndex)
+	if err != nil {
+		return nil, err
+	}
+	return openSectionRomFS(section.reader, section.fsHeader)
+}
 
-	//fmt.Println(string(section[DataOffset+offset+0x3060:DataOffset+offset+0x3060 +0x10]))
+func openSectionRomFS(section *sectionReader, fsHeader *fsHeader) (*RomFS, error) {
+	if fsHeader.fsType != 0 {
+		return nil, errors.New("section is not a RomFS")
+	}
+	if fsHeader.encType == FsEncryptionType_AesCtrEx {
+		return nil, errors.New("patch (BKTR) RomFS is not supported")
+	}
+	hashInfo, err := fsHeader.getHashInfo()
+	if err != nil {
+		return nil, err
+	}
+	return OpenRomFS(io.NewSectionReader(section, int64(hashInfo.pfs0HeaderOffset), int64(hashInfo.pfs0size)))
+}
+
+// Close closes the underlying file (if the RomFS was opened from a file)
+func (r *RomFS) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
+
+func (r *RomFS) lookup(name string) (*romfsDir, *romfsFile, error) {
+	if !fs.ValidPath(name) {
+		return nil, nil, fs.ErrInvalid
+	}
+	dir := r.root
+	if name == "." {
+		return dir, nil, nil
+	}
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		var next *romfsDir
+		for _, subDir := range dir.dirs {
+			if subDir.name == part {
+				next = subDir
+				break
+			}
+		}
+		if next != nil {
+			dir = next
+			continue
+		}
+		if i == len(parts)-1 {
+			for _, file := range dir.files {
+				if file.name == part {
+					return nil, file, nil
+				}
+			}
+		}
+		return nil, nil, fs.ErrNotExist
+	}
+	return dir, nil, nil
+}
+
+func (r *RomFS) Open(name string) (fs.File, error) {
+	dir, file, err := r.lookup(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if file != nil {
+		return &fileHandle{SectionReader: io.NewSectionReader(r.reader, int64(r.header.DataOffset)+file.offset, file.size),
+			info: fileInfo{name: file.name, size: file.size}}, nil
+	}
+	return newDirHandle(path.Base(name), dir.entries()), nil
+}
+
+func (r *RomFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	dir, _, err := r.lookup(name)
+	if err == nil && dir == nil {
+		err = errors.New("not a directory")
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return dir.entries(), nil
+}
+
+func (r *RomFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return file.Stat()
+}
+
+func (d *romfsDir) entries() []fs.DirEntry {
+	var result []fs.DirEntry
+	for _, subDir := range d.dirs {
+		result = append(result, fs.FileInfoToDirEntry(fileInfo{name: subDir.name, isDir: true}))
+	}
+	for _, file := range d.files {
+		result = append(result, fs.FileInfoToDirEntry(fileInfo{name: file.name, size: file.size}))
+	}
+	return sortDirEntries(result)
+}
+
+// ExtractFiles writes the files under root (recursively) to the destination folder
+func ExtractFiles(fsys fs.FS, root string, destFolder string) error {
+	return fs.WalkDir(fsys, root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath := name
+		if root != "." {
+			relativePath = strings.TrimPrefix(name, root)
+		}
+		target := filepath.Join(destFolder, filepath.FromSlash(relativePath))
+		if entry.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+		return extractFile(fsys, name, target)
+	})
+}
+
+func extractFile(fsys fs.FS, name string, target string) error {
+	source, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	err = os.MkdirAll(filepath.Dir(target), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	output, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(output, source)
+	closeErr := output.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
 }
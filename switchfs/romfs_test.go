@@ -0,0 +1,80 @@
+package switchfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+// romfsEntry builds a directory (0x18) or file (0x20) table entry, the name is padded to 4 bytes
+func romfsEntry(fields []uint64, sizes []int, name string) []byte {
+	var entry []byte
+	for i, field := range fields {
+		value := make([]byte, 8)
+		binary.LittleEndian.PutUint64(value, field)
+		entry = append(entry, value[:sizes[i]]...)
+	}
+	nameSize := make([]byte, 4)
+	binary.LittleEndian.PutUint32(nameSize, uint32(len(name)))
+	entry = append(entry, nameSize...)
+	return append(entry, []byte(name+strings.Repeat("\x00", (4-len(name)%4)%4))...)
+}
+
+func romfsDirEntry(sibling, childDir, childFile uint32, name string) []byte {
+	return romfsEntry([]uint64{0, uint64(sibling), uint64(childDir), uint64(childFile), romfsEmptyEntry},
+		[]int{4, 4, 4, 4, 4}, name)
+}
+
+func romfsFileEntry(sibling uint32, offset, size uint64, name string) []byte {
+	return romfsEntry([]uint64{0, uint64(sibling), offset, size, romfsEmptyEntry}, []int{4, 4, 8, 8, 4}, name)
+}
+
+func romfsImage(dirTable, fileTable []byte, data []byte) []byte {
+	header := make([]byte, romfsHeaderSize)
+	dirTableOffset := uint64(romfsHeaderSize)
+	fileTableOffset := dirTableOffset + uint64(len(dirTable))
+	dataOffset := fileTableOffset + uint64(len(fileTable))
+	for i, value := range []uint64{romfsHeaderSize, dirTableOffset, 0, dirTableOffset, uint64(len(dirTable)),
+		fileTableOffset, 0, fileTableOffset, uint64(len(fileTable)), dataOffset} {
+		binary.LittleEndian.PutUint64(header[i*8:], value)
+	}
+	return append(append(append(header, dirTable...), fileTable...), data...)
+}
+
+func TestOpenRomFS(t *testing.T) {
+	dirTable := append(romfsDirEntry(romfsEmptyEntry, 0x18, 0x0, ""), romfsDirEntry(romfsEmptyEntry, romfsEmptyEntry, 0x24, "dir")...)
+	fileTable := append(romfsFileEntry(romfsEmptyEntry, 0, 5, "a"), romfsFileEntry(romfsEmptyEntry, 5, 3, "b")...)
+	romfs, err := OpenRomFS(bytes.NewReader(romfsImage(dirTable, fileTable, []byte("hellobye"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := fs.ReadFile(romfs, "dir/b")
+	if err != nil || string(data) != "bye" {
+		t.Fatalf("unexpected dir/b [%s] %v", data, err)
+	}
+}
+
+func TestOpenRomFSLoops(t *testing.T) {
+	tests := []struct {
+		name      string
+		dirTable  []byte
+		fileTable []byte
+	}{
+		{"file sibling loop", romfsDirEntry(romfsEmptyEntry, romfsEmptyEntry, 0x0, ""),
+			append(romfsFileEntry(0x24, 0, 0, "a"), romfsFileEntry(0x0, 0, 0, "b")...)},
+		{"file shared by two directories", append(append(romfsDirEntry(romfsEmptyEntry, 0x18, romfsEmptyEntry, ""),
+			romfsDirEntry(0x34, romfsEmptyEntry, 0x0, "d1")...), romfsDirEntry(romfsEmptyEntry, romfsEmptyEntry, 0x0, "d2")...),
+			romfsFileEntry(romfsEmptyEntry, 0, 0, "a")},
+		{"directory sibling loop", append(append(romfsDirEntry(romfsEmptyEntry, 0x18, 0x0, ""),
+			romfsDirEntry(0x34, romfsEmptyEntry, romfsEmptyEntry, "d1")...), romfsDirEntry(0x18, romfsEmptyEntry, romfsEmptyEntry, "d2")...),
+			romfsFileEntry(romfsEmptyEntry, 0, 0, "a")},
+	}
+	for _, test := range tests {
+		_, err := OpenRomFS(bytes.NewReader(romfsImage(test.dirTable, test.fileTable, nil)))
+		if err == nil || !strings.Contains(err.Error(), "loop") {
+			t.Fatalf("%v: expected a loop error, got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package switchfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// sectionReader exposes the decrypted content of an NCA section, without reading the whole section to memory
+type sectionReader struct {
+	reader  io.ReaderAt //the NCA
+	start   int64       //section offset in the NCA
+	size    int64
+	encType byte
+	key     []byte
+	counter []byte
+}
+
+// openNcaSection returns a reader for the decrypted section, or nil if the section doesn't exist
+func openNcaSection(nca io.ReaderAt, ncaHeader *ncaHeader, index int, titleKey []byte) (*sectionReader, *fsHeader, error) {
+	entry := getFsEntry(ncaHeader, index)
+	if entry.Size == 0 {
+		return nil, nil, nil
+	}
+	fsHeader, err := getFsHeader(ncaHeader, index)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &sectionReader{reader: nca, start: int64(entry.StartOffset), size: int64(entry.Size), encType: fsHeader.encType}
+	switch fsHeader.encType {
+	case FsEncryptionType_None:
+	case FsEncryptionType_AesCtr, FsEncryptionType_AesCtrEx:
+		result.key, err = getSectionKey(ncaHeader, titleKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		result.counter = getSectionCounter(fsHeader)
+	default:
+		return nil, nil, fmt.Errorf("non supported encryption type [encryption type:%v]", fsHeader.encType)
+	}
+	return result, fsHeader, nil
+}
+
+// openNcaSections decrypts the NCA header, and returns the readers of all the NCA sections (nil for missing sections)
+func openNcaSections(nca io.ReaderAt, titleKeys map[string][]byte) (*ncaHeader, []*sectionReader, []*fsHeader, error) {
+	ncaHeader, err := readNcaHeader(nca, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var titleKey []byte
+	if ncaHeader.HasRightsId() {
+		titleKey, err = getTitleKey(ncaHeader, titleKeys)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	sections := make([]*sectionReader, 4)
+	fsHeaders := make([]*fsHeader, 4)
+	for i := 0; i < 4; i++ {
+		sections[i], fsHeaders[i], err = openNcaSection(nca, ncaHeader, i, titleKey)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to open section %v - %w", i, err)
+		}
+	}
+	return ncaHeader, sections, fsHeaders, nil
+}
+
+func (s *sectionReader) Size() int64 {
+	return s.size
+}
+
+func (s *sectionReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	length := len(p)
+	if off+int64(length) > s.size {
+		length = int(s.size - off)
+	}
+	n, err := s.reader.ReadAt(p[:length], s.start+off)
+	if s.key != nil && n > 0 {
+		stream := newAesCtrStream(s.key, s.counter, s.start+off)
+		stream.XORKeyStream(p[:n], p[:n])
+	}
+	if err == nil && length < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,199 @@
+package switchfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	SplitFileChunkSize = 0xFFFFFFFF //4 GiB - 1, the maximum file size on FAT32
+)
+
+// split file layouts
+const (
+	SplitLayout_Folder = iota //name.nsp/00, name.nsp/01 ...
+	SplitLayout_Flat          //name.nsp.00, name.nsp.01 ...
+)
+
+// SplitFileParts is the validated list of parts of a split file
+type SplitFileParts struct {
+	Layout    int
+	Name      string //the name of the joined file (name.nsp)
+	Folder    string //the folder holding the parts
+	Parts     []string
+	PartSizes []int64
+	Size      int64
+}
+
+// IsSplitFileName returns true for the name of a part (00 / name.nsp.00)
+func IsSplitFileName(fileName string) bool {
+	_, _, ok := parseSplitFileName(fileName)
+	return ok
+}
+
+// GetSplitPartNumber returns the part number of a part name (00 / name.nsp.00), false if it's not a part
+func GetSplitPartNumber(fileName string) (int, bool) {
+	partNum, _, ok := parseSplitFileName(fileName)
+	return partNum, ok
+}
+
+// parseSplitFileName returns the part number, and the prefix shared by all the parts
+// (empty for the folder layout, "name.nsp." for the flat layout)
+func parseSplitFileName(fileName string) (int, string, bool) {
+	index := strings.LastIndex(fileName, ".")
+	suffix := fileName[index+1:]
+	if len(suffix) < 2 {
+		return 0, "", false
+	}
+	for _, c := range suffix {
+		if c < '0' || c > '9' {
+			return 0, "", false
+		}
+	}
+	partNum, err := strconv.Atoi(suffix)
+	if err != nil {
+		return 0, "", false
+	}
+	return partNum, fileName[:index+1], true
+}
+
+// GetSplitFileParts returns the parts of the split file (filePath is the path of any part), the parts are validated:
+// the part numbers must be consecutive, all the parts (except the last) must have the same size, and the folder
+// of the folder layout must not hold other files
+func GetSplitFileParts(filePath string) (*SplitFileParts, error) {
+	folder, fileName := filepath.Split(filePath)
+	_, prefix, ok := parseSplitFileName(fileName)
+	if !ok {
+		return nil, errors.New("[" + fileName + "] is not a split file")
+	}
+	result := &SplitFileParts{Folder: folder, Layout: SplitLayout_Flat, Name: strings.TrimSuffix(prefix, ".")}
+	if prefix == "" {
+		result.Layout = SplitLayout_Folder
+		result.Name = filepath.Base(folder)
+	}
+
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, err
+	}
+	parts := map[int]os.DirEntry{}
+	for _, entry := range entries {
+		//skip mac hidden files
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if result.Layout == SplitLayout_Flat && !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		partNum, partPrefix, ok := parseSplitFileName(entry.Name())
+		if !ok || partPrefix != prefix || entry.IsDir() {
+			return nil, errors.New("unexpected file [" + entry.Name() + "] next to the split file parts")
+		}
+		if other, ok := parts[partNum]; ok {
+			return nil, fmt.Errorf("duplicate part [%v] and [%v]", other.Name(), entry.Name())
+		}
+		parts[partNum] = entry
+	}
+
+	for i := 0; i < len(parts); i++ {
+		entry, ok := parts[i]
+		if !ok {
+			return nil, fmt.Errorf("part %02d is missing", i)
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		result.Parts = append(result.Parts, filepath.Join(folder, entry.Name()))
+		result.PartSizes = append(result.PartSizes, info.Size())
+		result.Size += info.Size()
+	}
+	if len(result.Parts) == 0 {
+		return nil, errors.New("part 00 is missing")
+	}
+	for i, size := range result.PartSizes {
+		if size == 0 || size > result.PartSizes[0] || (i != len(result.PartSizes)-1 && size != result.PartSizes[0]) {
+			return nil, fmt.Errorf("part %02d has an unexpected size [%v], expected [%v]", i, size, result.PartSizes[0])
+		}
+	}
+	return result, nil
+}
+
+// GetSplitFilePartPath returns the path of the part, filePath is the path of the joined file (name.nsp)
+func GetSplitFilePartPath(filePath string, layout int, partNum int) string {
+	if layout == SplitLayout_Folder {
+		return filepath.Join(filePath, fmt.Sprintf("%02d", partNum))
+	}
+	return fmt.Sprintf("%v.%02d", filePath, partNum)
+}
+
+// WriteSplitFile writes the data to parts of chunkSize bytes (the last part may be smaller), filePath is the path
+// of the joined file (name.nsp) - the folder holding the parts for the folder layout. The parts are written as
+// temporary files, and renamed once all the data was written
+func WriteSplitFile(reader io.Reader, filePath string, layout int, chunkSize int64) ([]string, error) {
+	if chunkSize <= 0 {
+		return nil, errors.New("invalid chunk size")
+	}
+	if _, err := os.Stat(GetSplitFilePartPath(filePath, layout, 0)); err == nil {
+		return nil, errors.New("file [" + GetSplitFilePartPath(filePath, layout, 0) + "] already exists")
+	}
+	if layout == SplitLayout_Folder {
+		if _, err := os.Stat(filePath); err == nil {
+			return nil, errors.New("file [" + filePath + "] already exists")
+		}
+		err := os.Mkdir(filePath, os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var parts []string
+	err := func() error {
+		buffer := make([]byte, partitionCopyBufferSize)
+		for partNum := 0; ; partNum++ {
+			partPath := GetSplitFilePartPath(filePath, layout, partNum)
+			output, err := os.Create(partPath + ".tmp")
+			if err != nil {
+				return err
+			}
+			parts = append(parts, partPath)
+			written, err := io.CopyBuffer(output, io.LimitReader(reader, chunkSize), buffer)
+			closeErr := output.Close()
+			if err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+			if written == 0 && partNum != 0 {
+				//the data ended on the part boundary
+				parts = parts[:len(parts)-1]
+				return os.Remove(partPath + ".tmp")
+			}
+			if written < chunkSize {
+				return nil
+			}
+		}
+	}()
+	for _, part := range parts {
+		if err == nil {
+			err = os.Rename(part+".tmp", part)
+		}
+	}
+	if err != nil {
+		for _, part := range parts {
+			os.Remove(part + ".tmp")
+			os.Remove(part)
+		}
+		if layout == SplitLayout_Folder {
+			os.Remove(filePath)
+		}
+		return nil, err
+	}
+	return parts, nil
+}
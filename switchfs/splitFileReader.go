@@ -4,11 +4,8 @@ import (
 	"errors"
 	"github.com/avast/retry-go"
 	"io"
-	"io/ioutil"
 	"os"
-	"path"
-	"strconv"
-	"strings"
+	"path/filepath"
 )
 
 type ReadAtCloser interface {
@@ -17,9 +14,8 @@ type ReadAtCloser interface {
 }
 
 type splitFile struct {
-	info      []os.FileInfo
+	parts     *SplitFileParts
 	files     []ReadAtCloser
-	path      string
 	chunkSize int64
 }
 
@@ -55,44 +51,62 @@ func (sp *fileWrapper) Close() error {
 	return nil
 }
 
+// NewSplitFileReader opens the parts of the split file (see GetSplitFileParts), filePath is the path of any part
 func NewSplitFileReader(filePath string) (*splitFile, error) {
-	result := splitFile{}
-	index := strings.LastIndex(filePath, string(os.PathSeparator))
-	splitFileFolder := filePath[:index]
-	files, err := ioutil.ReadDir(splitFileFolder)
+	parts, err := GetSplitFileParts(filePath)
 	if err != nil {
 		return nil, err
 	}
-	result.path = splitFileFolder
-	result.chunkSize = files[0].Size()
-	result.info = make([]os.FileInfo, 0, len(files))
-	result.files = make([]ReadAtCloser, len(files))
-	for _, file := range files {
-		if _, err := strconv.Atoi(file.Name()[len(file.Name())-1:]); err == nil {
-			result.info = append(result.info, file)
-		}
-	}
+	result := splitFile{parts: parts, chunkSize: parts.PartSizes[0]}
+	result.files = make([]ReadAtCloser, len(parts.Parts))
 	return &result, nil
 }
 
 func (sp *splitFile) ReadAt(p []byte, off int64) (n int, err error) {
+	//reads crossing the part boundary are split between the parts
+	read := 0
+	for read < len(p) {
+		n, err = sp.readPartAt(p[read:], off+int64(read))
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+func (sp *splitFile) readPartAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("offset is out of bounds")
+	}
 	//calculate the part containing the offset
 	part := int(off / sp.chunkSize)
 
-	if len(sp.info) < part {
-		return 0, errors.New("missing part " + strconv.Itoa(part))
+	if len(sp.parts.Parts) <= part {
+		return 0, io.EOF
 	}
 
-	if len(sp.files) == 0 || sp.files[part] == nil {
-		file, _ := _openFile(path.Join(sp.path, sp.info[part].Name()))
+	if sp.files[part] == nil {
+		file, err := _openFile(sp.parts.Parts[part])
+		if err != nil {
+			return 0, err
+		}
 		sp.files[part] = file
 	}
 	off = off - sp.chunkSize*int64(part)
 
-	if off < 0 || off > sp.info[part].Size() {
-		return 0, errors.New("offset is out of bounds")
+	partSize := sp.parts.PartSizes[part]
+	if off >= partSize {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > partSize-off {
+		p = p[:partSize-off]
 	}
-	return sp.files[part].ReadAt(p, off)
+	n, err = sp.files[part].ReadAt(p, off)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	return n, err
 }
 
 func _openFile(path string) (*os.File, error) {
@@ -119,9 +133,14 @@ func (sp *splitFile) Close() error {
 
 func OpenFile(filePath string) (ReadAtCloser, error) {
 	//check if it's a split file
-	if _, err := strconv.Atoi(filePath[len(filePath)-1:]); err == nil {
+	if IsSplitFileName(filepath.Base(filePath)) {
 		return NewSplitFileReader(filePath)
 	} else {
 		return NewFileWrapper(filePath)
 	}
 }
+
+// Size returns the size of the joined file
+func (sp *splitFile) Size() int64 {
+	return sp.parts.Size
+}
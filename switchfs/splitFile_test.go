@@ -0,0 +1,91 @@
+package switchfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSplitFile(t *testing.T) {
+	data := syntheticNca(0x2345, 0x1)
+	for _, layout := range []int{SplitLayout_Folder, SplitLayout_Flat} {
+		for _, size := range []int{0x1000, 0x2345} {
+			folder := t.TempDir()
+			filePath := filepath.Join(folder, "name.nsp")
+			parts, err := WriteSplitFile(bytes.NewReader(data[:size]), filePath, layout, 0x1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parts) != (size+0xFFF)/0x1000 {
+				t.Fatalf("expected %v parts, got %v", (size+0xFFF)/0x1000, len(parts))
+			}
+			file, err := OpenFile(parts[len(parts)-1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			splitFile := file.(*splitFile)
+			if splitFile.Size() != int64(size) || splitFile.parts.Name != "name.nsp" || splitFile.parts.Layout != layout {
+				t.Fatalf("unexpected split file %v [%v]", splitFile.parts.Name, splitFile.Size())
+			}
+			result := make([]byte, size)
+			_, err = io.ReadFull(io.NewSectionReader(file, 0, splitFile.Size()), result)
+			file.Close()
+			if err != nil || !bytes.Equal(result, data[:size]) {
+				t.Fatalf("data mismatch %v", err)
+			}
+		}
+	}
+}
+
+func TestGetSplitFilePartsValidation(t *testing.T) {
+	invalid := map[string]map[string]int{
+		"gap":        {"name.nsp.00": 0x10, "name.nsp.02": 0x10},
+		"size":       {"name.nsp.00": 0x10, "name.nsp.01": 0x8, "name.nsp.02": 0x10},
+		"last size":  {"name.nsp.00": 0x10, "name.nsp.01": 0x20},
+		"stray":      {"name.nsp.00": 0x10, "name.nsp.01": 0x8, "name.nsp.01.tmp": 0x8},
+		"duplicate":  {"name.nsp.00": 0x10, "name.nsp.01": 0x8, "name.nsp.001": 0x8},
+		"empty part": {"name.nsp.00": 0x10, "name.nsp.01": 0x0},
+	}
+	for name, files := range invalid {
+		folder := t.TempDir()
+		for fileName, size := range files {
+			os.WriteFile(filepath.Join(folder, fileName), make([]byte, size), 0644)
+		}
+		if _, err := GetSplitFileParts(filepath.Join(folder, "name.nsp.00")); err == nil {
+			t.Fatalf("expected an error [%v]", name)
+		}
+	}
+
+	folder := t.TempDir()
+	os.Mkdir(filepath.Join(folder, "name.nsp"), os.ModePerm)
+	os.WriteFile(filepath.Join(folder, "name.nsp", "00"), make([]byte, 0x10), 0644)
+	os.WriteFile(filepath.Join(folder, "name.nsp", "readme.txt"), make([]byte, 0x10), 0644)
+	if _, err := GetSplitFileParts(filepath.Join(folder, "name.nsp", "00")); err == nil {
+		t.Fatal("expected an error for a stray file in the parts folder")
+	}
+
+	//files of other split files in the same folder are ignored
+	folder = t.TempDir()
+	os.WriteFile(filepath.Join(folder, "name.nsp.00"), make([]byte, 0x10), 0644)
+	os.WriteFile(filepath.Join(folder, "name.nsp.01"), make([]byte, 0x4), 0644)
+	os.WriteFile(filepath.Join(folder, "other.nsp.00"), make([]byte, 0x8), 0644)
+	parts, err := GetSplitFileParts(filepath.Join(folder, "name.nsp.00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts.Parts) != 2 || parts.Size != 0x14 {
+		t.Fatalf("unexpected parts %v", parts.Parts)
+	}
+}
+
+func TestGetSplitPartNumber(t *testing.T) {
+	for name, expected := range map[string]int{"00": 0, "01": 1, "name.nsp.00": 0, "name.nsp.12": 12,
+		"xyz00": -1, "0": -1, "a": -1, "name.nsp": -1, "name.nsp.0a": -1} {
+		partNum, ok := GetSplitPartNumber(name)
+		if (expected < 0 && ok) || (expected >= 0 && (!ok || partNum != expected)) {
+			t.Fatalf("unexpected part number for [%v]: %v %v", name, partNum, ok)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package switchfs
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/giwty/switch-library-manager/settings"
+	"github.com/giwty/switch-library-manager/switchfs/_crypto"
+	"go.uber.org/zap"
+	"io"
+	"strings"
+)
+
+//https://switchbrew.org/wiki/Ticket
+
+const (
+	TitleKeyType_Common       = 0
+	TitleKeyType_Personalized = 1
+)
+
+type ticket struct {
+	signatureType     uint32
+	issuer            string
+	titleKeyBlock     []byte
+	titleKeyType      byte
+	masterKeyRevision byte
+	rightsId          []byte
+}
+
+func getSignatureSize(signatureType uint32) (int, error) {
+	//signature size + padding to 0x40 alignment
+	switch signatureType {
+	case 0x10000, 0x10003: //RSA_4096
+		return 0x200 + 0x3C, nil
+	case 0x10001, 0x10004: //RSA_2048
+		return 0x100 + 0x3C, nil
+	case 0x10002, 0x10005: //ECDSA
+		return 0x3C + 0x40, nil
+	case 0x10006: //HMAC
+		return 0x14 + 0x28, nil
+	}
+	return 0, fmt.Errorf("unknown ticket signature type [%x]", signatureType)
+}
+
+func readTicket(reader io.ReaderAt, offset int64, size int64) (*ticket, error) {
+	data := make([]byte, size)
+	_, err := reader.ReadAt(data, offset)
+	if err != nil {
+		return nil, errors.New("failed to read ticket " + err.Error())
+	}
+	if len(data) < 0x4 {
+		return nil, errors.New("invalid ticket")
+	}
+	signatureType := binary.LittleEndian.Uint32(data[0x0:0x4])
+	signatureSize, err := getSignatureSize(signatureType)
+	if err != nil {
+		return nil, err
+	}
+	dataOffset := 0x4 + signatureSize
+	if len(data) < dataOffset+0x180 {
+		return nil, errors.New("invalid ticket size")
+	}
+	ticketData := data[dataOffset:]
+
+	result := ticket{signatureType: signatureType}
+	result.issuer = string(readBytesUntilZero(ticketData[0x0:0x40]))
+	result.titleKeyBlock = ticketData[0x40:0x140]
+	result.titleKeyType = ticketData[0x141:0x142][0]
+	result.masterKeyRevision = ticketData[0x145:0x146][0]
+	result.rightsId = ticketData[0x160:0x170]
+	return &result, nil
+}
+
+// readTitleKeys collects the encrypted title keys from all the tickets (.tik) found in the partition,
+// keyed by the rights id (hex)
+func readTitleKeys(reader io.ReaderAt, pfs0 *PFS0, pfs0Offset int64) map[string][]byte {
+	result := map[string][]byte{}
+	if pfs0 == nil {
+		return result
+	}
+	for _, pfs0File := range pfs0.Files {
+		if !strings.HasSuffix(strings.ToLower(pfs0File.Name), ".tik") {
+			continue
+		}
+		tik, err := readTicket(reader, pfs0Offset+int64(pfs0File.StartOffset), int64(pfs0File.Size))
+		if err != nil {
+			zap.S().Debugf("failed to read ticket [%v] - %v", pfs0File.Name, err)
+			continue
+		}
+		if tik.titleKeyType != TitleKeyType_Common {
+			zap.S().Debugf("skipping personalized ticket [%v]", pfs0File.Name)
+			continue
+		}
+		result[hex.EncodeToString(tik.rightsId)] = tik.titleKeyBlock[0x0:0x10]
+	}
+	return result
+}
+
+// ReadTitleKeys returns the (encrypted) title keys of all the common tickets found in the NSP/XCI file,
+// keyed by the rights id (hex)
+func ReadTitleKeys(filePath string) (map[string]string, error) {
+	file, err := OpenFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	partition, partitionOffset, err := openPartition(file)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]string{}
+	for rightsId, titleKey := range readTitleKeys(file, partition, partitionOffset) {
+		result[rightsId] = hex.EncodeToString(titleKey)
+	}
+	return result, nil
+}
+
+func getTitleKey(ncaHeader *ncaHeader, titleKeys map[string][]byte) ([]byte, error) {
+	rightsId := hex.EncodeToString(ncaHeader.rightsId)
+	encTitleKey, ok := titleKeys[rightsId]
+	if !ok {
+		//fallback to the title keys from title.keys, or from tickets found in other files
+		keys, _ := settings.SwitchKeys()
+		if keys != nil && keys.GetTitleKey(rightsId) != "" {
+			encTitleKey, _ = hex.DecodeString(keys.GetTitleKey(rightsId))
+		}
+	}
+	if len(encTitleKey) != 0x10 {
+		return nil, errors.New("missing title key for rights id [" + rightsId + "]")
+	}
+	key, err := getKey(fmt.Sprintf("titlekek_%02x", ncaHeader.getKeyRevision()))
+	if err != nil {
+		return nil, err
+	}
+	return _crypto.DecryptAes128Ecb(encTitleKey, key), nil
+}
@@ -0,0 +1,71 @@
+package switchfs
+
+import (
+	"fmt"
+	"io"
+)
+
+// MergeSource is a title (base, update or DLC) to include in a merged NSP/XCI, and the file holding it
+type MergeSource struct {
+	Container *Container
+	TitleId   string
+	Version   int
+}
+
+// MergeToNsp lays out a multi-content NSP holding the given titles, with their tickets and cnmt.xml
+// (NCZ files are decompressed, and gamecard NCAs are switched to download)
+func MergeToNsp(sources []MergeSource) (*io.SectionReader, error) {
+	files, err := getMergedFiles(sources, nspTarget)
+	if err != nil {
+		return nil, err
+	}
+	return NewPfs0Reader(files)
+}
+
+// MergeToXci lays out an XCI holding the given titles in the secure partition, the NCAs are switched to the
+// gamecard distribution type, and titlekey crypto is replaced with the key area (gamecards don't hold tickets).
+// The patched NCAs are hashed when the XCI is laid out (the HFS0 header holds the hash of the cnmt NCA header),
+// and read again when the XCI is written
+func MergeToXci(sources []MergeSource) (*io.SectionReader, error) {
+	files, err := getMergedFiles(sources, xciTarget)
+	if err != nil {
+		return nil, err
+	}
+	return NewXciReader(files)
+}
+
+func getMergedFiles(sources []MergeSource, target ncaTarget) ([]PartitionFile, error) {
+	var result []PartitionFile
+	names := map[string]bool{}
+	for _, source := range sources {
+		partition := source.Container.NcaPartition()
+		found := false
+		for _, name := range partition.FileNames() {
+			if !isCnmtNca(name) {
+				continue
+			}
+			metadata, files, err := convertTitle(partition, name, target, func(metadata *ContentMetaAttributes) bool {
+				return metadata.TitleId == source.TitleId && metadata.Version == source.Version
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to read [%v] - %v", name, err)
+			}
+			if metadata == nil {
+				continue
+			}
+			found = true
+			for _, file := range files {
+				//NCAs and certificates may be shared by titles
+				if !names[file.Name] {
+					names[file.Name] = true
+					result = append(result, file)
+				}
+			}
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("title [%v] v%v was not found", source.TitleId, source.Version)
+		}
+	}
+	return result, nil
+}
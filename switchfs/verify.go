@@ -0,0 +1,99 @@
+package switchfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+// NcaHashResult is the result of comparing an NCA to the content record in the cnmt
+type NcaHashResult struct {
+	TitleId      string
+	ContentType  string
+	FileName     string
+	ExpectedHash string
+	ActualHash   string
+	Missing      bool
+}
+
+func (r NcaHashResult) IsValid() bool {
+	return !r.Missing && r.ExpectedHash == r.ActualHash
+}
+
+// VerifyNcaHashes computes the SHA-256 of every NCA (NCZ files are decompressed) listed in the cnmt
+// of the NSP/XCI file, and compares it to the hash of the content record
+func VerifyNcaHashes(filePath string) ([]NcaHashResult, error) {
+	container, err := OpenContainer(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer container.Close()
+	return verifyNcaHashes(container.NcaPartition())
+}
+
+func verifyNcaHashes(partition *Partition) ([]NcaHashResult, error) {
+	var result []NcaHashResult
+	foundCnmt := false
+	for _, name := range partition.FileNames() {
+		if !isCnmtNca(name) {
+			continue
+		}
+		foundCnmt = true
+		cnmtNca, err := partition.OpenNca(name)
+		if err != nil {
+			return nil, err
+		}
+		cnmtData, err := readCnmtFile(cnmtNca)
+		if err != nil {
+			return nil, err
+		}
+		cnmt, err := readBinaryCnmt(cnmtData)
+		if err != nil {
+			return nil, err
+		}
+		for _, content := range readCnmtContents(cnmtData) {
+			ncaResult := NcaHashResult{TitleId: cnmt.TitleId, ContentType: content.Type, ExpectedHash: content.Hash}
+			ncaFile := getNcaById(partition.pfs0, content.ID)
+			if ncaFile == nil {
+				//delta fragments are not required for installation, and are usually removed
+				if content.Type == "DeltaFragment" {
+					continue
+				}
+				ncaResult.FileName = content.ID + ".nca"
+				ncaResult.Missing = true
+				result = append(result, ncaResult)
+				continue
+			}
+			ncaResult.FileName = ncaFile.Name
+			ncaResult.ActualHash, err = hashNca(partition.reader, partition.offset, ncaFile)
+			if err != nil {
+				return nil, errors.New("failed to read [" + ncaFile.Name + "] - " + err.Error())
+			}
+			result = append(result, ncaResult)
+		}
+	}
+	if !foundCnmt {
+		return nil, errors.New("cnmt was not found")
+	}
+	return result, nil
+}
+
+func hashNca(file io.ReaderAt, partitionOffset int64, entry *fileEntry) (string, error) {
+	offset := partitionOffset + int64(entry.StartOffset)
+	var reader io.Reader = io.NewSectionReader(file, offset, int64(entry.Size))
+	if isNcz(entry.Name) {
+		nczReader, err := NewNczReader(file, offset, int64(entry.Size))
+		if err != nil {
+			return "", err
+		}
+		defer nczReader.Close()
+		reader = io.NewSectionReader(nczReader, 0, nczReader.Size())
+	}
+	hash := sha256.New()
+	_, err := io.CopyBuffer(hash, reader, make([]byte, 0x400000))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,471 @@
+package switchfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The NSP/XCI files are exposed as a layered read only filesystem (io/fs):
+// container -> partition -> NCA -> section -> PFS0/RomFS files
+// for example fs.ReadFile(container, "secure/<nca id>.nca/section0/control.nacp") on an XCI,
+// the NSP has a single partition, so its files are at the root of the container ("<nca id>.nca/section0/control.nacp")
+
+// Container is an NSP/NSZ or XCI/XCZ file
+type Container struct {
+	sync.Mutex
+	reader    io.ReaderAt
+	closer    io.Closer
+	isXci     bool
+	root      *Partition
+	partition *Partition //the partition holding the NCAs
+	titleKeys map[string][]byte
+	ncas      map[string]*Nca
+}
+
+// Partition is a PFS0/HFS0 partition, the NCAs it holds are exposed as directories,
+// and so are the partitions of the XCI root partition
+type Partition struct {
+	container *Container
+	name      string
+	reader    io.ReaderAt
+	offset    int64
+	pfs0      *PFS0
+	isXciRoot bool
+}
+
+// Nca is a (decrypted) NCA, the sections are exposed as directories named section0 - section3
+type Nca struct {
+	name     string
+	reader   io.ReaderAt //NCZ files are decompressed
+	size     int64
+	closer   io.Closer
+	header   *ncaHeader
+	sections []*NcaSection
+}
+
+// NcaSection is a decrypted NCA section, the files of its PFS0 or RomFS are exposed at its root
+type NcaSection struct {
+	sync.Mutex
+	name     string
+	index    int
+	reader   *sectionReader
+	fsHeader *fsHeader
+	files    fs.FS
+}
+
+// OpenContainer opens the NSP/NSZ or XCI/XCZ file (split files are supported)
+func OpenContainer(filePath string) (*Container, error) {
+	file, err := OpenFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	container, err := NewContainer(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	container.closer = file
+	return container, nil
+}
+
+// NewContainer reads the partitions of the NSP/XCI, the title keys are read from the tickets in the NCA partition
+func NewContainer(reader io.ReaderAt) (*Container, error) {
+	container := &Container{reader: reader, ncas: map[string]*Nca{}}
+	header := make([]byte, 0x200)
+	_, err := reader.ReadAt(header, 0)
+	if err != nil {
+		return nil, err
+	}
+	if string(header[0x100:0x104]) == "HEAD" {
+		container.isXci = true
+		rootPartitionOffset := int64(binary.LittleEndian.Uint64(header[0x130:0x138]))
+		container.root, err = newPartition(container, ".", reader, rootPartitionOffset)
+		if err != nil {
+			return nil, err
+		}
+		container.root.isXciRoot = true
+		container.partition, err = container.Partition("secure")
+	} else {
+		container.root, err = newPartition(container, ".", reader, 0)
+		container.partition = container.root
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file is not an NSP/NSZ or XCI/XCZ - %w", err)
+	}
+	container.titleKeys = readTitleKeys(reader, container.partition.pfs0, container.partition.offset)
+	return container, nil
+}
+
+func newPartition(container *Container, name string, reader io.ReaderAt, offset int64) (*Partition, error) {
+	pfs0, err := readPfs0(reader, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &Partition{container: container, name: name, reader: reader, offset: offset, pfs0: pfs0}, nil
+}
+
+func (c *Container) IsXci() bool {
+	return c.isXci
+}
+
+// Partition returns the XCI partition by name (update, normal, secure, logo), the NSP has a single partition (".")
+func (c *Container) Partition(name string) (*Partition, error) {
+	if name == "." {
+		return c.root, nil
+	}
+	if !c.isXci {
+		return nil, fmt.Errorf("partition [%v] doesn't exist", name)
+	}
+	entry := c.root.getEntry(name)
+	if entry == nil {
+		return nil, fmt.Errorf("partition [%v] doesn't exist", name)
+	}
+	return newPartition(c, name, c.reader, c.root.offset+int64(entry.StartOffset))
+}
+
+// NcaPartition returns the partition holding the NCAs - the NSP partition, or the XCI secure partition
+func (c *Container) NcaPartition() *Partition {
+	return c.partition
+}
+
+func (c *Container) Open(name string) (fs.File, error) {
+	return c.root.Open(name)
+}
+
+// Close closes the NCZ readers opened by the container, and the file
+func (c *Container) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	for key, nca := range c.ncas {
+		if nca.closer != nil {
+			nca.closer.Close()
+		}
+		delete(c.ncas, key)
+	}
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
+
+func (p *Partition) Name() string {
+	return p.name
+}
+
+// FileNames returns the names of the partition files
+func (p *Partition) FileNames() []string {
+	var result []string
+	for _, entry := range p.pfs0.Files {
+		result = append(result, entry.Name)
+	}
+	return result
+}
+
+func (p *Partition) getEntry(name string) *fileEntry {
+	for i := range p.pfs0.Files {
+		if p.pfs0.Files[i].Name == name {
+			return &p.pfs0.Files[i]
+		}
+	}
+	return nil
+}
+
+// OpenFile returns a reader of the (raw) partition file
+func (p *Partition) OpenFile(name string) (*io.SectionReader, error) {
+	entry := p.getEntry(name)
+	if entry == nil {
+		return nil, fmt.Errorf("[%v] was not found", name)
+	}
+	return io.NewSectionReader(p.reader, p.offset+int64(entry.StartOffset), int64(entry.Size)), nil
+}
+
+// FindNca returns the NCA (or NCZ) by id
+func (p *Partition) FindNca(ncaId string) (*Nca, error) {
+	entry := getNcaById(p.pfs0, ncaId)
+	if entry == nil {
+		return nil, fmt.Errorf("NCA [%v] was not found", ncaId)
+	}
+	return p.OpenNca(entry.Name)
+}
+
+// OpenNca decrypts the header of the NCA (or NCZ) file, the NCA is cached by the container until it's closed
+func (p *Partition) OpenNca(name string) (*Nca, error) {
+	if p.container == nil || !isNcaFile(name) {
+		return nil, fmt.Errorf("[%v] is not an NCA", name)
+	}
+	entry := p.getEntry(name)
+	if entry == nil {
+		return nil, fmt.Errorf("[%v] was not found", name)
+	}
+	c := p.container
+	c.Lock()
+	defer c.Unlock()
+	key := p.name + "/" + name
+	if nca, ok := c.ncas[key]; ok {
+		return nca, nil
+	}
+	nca, err := openNca(name, p.reader, p.offset+int64(entry.StartOffset), int64(entry.Size), c.titleKeys)
+	if err != nil {
+		return nil, err
+	}
+	c.ncas[key] = nca
+	return nca, nil
+}
+
+func (p *Partition) isDir(entry *fileEntry) bool {
+	return p.isXciRoot || (p.container != nil && isNcaFile(entry.Name))
+}
+
+func (p *Partition) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		var entries []fs.DirEntry
+		for i := range p.pfs0.Files {
+			entry := &p.pfs0.Files[i]
+			entries = append(entries, fs.FileInfoToDirEntry(fileInfo{name: entry.Name, size: int64(entry.Size), isDir: p.isDir(entry)}))
+		}
+		return newDirHandle(p.name, entries), nil
+	}
+	first, rest := splitPath(name)
+	entry := p.getEntry(first)
+	if entry == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if p.isXciRoot {
+		partition, err := p.container.Partition(first)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return openSubPath(partition, name, rest)
+	}
+	if p.isDir(entry) {
+		nca, err := p.OpenNca(first)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return openSubPath(nca, name, rest)
+	}
+	if rest != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &fileHandle{SectionReader: io.NewSectionReader(p.reader, p.offset+int64(entry.StartOffset), int64(entry.Size)),
+		info: fileInfo{name: entry.Name, size: int64(entry.Size)}}, nil
+}
+
+func openNca(name string, reader io.ReaderAt, offset int64, size int64, titleKeys map[string][]byte) (*Nca, error) {
+	nca := &Nca{name: name, reader: io.NewSectionReader(reader, offset, size), size: size}
+	if isNcz(name) {
+		nczReader, err := NewNczReader(reader, offset, size)
+		if err != nil {
+			return nil, err
+		}
+		nca.reader = nczReader
+		nca.size = nczReader.Size()
+		nca.closer = nczReader
+	}
+	header, sections, fsHeaders, err := openNcaSections(nca.reader, titleKeys)
+	if err != nil {
+		if nca.closer != nil {
+			nca.closer.Close()
+		}
+		return nil, err
+	}
+	nca.header = header
+	for i, section := range sections {
+		if section == nil {
+			continue
+		}
+		nca.sections = append(nca.sections, &NcaSection{name: fmt.Sprintf("section%v", i), index: i, reader: section, fsHeader: fsHeaders[i]})
+	}
+	return nca, nil
+}
+
+func (n *Nca) Name() string {
+	return n.name
+}
+
+// Size returns the size of the NCA (the decompressed size for NCZ files)
+func (n *Nca) Size() int64 {
+	return n.size
+}
+
+// ReadAt reads the raw (encrypted) NCA data, NCZ files are decompressed
+func (n *Nca) ReadAt(p []byte, off int64) (int, error) {
+	return n.reader.ReadAt(p, off)
+}
+
+// Section returns the section by index (0-3)
+func (n *Nca) Section(index int) (*NcaSection, error) {
+	for _, section := range n.sections {
+		if section.index == index {
+			return section, nil
+		}
+	}
+	return nil, fmt.Errorf("section [%v] doesn't exist", index)
+}
+
+func (n *Nca) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		var entries []fs.DirEntry
+		for _, section := range n.sections {
+			entries = append(entries, fs.FileInfoToDirEntry(fileInfo{name: section.name, size: section.reader.Size(), isDir: true}))
+		}
+		return newDirHandle(n.name, entries), nil
+	}
+	first, rest := splitPath(name)
+	for _, section := range n.sections {
+		if section.name == first {
+			return openSubPath(section, name, rest)
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (s *NcaSection) Index() int {
+	return s.index
+}
+
+// IsRomFS returns true for RomFS sections, and false for PFS0 sections
+func (s *NcaSection) IsRomFS() bool {
+	return s.fsHeader.fsType == 0
+}
+
+func (s *NcaSection) Size() int64 {
+	return s.reader.Size()
+}
+
+// ReadAt reads the decrypted section data
+func (s *NcaSection) ReadAt(p []byte, off int64) (int, error) {
+	return s.reader.ReadAt(p, off)
+}
+
+// Files returns the files of the section PFS0/RomFS
+func (s *NcaSection) Files() (fs.FS, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.files != nil {
+		return s.files, nil
+	}
+	if s.IsRomFS() {
+		romfs, err := openSectionRomFS(s.reader, s.fsHeader)
+		if err != nil {
+			return nil, err
+		}
+		s.files = romfs
+		return s.files, nil
+	}
+	hashInfo, err := s.fsHeader.getHashInfo()
+	if err != nil {
+		return nil, err
+	}
+	pfs0, err := newPartition(nil, s.name, io.NewSectionReader(s.reader, int64(hashInfo.pfs0HeaderOffset), int64(hashInfo.pfs0size)), 0)
+	if err != nil {
+		return nil, err
+	}
+	s.files = pfs0
+	return s.files, nil
+}
+
+func (s *NcaSection) Open(name string) (fs.File, error) {
+	files, err := s.Files()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return files.Open(name)
+}
+
+// openSubPath opens the rest of the path in the sub filesystem, errors are reported with the full path
+func openSubPath(fsys fs.FS, name string, rest string) (fs.File, error) {
+	file, err := fsys.Open(rest)
+	if pathErr, ok := err.(*fs.PathError); ok {
+		pathErr.Path = name
+	}
+	return file, err
+}
+
+// splitPath splits the first element of the path, the rest is "." if the path has a single element
+func splitPath(name string) (string, string) {
+	index := strings.Index(name, "/")
+	if index == -1 {
+		return name, "."
+	}
+	return name[:index], name[index+1:]
+}
+
+func isNcaFile(name string) bool {
+	lowerName := strings.ToLower(name)
+	return strings.HasSuffix(lowerName, ".nca") || strings.HasSuffix(lowerName, ".ncz")
+}
+
+type fileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) ModTime() time.Time { return time.Time{} }
+func (i fileInfo) IsDir() bool        { return i.isDir }
+func (i fileInfo) Sys() interface{}   { return nil }
+func (i fileInfo) Mode() fs.FileMode {
+	if i.isDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+type fileHandle struct {
+	*io.SectionReader
+	info fileInfo
+}
+
+func (f *fileHandle) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *fileHandle) Close() error               { return nil }
+
+type dirHandle struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func newDirHandle(name string, entries []fs.DirEntry) *dirHandle {
+	return &dirHandle{info: fileInfo{name: name, isDir: true}, entries: sortDirEntries(entries)}
+}
+
+func sortDirEntries(entries []fs.DirEntry) []fs.DirEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+func (d *dirHandle) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dirHandle) Close() error               { return nil }
+func (d *dirHandle) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *dirHandle) ReadDir(count int) ([]fs.DirEntry, error) {
+	entries := d.entries[d.offset:]
+	if count > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > 0 && count < len(entries) {
+		entries = entries[:count]
+	}
+	d.offset += len(entries)
+	return entries, nil
+}
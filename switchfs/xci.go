@@ -1,7 +1,6 @@
 package switchfs
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"go.uber.org/zap"
@@ -10,88 +9,95 @@ import (
 )
 
 func ReadXciMetadata(filePath string) (map[string]*ContentMetaAttributes, error) {
-	file, err := OpenFile(filePath)
+	container, err := OpenContainer(filePath)
 	if err != nil {
 		return nil, err
 	}
+	defer container.Close()
 
-	defer file.Close()
-
-	header := make([]byte, 0x200)
-	_, err = file.ReadAt(header, 0)
+	if !container.IsXci() {
+		return nil, errors.New("Invalid XCI file, 'HEAD' magic was not found")
+	}
+	metadata, err := readContainerMetadata(container)
 	if err != nil {
 		return nil, err
 	}
-
-	if string(header[0x100:0x104]) != "HEAD" {
-		return nil, errors.New("Invalid XCI headerBytes. Expected 'HEAD', got '" + string(header[:0x4]) + "'")
+	systemUpdateVersion, err := ReadSystemUpdateVersion(container)
+	if err != nil {
+		zap.S().Debugf("Failed to read the system update of [%v] - %v\n", filePath, err)
 	}
+	for _, titleMetadata := range metadata {
+		titleMetadata.SystemUpdateVersion = systemUpdateVersion
+	}
+	return metadata, nil
+}
 
-	rootPartitionOffset := binary.LittleEndian.Uint64(header[0x130:0x138])
-	//rootPartitionSize := binary.LittleEndian.Uint64(header[0x138:0x140])
-
-	rootHfs0, err := readPfs0(file, int64(rootPartitionOffset))
+// ReadSystemUpdateVersion returns the version of the system update in the update partition of the XCI
+// (0 if the partition is empty)
+func ReadSystemUpdateVersion(container *Container) (int, error) {
+	partition, err := container.Partition("update")
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	for _, name := range partition.FileNames() {
+		if !isCnmtNca(name) {
+			continue
+		}
+		cnmtNca, err := partition.OpenNca(name)
+		if err != nil {
+			return 0, err
+		}
+		cnmt, err := readCnmtFile(cnmtNca)
+		if err != nil {
+			return 0, err
+		}
+		if len(cnmt) >= 0x20 && cnmt[0xC] == ContentMetaType_SystemUpdate {
+			return int(binary.LittleEndian.Uint32(cnmt[0x8:0xC])), nil
+		}
 	}
+	return 0, nil
+}
 
-	secureHfs0, secureOffset, err := readSecurePartition(file, rootHfs0, rootPartitionOffset)
+// openPartition returns the partition holding the NCAs - the PFS0 of an NSP, or the secure partition of an XCI
+func openPartition(file io.ReaderAt) (*PFS0, int64, error) {
+	partition, partitionOffset, err := openXciSecurePartition(file)
 	if err != nil {
-		return nil, err
+		partition, err = readPfs0(file, 0x0)
+		partitionOffset = 0
 	}
+	if err != nil || partition == nil {
+		return nil, 0, errors.New("file is not an NSP/NSZ or XCI/XCZ")
+	}
+	return partition, partitionOffset, nil
+}
 
-	contentMap := map[string]*ContentMetaAttributes{}
-
-	for _, pfs0File := range secureHfs0.Files {
-
-		fileOffset := secureOffset + int64(pfs0File.StartOffset)
-
-		if strings.Contains(pfs0File.Name, "cnmt.nca") {
-			_, section, err := openMetaNcaDataSection(file, fileOffset)
-			if err != nil {
-				return nil, err
-			}
-			currPfs0, err := readPfs0(bytes.NewReader(section), 0x0)
-			if err != nil {
-				return nil, err
-			}
-			currCnmt, err := readBinaryCnmt(currPfs0, section)
-			if err != nil {
-				return nil, err
-			}
-
-			if currCnmt.Type == "BASE" || currCnmt.Type == "UPD" {
-				nacp, err := ExtractNacp(currCnmt, file, secureHfs0, secureOffset)
-				if err != nil {
-					zap.S().Debug("Failed to extract nacp [%v]\n", err.Error())
-				}
-				currCnmt.Ncap = nacp
-			}
+func openXciSecurePartition(file io.ReaderAt) (*PFS0, int64, error) {
+	header := make([]byte, 0x200)
+	_, err := file.ReadAt(header, 0)
+	if err != nil {
+		return nil, 0, err
+	}
 
-			contentMap[currCnmt.TitleId] = currCnmt
+	if string(header[0x100:0x104]) != "HEAD" {
+		return nil, 0, errors.New("Invalid XCI headerBytes. Expected 'HEAD', got '" + string(header[:0x4]) + "'")
+	}
 
-		} /* else if strings.Contains(pfs0File.Name, ".cnmt.xml") {
-			xmlBytes := make([]byte, pfs0File.Size)
-			_, err = file.ReadAt(xmlBytes, fileOffset)
-			if err != nil {
-				return nil, err
-			}
+	rootPartitionOffset := binary.LittleEndian.Uint64(header[0x130:0x138])
+	//rootPartitionSize := binary.LittleEndian.Uint64(header[0x138:0x140])
 
-			currCnmt, err := readXmlCnmt(xmlBytes)
-			if err != nil {
-				return nil, err
-			}
-			contentMap[currCnmt.TitleId] = currCnmt
-		}*/
+	rootHfs0, err := readPfs0(file, int64(rootPartitionOffset))
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return contentMap, nil
+	return readSecurePartition(file, rootHfs0, rootPartitionOffset)
 }
 
+// getNcaById returns the NCA (or NCZ) entry named after the id (<id>.nca, <id>.cnmt.nca, <id>.ncz...)
 func getNcaById(hfs0 *PFS0, id string) *fileEntry {
-	for _, fileEntry := range hfs0.Files {
-		if strings.Contains(fileEntry.Name, id) {
-			return &fileEntry
+	for i, fileEntry := range hfs0.Files {
+		if isNcaFile(fileEntry.Name) && strings.SplitN(fileEntry.Name, ".", 2)[0] == id {
+			return &hfs0.Files[i]
 		}
 	}
 	return nil
@@ -0,0 +1,105 @@
+package switchfs
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+//https://switchbrew.org/wiki/XCI
+
+const (
+	xciPageSize            = 0x200
+	xciRootPartitionOffset = 0xF000
+	xciHashedRegionSize    = 0x200
+)
+
+// gamecard size (rom size byte) by capacity
+var xciRomSizes = []struct {
+	size    int64
+	romSize byte
+}{
+	{1 << 30, 0xFA},
+	{2 << 30, 0xF8},
+	{4 << 30, 0xF0},
+	{8 << 30, 0xE0},
+	{16 << 30, 0xE1},
+	{32 << 30, 0xE2},
+}
+
+// NewXciReader lays out an XCI with the given files in the secure partition (the update and normal partitions are
+// empty), and exposes it as a reader. The header is not signed, and the gamecard info is not set, so the XCI
+// can't be written to a real gamecard, but is accepted by installers and emulators
+func NewXciReader(secureFiles []PartitionFile) (*io.SectionReader, error) {
+	names := map[string]bool{}
+	entries := make([]partitionEntry, len(secureFiles))
+	for i, file := range secureFiles {
+		if file.Name == "" || names[file.Name] || file.Size < 0 || file.Reader == nil {
+			return nil, errors.New("invalid file [" + file.Name + "]")
+		}
+		names[file.Name] = true
+		entries[i] = partitionEntry{name: file.Name, size: file.Size, reader: file.Reader, hashedRegionSize: xciHashedRegionSize}
+	}
+
+	var rootEntries []partitionEntry
+	for _, partition := range []struct {
+		name    string
+		entries []partitionEntry
+	}{{"update", nil}, {"normal", nil}, {"secure", entries}} {
+		builder, err := newPartitionBuilder(hfs0Magic, partition.entries, getAlignedStringTableSize(partition.entries))
+		if err != nil {
+			return nil, err
+		}
+		rootEntries = append(rootEntries, partitionEntry{name: partition.name, size: builder.Size(), reader: builder,
+			hashedRegionSize: uint32(builder.HeaderSize())})
+	}
+	root, err := newPartitionBuilder(hfs0Magic, rootEntries, getAlignedStringTableSize(rootEntries))
+	if err != nil {
+		return nil, err
+	}
+
+	size := xciRootPartitionOffset + root.Size()
+	secureOffset := xciRootPartitionOffset + root.HeaderSize() + rootEntries[0].size + rootEntries[1].size
+	header := make([]byte, xciRootPartitionOffset)
+	copy(header[0x100:0x104], "HEAD")
+	binary.LittleEndian.PutUint32(header[0x104:0x108], uint32(secureOffset/xciPageSize))
+	binary.LittleEndian.PutUint32(header[0x108:0x10C], 0xFFFFFFFF)
+	header[0x10D] = getXciRomSize(size)
+	rand.Read(header[0x110:0x118])
+	binary.LittleEndian.PutUint64(header[0x118:0x120], uint64((size+xciPageSize-1)/xciPageSize-1))
+	binary.LittleEndian.PutUint64(header[0x130:0x138], xciRootPartitionOffset)
+	binary.LittleEndian.PutUint64(header[0x138:0x140], uint64(root.HeaderSize()))
+	rootHeaderHash := sha256.Sum256(root.header)
+	copy(header[0x140:0x160], rootHeaderHash[:])
+	binary.LittleEndian.PutUint32(header[0x180:0x184], 1)
+	binary.LittleEndian.PutUint32(header[0x184:0x188], 2)
+	binary.LittleEndian.PutUint32(header[0x18C:0x190], uint32(secureOffset/xciPageSize))
+
+	reader := &multiReaderAt{size: size, parts: []readerPart{
+		{offset: 0, size: xciRootPartitionOffset, reader: byteReaderAt(header)},
+		{offset: xciRootPartitionOffset, size: root.Size(), reader: root},
+	}}
+	return io.NewSectionReader(reader, 0, size), nil
+}
+
+// getAlignedStringTableSize returns the size of the string table that aligns the HFS0 header to the page size
+// (the NCA sizes are aligned as well, so all the files start on a page)
+func getAlignedStringTableSize(entries []partitionEntry) uint32 {
+	size := 0x10 + HfsfileEntryTableSize*len(entries)
+	for _, entry := range entries {
+		size += len(entry.name) + 1
+	}
+	alignedSize := (size + xciPageSize - 1) / xciPageSize * xciPageSize
+	return uint32(alignedSize - 0x10 - HfsfileEntryTableSize*len(entries))
+}
+
+func getXciRomSize(size int64) byte {
+	for _, romSize := range xciRomSizes {
+		if size <= getXciUntrimmedSize(romSize.size) {
+			return romSize.romSize
+		}
+	}
+	return xciRomSizes[len(xciRomSizes)-1].romSize
+}
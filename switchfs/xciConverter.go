@@ -0,0 +1,500 @@
+package switchfs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/giwty/switch-library-manager/switchfs/_crypto"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	NcaDistributionType_Download = 0
+	NcaDistributionType_GameCard = 1
+)
+
+// ncaTarget is the layout of the NCAs in the output file
+type ncaTarget struct {
+	distribution byte
+	xci          bool //gamecards don't hold tickets (titlekey crypto is replaced by the key area) or a cnmt.xml
+}
+
+var (
+	nspTarget = ncaTarget{distribution: NcaDistributionType_Download}
+	xciTarget = ncaTarget{distribution: NcaDistributionType_GameCard, xci: true}
+)
+
+// ConvertedNsp is the standalone NSP of a title (application, patch or add-on content) of an XCI or a multi-content NSP
+type ConvertedNsp struct {
+	*io.SectionReader
+	TitleId string
+	Version int
+	Type    string
+}
+
+// convertedTitle holds the state of a title conversion, the cnmt NCA and the cnmt.xml are built
+// after the NCAs were read (the hashes of the NCAs switched to download are computed while they are read)
+type convertedTitle struct {
+	partition   *Partition
+	target      ncaTarget
+	cnmtNca     *Nca
+	cnmt        []byte
+	contents    []Content
+	ncas        []*convertedNca
+	newCnmtNca  *lazyReaderAt
+	newMetaHash string
+}
+
+// convertedNca is an NCA with the header patched for the target (if needed),
+// the new hash is computed while the NCA is read sequentially
+type convertedNca struct {
+	sync.Mutex
+	reader        io.ReaderAt
+	size          int64
+	contentIndex  int
+	keyGeneration byte
+	patched       bool
+	hash          hash.Hash
+	hashed        int64
+	sum           string
+}
+
+// ConvertXciToNsp lays out a standalone NSP for every title in the XCI secure partition: the NCAs of the title,
+// the cnmt NCA, a regenerated cnmt.xml and the tickets/certs (if present), NCZ files are decompressed.
+// NCAs with the gamecard distribution type are switched to download, which changes their hashes, so the content
+// records of the cnmt are updated as well (the NCA header signature is no longer valid after the change).
+// The data is read on demand, reading the NSP sequentially avoids hashing the patched NCAs twice
+func ConvertXciToNsp(container *Container) ([]*ConvertedNsp, error) {
+	if !container.IsXci() {
+		return nil, errors.New("file is not an XCI/XCZ")
+	}
+	result, err := convertTitles(container.NcaPartition(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no titles found in the secure partition")
+	}
+	return result, nil
+}
+
+// SplitNsp lays out a standalone NSP for every title in a multi-content NSP/NSZ, each NSP holds only the NCAs
+// referenced by the cnmt of the title (NCZ files are decompressed)
+func SplitNsp(container *Container) ([]*ConvertedNsp, error) {
+	if container.IsXci() {
+		return nil, errors.New("file is not an NSP/NSZ")
+	}
+	result, err := convertTitles(container.NcaPartition(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) < 2 {
+		return nil, errors.New("file is not a multi-content NSP")
+	}
+	return result, nil
+}
+
+// convertTitles lays out an NSP for every title in the partition that matches the filter (all titles if nil)
+func convertTitles(partition *Partition, filter func(metadata *ContentMetaAttributes) bool) ([]*ConvertedNsp, error) {
+	var result []*ConvertedNsp
+	for _, name := range partition.FileNames() {
+		if !isCnmtNca(name) {
+			continue
+		}
+		metadata, files, err := convertTitle(partition, name, nspTarget, filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert [%v] - %v", name, err)
+		}
+		if metadata == nil {
+			continue
+		}
+		reader, err := NewPfs0Reader(files)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &ConvertedNsp{SectionReader: reader, TitleId: metadata.TitleId, Version: metadata.Version, Type: metadata.Type})
+	}
+	return result, nil
+}
+
+// convertTitle returns the files of the title (the NCAs, the cnmt NCA, the cnmt.xml and the tickets/certs),
+// the metadata is nil if the title doesn't match the filter
+func convertTitle(partition *Partition, cnmtNcaName string, target ncaTarget,
+	filter func(metadata *ContentMetaAttributes) bool) (*ContentMetaAttributes, []PartitionFile, error) {
+	cnmtNca, err := partition.OpenNca(cnmtNcaName)
+	if err != nil {
+		return nil, nil, err
+	}
+	cnmt, err := readCnmtFile(cnmtNca)
+	if err != nil {
+		return nil, nil, err
+	}
+	metadata, err := readBinaryCnmt(cnmt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if filter != nil && !filter(metadata) {
+		return nil, nil, nil
+	}
+	title := &convertedTitle{partition: partition, target: target, cnmtNca: cnmtNca, cnmt: cnmt, contents: readCnmtContents(cnmt)}
+
+	var files []PartitionFile
+	rightsIds := map[string]bool{}
+	for i, content := range title.contents {
+		entry := getNcaById(partition.pfs0, content.ID)
+		if entry == nil {
+			//delta fragments are not required for installation
+			if content.Type == "DeltaFragment" {
+				continue
+			}
+			return nil, nil, fmt.Errorf("%v NCA [%v] is missing", content.Type, content.ID)
+		}
+		nca, err := partition.OpenNca(entry.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		converted, err := newConvertedNca(nca, i, target)
+		if err != nil {
+			return nil, nil, err
+		}
+		title.ncas = append(title.ncas, converted)
+		if nca.header.HasRightsId() {
+			rightsIds[hex.EncodeToString(nca.header.rightsId)] = true
+		}
+		files = append(files, PartitionFile{Name: content.ID + ".nca", Size: converted.size, Reader: converted})
+	}
+
+	//the sizes don't depend on the new hashes, so the header can be built before the NCAs are read
+	cnmtNcaId := strings.SplitN(cnmtNca.Name(), ".", 2)[0]
+	title.newCnmtNca = &lazyReaderAt{build: title.buildCnmtNca}
+	files = append(files, PartitionFile{Name: cnmtNcaId + ".cnmt.nca", Size: cnmtNca.Size(), Reader: title.newCnmtNca})
+	if target.xci {
+		return metadata, files, nil
+	}
+	cnmtXml, err := title.buildCnmtXml(true)
+	if err != nil {
+		return nil, nil, err
+	}
+	files = append(files, PartitionFile{Name: cnmtNcaId + ".cnmt.xml", Size: int64(len(cnmtXml)),
+		Reader: &lazyReaderAt{build: func() ([]byte, error) {
+			data, err := title.buildCnmtXml(false)
+			if err == nil && len(data) != len(cnmtXml) {
+				err = errors.New("unexpected cnmt.xml size")
+			}
+			return data, err
+		}}})
+
+	for _, name := range partition.FileNames() {
+		lowerName := strings.ToLower(name)
+		for rightsId := range rightsIds {
+			if lowerName == rightsId+".tik" || lowerName == rightsId+".cert" {
+				file, err := partition.OpenFile(name)
+				if err != nil {
+					return nil, nil, err
+				}
+				files = append(files, PartitionFile{Name: name, Size: file.Size(), Reader: file})
+			}
+		}
+	}
+	return metadata, files, nil
+}
+
+func newConvertedNca(nca *Nca, contentIndex int, target ncaTarget) (*convertedNca, error) {
+	result := &convertedNca{reader: nca, size: nca.Size(), contentIndex: contentIndex,
+		keyGeneration: max(nca.header.keyGeneration1, nca.header.keyGeneration2)}
+	headerBytes, patched, err := patchNcaHeader(nca, target)
+	if err != nil || !patched {
+		return result, err
+	}
+	header, err := encryptNcaHeader(headerBytes)
+	if err != nil {
+		return nil, err
+	}
+	result.patched = true
+	result.hash = sha256.New()
+	result.reader = &multiReaderAt{size: nca.Size(), parts: []readerPart{
+		{offset: 0, size: int64(len(header)), reader: byteReaderAt(header)},
+		{offset: int64(len(header)), size: nca.Size() - int64(len(header)), reader: io.NewSectionReader(nca, int64(len(header)), nca.Size()-int64(len(header)))},
+	}}
+	return result, nil
+}
+
+// patchNcaHeader returns the (decrypted) NCA header with the distribution type of the target, for gamecards
+// the rights id is removed, and the title key is stored in the key area instead (the sections are not changed)
+func patchNcaHeader(nca *Nca, target ncaTarget) ([]byte, bool, error) {
+	headerBytes := append([]byte{}, nca.header.headerBytes...)
+	patched := false
+	if headerBytes[0x204] != target.distribution {
+		headerBytes[0x204] = target.distribution
+		patched = true
+	}
+	if target.xci && nca.header.HasRightsId() {
+		var titleKey []byte
+		for _, section := range nca.sections {
+			if section.reader.key != nil {
+				titleKey = section.reader.key
+				break
+			}
+		}
+		if titleKey == nil {
+			return nil, false, errors.New("title key not found")
+		}
+		keyName, err := getKeyAreaKeyName(NcaKeyAreaKey_Application, nca.header.getKeyRevision())
+		if err != nil {
+			return nil, false, err
+		}
+		key, err := getKey(keyName)
+		if err != nil {
+			return nil, false, err
+		}
+		headerBytes[0x207] = NcaKeyAreaKey_Application
+		copy(headerBytes[0x230:0x240], make([]byte, 0x10))
+		//the AES-CTR key is the third key of the key area
+		copy(headerBytes[0x320:0x330], _crypto.EncryptAes128Ecb(titleKey, key))
+		patched = true
+	}
+	return headerBytes, patched, nil
+}
+
+func (c *convertedNca) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.reader.ReadAt(p, off)
+	if c.patched {
+		c.Lock()
+		//parts that were already hashed may be read again (e.g. the HFS0 hashed region)
+		if off <= c.hashed && off+int64(n) > c.hashed && c.sum == "" {
+			c.hash.Write(p[c.hashed-off : n])
+			c.hashed = off + int64(n)
+		}
+		c.Unlock()
+	}
+	return n, err
+}
+
+// getHash returns the hash of the NCA, the NCA is hashed again if it was not read sequentially
+func (c *convertedNca) getHash(originalHash string) (string, error) {
+	if !c.patched {
+		return originalHash, nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.sum != "" {
+		return c.sum, nil
+	}
+	if c.hashed == c.size {
+		c.sum = hex.EncodeToString(c.hash.Sum(nil))
+		return c.sum, nil
+	}
+	ncaHash := sha256.New()
+	_, err := io.CopyBuffer(ncaHash, io.NewSectionReader(c.reader, 0, c.size), make([]byte, partitionCopyBufferSize))
+	if err != nil {
+		return "", err
+	}
+	c.sum = hex.EncodeToString(ncaHash.Sum(nil))
+	return c.sum, nil
+}
+
+// buildCnmtNca updates the content records of the cnmt with the new NCA hashes, and rebuilds the cnmt NCA
+// (the cnmt NCA is copied as is if no NCA was patched)
+func (t *convertedTitle) buildCnmtNca() ([]byte, error) {
+	data := make([]byte, t.cnmtNca.Size())
+	_, err := t.cnmtNca.ReadAt(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	_, patched, err := patchNcaHeader(t.cnmtNca, t.target)
+	if err != nil {
+		return nil, err
+	}
+	tableOffset := binary.LittleEndian.Uint16(t.cnmt[0xE:0x10])
+	newCnmt := append([]byte{}, t.cnmt...)
+	for _, nca := range t.ncas {
+		if !nca.patched {
+			continue
+		}
+		ncaHash, err := nca.getHash(t.contents[nca.contentIndex].Hash)
+		if err != nil {
+			return nil, err
+		}
+		hashBytes, _ := hex.DecodeString(ncaHash)
+		position := 0x20 + int(tableOffset) + nca.contentIndex*0x38
+		copy(newCnmt[position:position+0x20], hashBytes)
+		patched = true
+	}
+	if patched {
+		data, err = rebuildCnmtNca(t.cnmtNca, data, newCnmt, t.target)
+		if err != nil {
+			return nil, err
+		}
+	}
+	metaHash := sha256.Sum256(data)
+	t.newMetaHash = hex.EncodeToString(metaHash[:])
+	return data, nil
+}
+
+// rebuildCnmtNca replaces the cnmt in the (encrypted) cnmt NCA data, the section hashes are recomputed,
+// the section is encrypted again, and the header is patched for the target
+func rebuildCnmtNca(cnmtNca *Nca, data []byte, cnmt []byte, target ncaTarget) ([]byte, error) {
+	section, err := cnmtNca.Section(0)
+	if err != nil {
+		return nil, err
+	}
+	sectionData := make([]byte, section.Size())
+	_, err = section.ReadAt(sectionData, 0)
+	if err != nil {
+		return nil, err
+	}
+	hashInfo, err := section.fsHeader.getHashInfo()
+	if err != nil {
+		return nil, err
+	}
+	pfs0, err := readPfs0(byteReaderAt(sectionData), int64(hashInfo.pfs0HeaderOffset))
+	if err != nil {
+		return nil, err
+	}
+	if len(pfs0.Files) != 1 || pfs0.Files[0].Size != uint64(len(cnmt)) {
+		return nil, errors.New("unexpected pfs0")
+	}
+	copy(sectionData[hashInfo.pfs0HeaderOffset+pfs0.Files[0].StartOffset:], cnmt)
+
+	//the master hash is updated in a copy of the fs header
+	headerBytes, _, err := patchNcaHeader(cnmtNca, target)
+	if err != nil {
+		return nil, err
+	}
+	fsHeaderBytes := headerBytes[0x400 : 0x400+0x200]
+	fsHeader := &fsHeader{fsHeaderBytes: fsHeaderBytes, hashType: section.fsHeader.hashType}
+	tree, err := fsHeader.getHashTree()
+	if err != nil {
+		return nil, err
+	}
+	err = rebuildHashTree(sectionData, tree)
+	if err != nil {
+		return nil, err
+	}
+	fsHeaderHash := sha256.Sum256(fsHeaderBytes)
+	copy(headerBytes[0x280:0x2A0], fsHeaderHash[:])
+
+	if section.reader.key != nil {
+		stream := newAesCtrStream(section.reader.key, section.reader.counter, section.reader.start)
+		stream.XORKeyStream(sectionData, sectionData)
+	}
+	copy(data[section.reader.start:], sectionData)
+	encryptedHeader, err := encryptNcaHeader(headerBytes)
+	if err != nil {
+		return nil, err
+	}
+	copy(data, encryptedHeader)
+	return data, nil
+}
+
+// buildCnmtXml generates the cnmt.xml of the title from the binary cnmt and the NCA headers, the original hashes
+// are used when only the size is needed (the hashes have a fixed length)
+func (t *convertedTitle) buildCnmtXml(sizeOnly bool) ([]byte, error) {
+	metaHash := strings.Repeat("0", sha256.Size*2)
+	if !sizeOnly {
+		_, err := t.newCnmtNca.get()
+		if err != nil {
+			return nil, err
+		}
+		metaHash = t.newMetaHash
+	}
+	attributes, err := readBinaryCnmt(t.cnmt)
+	if err != nil {
+		return nil, err
+	}
+	contentMeta := ContentMeta{
+		Type:                          getContentMetaTypeName(attributes.MetaType),
+		ID:                            fmt.Sprintf("0x%016x", binary.LittleEndian.Uint64(t.cnmt[0x0:0x8])),
+		Version:                       attributes.Version,
+		RequiredDownloadSystemVersion: fmt.Sprintf("%v", attributes.RequiredDownloadSystemVersion),
+		Digest:                        attributes.Digest,
+	}
+	for _, nca := range t.ncas {
+		content := t.contents[nca.contentIndex]
+		ncaHash := content.Hash
+		if !sizeOnly {
+			var err error
+			ncaHash, err = nca.getHash(content.Hash)
+			if err != nil {
+				return nil, err
+			}
+		}
+		contentMeta.Content = append(contentMeta.Content, Content{Type: content.Type, ID: content.ID, Size: content.Size,
+			Hash: ncaHash, KeyGeneration: fmt.Sprintf("%v", nca.keyGeneration)})
+	}
+	metaKeyGeneration := fmt.Sprintf("%v", max(t.cnmtNca.header.keyGeneration1, t.cnmtNca.header.keyGeneration2))
+	contentMeta.Content = append(contentMeta.Content, Content{Type: "Meta", ID: strings.SplitN(t.cnmtNca.Name(), ".", 2)[0],
+		Size: fmt.Sprintf("%v", t.cnmtNca.Size()), Hash: metaHash, KeyGeneration: metaKeyGeneration})
+	contentMeta.KeyGenerationMin = metaKeyGeneration
+
+	switch attributes.MetaType {
+	case ContentMetaType_Application:
+		contentMeta.PatchId = "0x" + attributes.PatchId
+		contentMeta.RequiredSystemVersion = fmt.Sprintf("%v", attributes.RequiredSystemVersion)
+	case ContentMetaType_Patch:
+		contentMeta.ApplicationId = "0x" + attributes.ApplicationId
+		contentMeta.RequiredSystemVersion = fmt.Sprintf("%v", attributes.RequiredSystemVersion)
+	case ContentMetaType_AddOnContent:
+		contentMeta.ApplicationId = "0x" + attributes.ApplicationId
+		contentMeta.RequiredApplicationVersion = fmt.Sprintf("%v", attributes.RequiredApplicationVersion)
+	}
+
+	xmlBytes, err := xml.MarshalIndent(contentMeta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), xmlBytes...), nil
+}
+
+func getContentMetaTypeName(metaType byte) string {
+	switch metaType {
+	case ContentMetaType_SystemProgram:
+		return "SystemProgram"
+	case ContentMetaType_SystemData:
+		return "SystemData"
+	case ContentMetaType_SystemUpdate:
+		return "SystemUpdate"
+	case ContentMetaType_BootImagePackage:
+		return "BootImagePackage"
+	case ContentMetaType_BootImagePackageSafe:
+		return "BootImagePackageSafe"
+	case ContentMetaType_Application:
+		return "Application"
+	case ContentMetaType_Patch:
+		return "Patch"
+	case ContentMetaType_AddOnContent:
+		return "AddOnContent"
+	case ContentMetaType_Delta:
+		return "Delta"
+	}
+	return "Unknown"
+}
+
+// lazyReaderAt builds the data on the first read
+type lazyReaderAt struct {
+	sync.Once
+	build func() ([]byte, error)
+	data  []byte
+	err   error
+}
+
+func (l *lazyReaderAt) get() ([]byte, error) {
+	l.Do(func() {
+		l.data, l.err = l.build()
+	})
+	return l.data, l.err
+}
+
+func (l *lazyReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	data, err := l.get()
+	if err != nil {
+		return 0, err
+	}
+	return byteReaderAt(data).ReadAt(p, off)
+}
@@ -0,0 +1,302 @@
+package switchfs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+//https://switchbrew.org/wiki/Gamecard_Format
+
+const (
+	xciHeaderSize  = 0x200
+	xciPaddingByte = 0xFF
+)
+
+// gamecard flags
+const (
+	XciFlag_AutoBoot                         = 1 << 0
+	XciFlag_HistoryErase                     = 1 << 1
+	XciFlag_RepairTool                       = 1 << 2
+	XciFlag_DifferentRegionCupToTerraDevice  = 1 << 3
+	XciFlag_DifferentRegionCupToGlobalDevice = 1 << 4
+)
+
+// XciHeader is the gamecard header, the extended header is only available if xci_header_key is in prod.keys
+type XciHeader struct {
+	RomAreaStartPage         uint32
+	BackupAreaStartPage      uint32
+	KekIndex                 byte
+	TitleKeyDecIndex         byte
+	RomSize                  byte
+	HeaderVersion            byte
+	Flags                    byte
+	PackageId                uint64
+	ValidDataEndPage         uint32
+	Iv                       []byte
+	PartitionFsHeaderAddress uint64
+	PartitionFsHeaderSize    uint64
+	PartitionFsHeaderHash    []byte
+	InitialDataHash          []byte
+	SelSec                   uint32
+	SelT1Key                 uint32
+	SelKey                   uint32
+	LimAreaPage              uint32
+	ExtendedHeader           *XciExtendedHeader
+}
+
+// XciExtendedHeader is the encrypted part of the gamecard header
+type XciExtendedHeader struct {
+	FwVersion         uint64
+	AccCtrl1          uint32
+	Wait1TimeRead     uint32
+	Wait2TimeRead     uint32
+	Wait1TimeWrite    uint32
+	Wait2TimeWrite    uint32
+	FwMode            uint32
+	UppVersion        uint32
+	CompatibilityType byte
+	UppHash           []byte
+	UppId             uint64
+}
+
+// XciTrimInfo describes the padding of an XCI, the data ends at the valid data end page of the header,
+// an untrimmed XCI is padded (with 0xFF) to the size of the gamecard
+type XciTrimInfo struct {
+	FileSize        int64
+	DataSize        int64
+	UntrimmedSize   int64
+	Trimmed         bool
+	ReclaimableSize int64
+}
+
+// ReadXciHeader reads the gamecard header, the extended header is decrypted if xci_header_key is available
+func ReadXciHeader(reader io.ReaderAt) (*XciHeader, error) {
+	headerBytes := make([]byte, xciHeaderSize)
+	_, err := reader.ReadAt(headerBytes, 0)
+	if err != nil {
+		return nil, errors.New("failed to read XCI header " + err.Error())
+	}
+	return parseXciHeader(headerBytes)
+}
+
+func parseXciHeader(headerBytes []byte) (*XciHeader, error) {
+	if len(headerBytes) < xciHeaderSize || string(headerBytes[0x100:0x104]) != "HEAD" {
+		return nil, errors.New("Invalid XCI header, 'HEAD' magic was not found")
+	}
+	header := &XciHeader{
+		RomAreaStartPage:         binary.LittleEndian.Uint32(headerBytes[0x104:0x108]),
+		BackupAreaStartPage:      binary.LittleEndian.Uint32(headerBytes[0x108:0x10C]),
+		KekIndex:                 headerBytes[0x10C] & 0xF,
+		TitleKeyDecIndex:         headerBytes[0x10C] >> 4,
+		RomSize:                  headerBytes[0x10D],
+		HeaderVersion:            headerBytes[0x10E],
+		Flags:                    headerBytes[0x10F],
+		PackageId:                binary.LittleEndian.Uint64(headerBytes[0x110:0x118]),
+		ValidDataEndPage:         binary.LittleEndian.Uint32(headerBytes[0x118:0x11C]),
+		Iv:                       headerBytes[0x120:0x130],
+		PartitionFsHeaderAddress: binary.LittleEndian.Uint64(headerBytes[0x130:0x138]),
+		PartitionFsHeaderSize:    binary.LittleEndian.Uint64(headerBytes[0x138:0x140]),
+		PartitionFsHeaderHash:    headerBytes[0x140:0x160],
+		InitialDataHash:          headerBytes[0x160:0x180],
+		SelSec:                   binary.LittleEndian.Uint32(headerBytes[0x180:0x184]),
+		SelT1Key:                 binary.LittleEndian.Uint32(headerBytes[0x184:0x188]),
+		SelKey:                   binary.LittleEndian.Uint32(headerBytes[0x188:0x18C]),
+		LimAreaPage:              binary.LittleEndian.Uint32(headerBytes[0x18C:0x190]),
+	}
+	key, err := getKey("xci_header_key")
+	if err == nil && len(key) == 0x10 {
+		header.ExtendedHeader = decryptXciExtendedHeader(headerBytes[0x190:0x200], header.Iv, key)
+	}
+	return header, nil
+}
+
+// decryptXciExtendedHeader decrypts the extended header with AES-CBC, the IV is stored reversed
+func decryptXciExtendedHeader(data []byte, iv []byte, key []byte) *XciExtendedHeader {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil
+	}
+	reversedIv := make([]byte, len(iv))
+	for i := range iv {
+		reversedIv[i] = iv[len(iv)-1-i]
+	}
+	decrypted := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, reversedIv).CryptBlocks(decrypted, data)
+	return &XciExtendedHeader{
+		FwVersion:         binary.LittleEndian.Uint64(decrypted[0x0:0x8]),
+		AccCtrl1:          binary.LittleEndian.Uint32(decrypted[0x8:0xC]),
+		Wait1TimeRead:     binary.LittleEndian.Uint32(decrypted[0xC:0x10]),
+		Wait2TimeRead:     binary.LittleEndian.Uint32(decrypted[0x10:0x14]),
+		Wait1TimeWrite:    binary.LittleEndian.Uint32(decrypted[0x14:0x18]),
+		Wait2TimeWrite:    binary.LittleEndian.Uint32(decrypted[0x18:0x1C]),
+		FwMode:            binary.LittleEndian.Uint32(decrypted[0x1C:0x20]),
+		UppVersion:        binary.LittleEndian.Uint32(decrypted[0x20:0x24]),
+		CompatibilityType: decrypted[0x24],
+		UppHash:           decrypted[0x28:0x30],
+		UppId:             binary.LittleEndian.Uint64(decrypted[0x30:0x38]),
+	}
+}
+
+// CardSize returns the capacity of the gamecard (e.g. 8GB), or 0 for an unknown rom size
+func (h *XciHeader) CardSize() int64 {
+	for _, romSize := range xciRomSizes {
+		if romSize.romSize == h.RomSize {
+			return romSize.size
+		}
+	}
+	return 0
+}
+
+// UntrimmedSize returns the size of a full dump of the gamecard (72MB per GB are not usable)
+func (h *XciHeader) UntrimmedSize() int64 {
+	return getXciUntrimmedSize(h.CardSize())
+}
+
+func getXciUntrimmedSize(cardSize int64) int64 {
+	return cardSize - (cardSize>>30)*0x48*0x100000
+}
+
+// DataSize returns the size of the data on the gamecard (the end of the valid data)
+func (h *XciHeader) DataSize() int64 {
+	return (int64(h.ValidDataEndPage) + 1) * xciPageSize
+}
+
+// ClockRate returns the gamecard bus clock rate in MHz
+func (e *XciExtendedHeader) ClockRate() int {
+	if e.AccCtrl1 == 0x00A10010 {
+		return 50
+	}
+	return 25
+}
+
+// GetXciTrimInfo returns the trimmed status of the XCI file
+func GetXciTrimInfo(filePath string) (*XciTrimInfo, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header, err := ReadXciHeader(file)
+	if err != nil {
+		return nil, err
+	}
+	return getXciTrimInfo(header, info.Size())
+}
+
+func getXciTrimInfo(header *XciHeader, fileSize int64) (*XciTrimInfo, error) {
+	if header.CardSize() == 0 {
+		return nil, fmt.Errorf("unknown gamecard size [0x%x]", header.RomSize)
+	}
+	result := &XciTrimInfo{FileSize: fileSize, DataSize: header.DataSize(), UntrimmedSize: header.UntrimmedSize()}
+	if result.DataSize > result.UntrimmedSize {
+		return nil, errors.New("the valid data end is beyond the gamecard size")
+	}
+	if fileSize < result.DataSize {
+		return nil, fmt.Errorf("the file is truncated, expected at least [%v] bytes", result.DataSize)
+	}
+	result.Trimmed = fileSize < result.UntrimmedSize
+	result.ReclaimableSize = fileSize - result.DataSize
+	return result, nil
+}
+
+// TrimXci removes the padding after the valid data end, the padding is checked before the file is truncated,
+// the number of bytes removed is returned
+func TrimXci(filePath string) (int64, error) {
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	header, err := ReadXciHeader(file)
+	if err != nil {
+		return 0, err
+	}
+	trimInfo, err := getXciTrimInfo(header, info.Size())
+	if err != nil {
+		return 0, err
+	}
+	if trimInfo.ReclaimableSize == 0 {
+		return 0, nil
+	}
+
+	//make sure no data is removed, the data of all partitions must end before the valid data end
+	rootPartition, err := readPfs0(file, int64(header.PartitionFsHeaderAddress))
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range rootPartition.Files {
+		end := int64(header.PartitionFsHeaderAddress) + int64(entry.StartOffset) + int64(entry.Size)
+		if end > trimInfo.DataSize {
+			return 0, fmt.Errorf("partition [%v] ends after the valid data end", entry.Name)
+		}
+	}
+	buffer := make([]byte, partitionCopyBufferSize)
+	for offset := trimInfo.DataSize; offset < trimInfo.FileSize; offset += int64(len(buffer)) {
+		n, err := file.ReadAt(buffer, offset)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i, b := range buffer[:n] {
+			if b != xciPaddingByte {
+				return 0, fmt.Errorf("unexpected data in the padding at [0x%x]", offset+int64(i))
+			}
+		}
+	}
+
+	err = file.Truncate(trimInfo.DataSize)
+	if err != nil {
+		return 0, err
+	}
+	return trimInfo.ReclaimableSize, nil
+}
+
+// UntrimXci restores the padding of the XCI (to the size of a full dump of the gamecard),
+// the number of bytes added is returned
+func UntrimXci(filePath string) (int64, error) {
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	header, err := ReadXciHeader(file)
+	if err != nil {
+		return 0, err
+	}
+	trimInfo, err := getXciTrimInfo(header, info.Size())
+	if err != nil {
+		return 0, err
+	}
+	if !trimInfo.Trimmed {
+		return 0, nil
+	}
+	padding := make([]byte, partitionCopyBufferSize)
+	for i := range padding {
+		padding[i] = xciPaddingByte
+	}
+	for offset := trimInfo.FileSize; offset < trimInfo.UntrimmedSize; offset += int64(len(padding)) {
+		length := min64(int64(len(padding)), trimInfo.UntrimmedSize-offset)
+		_, err = file.WriteAt(padding[:length], offset)
+		if err != nil {
+			file.Truncate(trimInfo.FileSize)
+			return 0, err
+		}
+	}
+	return trimInfo.UntrimmedSize - trimInfo.FileSize, nil
+}
@@ -0,0 +1,80 @@
+package switchfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrimXci(t *testing.T) {
+	files := []PartitionFile{
+		{Name: "0123456789abcdef0123456789abcdef.nca", Size: 0x1400, Reader: bytes.NewReader(syntheticNca(0x1400, 0x1))},
+	}
+	xci, err := NewXciReader(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePath := filepath.Join(t.TempDir(), "game.xci")
+	output, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(output, xci)
+	output.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trimInfo, err := GetXciTrimInfo(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trimInfo.Trimmed || trimInfo.DataSize != xci.Size() || trimInfo.UntrimmedSize != 952*0x100000 || trimInfo.ReclaimableSize != 0 {
+		t.Fatalf("unexpected trim info %+v", trimInfo)
+	}
+
+	added, err := UntrimXci(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != trimInfo.UntrimmedSize-xci.Size() {
+		t.Fatalf("expected %v bytes of padding, got %v", trimInfo.UntrimmedSize-xci.Size(), added)
+	}
+	trimInfo, err = GetXciTrimInfo(filePath)
+	if err != nil || trimInfo.Trimmed || trimInfo.ReclaimableSize != added {
+		t.Fatalf("unexpected trim info %+v %v", trimInfo, err)
+	}
+
+	removed, err := TrimXci(filePath)
+	if err != nil || removed != added {
+		t.Fatalf("expected %v bytes to be removed, got %v %v", added, removed, err)
+	}
+	info, _ := os.Stat(filePath)
+	if info.Size() != xci.Size() {
+		t.Fatalf("expected size %v, got %v", xci.Size(), info.Size())
+	}
+}
+
+func TestTrimXciKeepsData(t *testing.T) {
+	files := []PartitionFile{
+		{Name: "0123456789abcdef0123456789abcdef.nca", Size: 0x1400, Reader: bytes.NewReader(syntheticNca(0x1400, 0x1))},
+	}
+	xci, _ := NewXciReader(files)
+	data := make([]byte, xci.Size())
+	xci.ReadAt(data, 0)
+	data = append(data, bytes.Repeat([]byte{xciPaddingByte}, 0x1000)...)
+	data[len(data)-0x10] = 0
+	filePath := filepath.Join(t.TempDir(), "game.xci")
+	os.WriteFile(filePath, data, 0644)
+
+	_, err := TrimXci(filePath)
+	if err == nil {
+		t.Fatal("expected the padding check to fail")
+	}
+	info, _ := os.Stat(filePath)
+	if info.Size() != int64(len(data)) {
+		t.Fatal("the file was truncated")
+	}
+}